@@ -1,11 +1,15 @@
 module github.com/wellknittech/hayanix
 
-go 1.21
+go 1.22
 
 require (
 	github.com/alecthomas/kong v0.8.1
 	github.com/go-yaml/yaml v2.1.0+incompatible
+	github.com/klauspost/compress v1.18.0
 	github.com/olekukonko/tablewriter v0.0.5
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.9.0
+	golang.org/x/sys v0.8.0
 )
 
 require (
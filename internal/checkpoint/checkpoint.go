@@ -0,0 +1,352 @@
+package checkpoint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+// headSize is how much of the start of a file its checkpoint hashes, to tell
+// a file rewritten in place or a reused inode from the file checkpointed.
+const headSize = 4096
+
+// Checkpoint records how far a log file was analyzed.
+type Checkpoint struct {
+	// Offset is where the next unanalyzed line starts.
+	Offset int64 `json:"offset"`
+	// Held are the lines before Offset that make up entries later lines
+	// could still add to, such as an audit event missing records. They
+	// are parsed again ahead of the next line, see parser.Resumer.
+	Held []string `json:"held,omitempty"`
+	// Device and Inode identify the file, 0 where they are unknown.
+	Device uint64 `json:"device,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+	// HeadHash is the SHA-256 of the first HeadSize bytes of the file.
+	HeadSize int64     `json:"head_size"`
+	HeadHash string    `json:"head_sha256"`
+	Updated  time.Time `json:"updated"`
+}
+
+// State holds the checkpoints of the log files analyzed so far, by path, in a
+// JSON state file. It is safe for concurrent use.
+type State struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]Checkpoint
+}
+
+type stateFile struct {
+	Files map[string]Checkpoint `json:"files"`
+}
+
+// Load reads the state file at path. A missing file is an empty state.
+func Load(path string) (*State, error) {
+	s := &State{path: path, files: make(map[string]Checkpoint)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+
+	var decoded stateFile
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	for file, checkpoint := range decoded.Files {
+		s.files[file] = checkpoint
+	}
+	return s, nil
+}
+
+// Save writes the state file, replacing it atomically. Checkpoints of files
+// that no longer exist are dropped.
+func (s *State) Save() error {
+	s.mu.Lock()
+	files := make(map[string]Checkpoint, len(s.files))
+	for file, checkpoint := range s.files {
+		if _, err := os.Stat(file); err == nil {
+			files[file] = checkpoint
+		}
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(stateFile{Files: files}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// Record checkpoints the file at path, open as file, as analyzed up to
+// offset, apart from the held lines.
+func (s *State) Record(path string, file *os.File, offset int64, held []string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	checkpoint := Checkpoint{Offset: offset, Held: held, Updated: time.Now().UTC()}
+	checkpoint.Device, checkpoint.Inode = fileID(info)
+	checkpoint.HeadSize = min(offset, headSize)
+	if checkpoint.HeadHash, err = headHash(file, checkpoint.HeadSize); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files[key] = checkpoint
+	s.mu.Unlock()
+	return nil
+}
+
+// Resume returns the checkpoint to resume analyzing the file at path from, or
+// an empty one to start over if the file was truncated, rewritten or replaced
+// since. A file rotated to a new name is still recognised by its device and
+// inode. found is false if neither path nor the file was ever checkpointed.
+func (s *State) Resume(path string) (resume Checkpoint, found bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The file's own checkpoint, then one taken under another name
+	candidates := []Checkpoint{}
+	if checkpoint, ok := s.files[key]; ok {
+		candidates = append(candidates, checkpoint)
+	}
+	device, inode := fileID(info)
+	if inode != 0 {
+		for _, other := range s.sortedPaths() {
+			if checkpoint := s.files[other]; other != key && checkpoint.Device == device && checkpoint.Inode == inode {
+				candidates = append(candidates, checkpoint)
+			}
+		}
+	}
+
+	for _, checkpoint := range candidates {
+		if checkpoint.Inode != inode || checkpoint.Device != device {
+			// Replaced by a new file
+			continue
+		}
+		if info.Size() < checkpoint.Offset || !headMatches(path, checkpoint) {
+			// Truncated or rewritten in place
+			continue
+		}
+		return checkpoint, true, nil
+	}
+	return Checkpoint{}, len(candidates) > 0, nil
+}
+
+// Rotated finds the file the checkpoint for path was taken on, if path has
+// since been rotated and the file renamed alongside it, as to auth.log.1, and
+// returns it with the checkpoint to finish analyzing it from. Rotated files
+// that were compressed can't be resumed and aren't found.
+func (s *State) Rotated(path string) (string, Checkpoint, bool) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", Checkpoint{}, false
+	}
+	s.mu.Lock()
+	checkpoint, ok := s.files[key]
+	s.mu.Unlock()
+	if !ok || checkpoint.Inode == 0 {
+		return "", Checkpoint{}, false
+	}
+	if info, err := os.Stat(path); err == nil {
+		if device, inode := fileID(info); device == checkpoint.Device && inode == checkpoint.Inode {
+			// Not rotated
+			return "", Checkpoint{}, false
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return "", Checkpoint{}, false
+	}
+	for _, entry := range entries {
+		if entry.Name() == filepath.Base(path) || !strings.HasPrefix(entry.Name(), filepath.Base(path)) {
+			continue
+		}
+		candidate := filepath.Join(filepath.Dir(path), entry.Name())
+		info, err := os.Stat(candidate)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if device, inode := fileID(info); device != checkpoint.Device || inode != checkpoint.Inode {
+			continue
+		}
+		if info.Size() < checkpoint.Offset || !headMatches(candidate, checkpoint) || compressed(candidate) {
+			return "", Checkpoint{}, false
+		}
+		return candidate, checkpoint, true
+	}
+	return "", Checkpoint{}, false
+}
+
+// sortedPaths lists the checkpointed paths in order, so lookups are
+// deterministic. The caller holds mu.
+func (s *State) sortedPaths() []string {
+	paths := make([]string, 0, len(s.files))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func headHash(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, size)
+	if _, err := r.ReadAt(head, 0); err != nil && !(err == io.EOF && size == 0) {
+		return "", err
+	}
+	digest := sha256.Sum256(head)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// headMatches reports whether the file at path starts as it did when
+// checkpointed.
+func headMatches(path string, checkpoint Checkpoint) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	hash, err := headHash(file, checkpoint.HeadSize)
+	return err == nil && hash == checkpoint.HeadHash
+}
+
+func compressed(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	head := make([]byte, 6)
+	n, _ := io.ReadFull(file, head)
+	return parser.CompressionFormat(head[:n]) != ""
+}
+
+// Reader reads a log file from a checkpoint to the end of its last complete
+// line, so a line still being written is left for the next run.
+type Reader struct {
+	io.Reader
+	file  *os.File
+	start int64
+	end   int64
+}
+
+// Open opens the file at path to read from offset. A compressed file can't
+// be read from the middle, so it is read whole unless offset is its end.
+func Open(path string, offset int64) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file %s: %w", path, err)
+	}
+	size := info.Size()
+
+	end := size
+	if compressed(path) {
+		if offset != size {
+			offset = 0
+		}
+	} else if end, err = lastLineEnd(file, offset, size); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if offset > end {
+		offset = end
+	}
+
+	return &Reader{Reader: io.NewSectionReader(file, offset, end-offset), file: file, start: offset, end: end}, nil
+}
+
+// lastLineEnd returns the offset just past the last newline between offset
+// and size, or offset if there is none.
+func lastLineEnd(file *os.File, offset, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for end := size; end > offset; {
+		start := max(end-int64(len(buf)), offset)
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return offset, nil
+}
+
+// File is the open file, for Record.
+func (r *Reader) File() *os.File {
+	return r.file
+}
+
+// Start is the offset reading starts at: the checkpoint, or 0 when a
+// compressed file is read whole.
+func (r *Reader) Start() int64 {
+	return r.start
+}
+
+// End is the offset reading stops at, for Record.
+func (r *Reader) End() int64 {
+	return r.end
+}
+
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,194 @@
+package checkpoint
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestState_Resume(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "auth.log")
+	statePath := filepath.Join(tmpDir, "state", "state.json")
+
+	state, err := Load(statePath)
+	if err != nil {
+		t.Fatalf("Load() of a missing state file error = %v", err)
+	}
+
+	write := func(content string, flag int) {
+		t.Helper()
+		file, err := os.OpenFile(logFile, flag|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+	}
+	// analyze reads path from its checkpoint like a run would, returning
+	// what it read
+	analyze := func(path string) string {
+		t.Helper()
+		resume, _, err := state.Resume(path)
+		if err != nil {
+			t.Fatalf("Resume() error = %v", err)
+		}
+		reader, err := Open(path, resume.Offset)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if err := state.Record(path, reader.File(), reader.End(), nil); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		return string(data)
+	}
+	expect := func(step, got, want string) {
+		t.Helper()
+		if got != want {
+			t.Errorf("%s: read %q, expected %q", step, got, want)
+		}
+	}
+
+	if _, found, err := state.Resume(filepath.Join(tmpDir, "missing.log")); err == nil || found {
+		t.Errorf("Resume() of a missing file = %v, %v; expected an error", found, err)
+	}
+
+	write("line 1\n", os.O_TRUNC)
+	if resume, found, err := state.Resume(logFile); err != nil || found || resume.Offset != 0 {
+		t.Errorf("Resume() without a checkpoint = %d, %v, %v; expected 0, false", resume.Offset, found, err)
+	}
+	expect("first run", analyze(logFile), "line 1\n")
+	expect("nothing new", analyze(logFile), "")
+
+	// A line still being written is left for the next run
+	write("line 2\nline 3 part", os.O_APPEND)
+	expect("partial line", analyze(logFile), "line 2\n")
+	write("ial\n", os.O_APPEND)
+	expect("completed line", analyze(logFile), "line 3 partial\n")
+
+	// Checkpoints survive saving and loading, with their held lines
+	reader, err := Open(logFile, int64(len("line 1\nline 2\n")))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := state.Record(logFile, reader.File(), reader.End(), []string{"line 3 partial"}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	reader.Close()
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if state, err = Load(statePath); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	resume, found, err := state.Resume(logFile)
+	if err != nil || !found || resume.Offset != int64(len("line 1\nline 2\nline 3 partial\n")) {
+		t.Errorf("Resume() after Load() = %d, %v, %v", resume.Offset, found, err)
+	}
+	if len(resume.Held) != 1 || resume.Held[0] != "line 3 partial" {
+		t.Errorf("Resume() after Load() held %q, expected the recorded line", resume.Held)
+	}
+
+	// Truncated in place, as by copytruncate, starts over
+	write("line 4\n", os.O_TRUNC)
+	if resume, found, err := state.Resume(logFile); err != nil || !found || resume.Offset != 0 || resume.Held != nil {
+		t.Errorf("Resume() after truncation = %+v, %v, %v; expected 0, true", resume, found, err)
+	}
+	expect("truncated", analyze(logFile), "line 4\n")
+
+	// Rewritten with as much or more, the head no longer matches
+	write("LINE 5\nline 6\n", os.O_TRUNC)
+	expect("rewritten", analyze(logFile), "LINE 5\nline 6\n")
+
+	// Rotated by renaming: the old file is found to finish it, and the new
+	// one is read from the start
+	write("line 7\n", os.O_APPEND)
+	rotated := logFile + ".1"
+	if err := os.Rename(logFile, rotated); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	write("line 8\n", os.O_TRUNC)
+	path, from, ok := state.Rotated(logFile)
+	if !ok || path != rotated || from.Offset != int64(len("LINE 5\nline 6\n")) {
+		t.Fatalf("Rotated() = %q, %d, %v; expected %q", path, from.Offset, ok, rotated)
+	}
+	expect("rotated file", analyze(rotated), "line 7\n")
+	if resume, found, _ := state.Resume(logFile); !found || resume.Offset != 0 {
+		t.Errorf("Resume() of the new file = %d, %v; expected 0, true", resume.Offset, found)
+	}
+	expect("new file", analyze(logFile), "line 8\n")
+	if _, _, ok := state.Rotated(logFile); ok {
+		t.Errorf("Rotated() found a rotation of the file it checkpointed")
+	}
+
+	// Checkpoints of files that are gone aren't saved
+	if err := os.Remove(rotated); err != nil {
+		t.Fatalf("Failed to remove log: %v", err)
+	}
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if strings.Contains(string(data), "auth.log.1") || !strings.Contains(string(data), "auth.log") {
+		t.Errorf("Unexpected state file:\n%s", data)
+	}
+}
+
+func TestOpen_Compressed(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "auth.log.gz")
+
+	file, err := os.Create(logFile)
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte("line 1\nline 2\n"))
+	gz.Close()
+	file.Close()
+	info, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   int64
+	}{
+		{"from the start", 0, info.Size()},
+		{"from the middle", 5, info.Size()},
+		{"already read", info.Size(), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := Open(logFile, tt.offset)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer reader.Close()
+
+			n, err := io.Copy(io.Discard, reader)
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if n != tt.want || reader.End() != info.Size() {
+				t.Errorf("Read %d bytes up to %d, expected %d up to %d", n, reader.End(), tt.want, info.Size())
+			}
+		})
+	}
+}
@@ -1,17 +1,34 @@
 package cli
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/wellknittech/hayanix/internal/checkpoint"
 	"github.com/wellknittech/hayanix/internal/collection"
 	"github.com/wellknittech/hayanix/internal/config"
 	"github.com/wellknittech/hayanix/internal/engine"
+	"github.com/wellknittech/hayanix/internal/output"
+	"github.com/wellknittech/hayanix/internal/parser"
+	"github.com/wellknittech/hayanix/internal/receiver"
 	"github.com/wellknittech/hayanix/internal/rules"
 	"github.com/wellknittech/hayanix/internal/wizard"
 )
 
+// Version is the version of hayanix, recorded in output metadata.
+var Version = "dev"
+
 type Args struct {
 	Version kong.VersionFlag `help:"Show version information."`
 
@@ -21,6 +38,12 @@ type Args struct {
 	// Collection analysis
 	Collection CollectionCmd `cmd:"" help:"Analyze a collection of log files in a directory."`
 
+	// Network syslog receiver
+	Serve ServeCmd `cmd:"" help:"Receive syslog over the network and alert on matching messages as they arrive."`
+
+	// Result cache management
+	Cache CacheCmd `cmd:"" help:"Manage the collection analysis result cache."`
+
 	// Rule management commands
 	Rules RulesCmd `cmd:"" help:"Manage sigma rules from external sources."`
 
@@ -36,16 +59,71 @@ type AnalyzeCmd struct {
 	File      string `help:"Specific log file to analyze (optional)."`
 	Output    string `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
 	UseConfig bool   `help:"Use saved configuration from wizard."`
+	Threads   int    `help:"Number of rule evaluation workers (0 = number of CPUs)." default:"0"`
+	Timezone  string `help:"Timezone of log timestamps without an offset, e.g. Europe/Berlin (default: local)."`
+	Year      int    `help:"Year of the first syslog timestamp (default: inferred from the file's modification time)."`
+	Start     string `help:"Skip entries before this time (RFC 3339, or relative to now like -48h)."`
+	End       string `help:"Skip entries after this time (RFC 3339, or relative to now like -1h)."`
+	Host      string `help:"Host the log came from, for entries that don't name one (e.g. auditd without node=)."`
+	Follow    bool   `help:"Keep reading the log as it is written, following rotation, and write detections as they are found until interrupted." short:"f"`
+	State     string `help:"State file to resume the log from where the last run with it left off, and to checkpoint it in."`
 }
 
 type CollectionCmd struct {
-	Path     string `help:"Path to directory containing log files."`
-	RulesDir string `help:"Path to sigma rules directory." default:"./rules"`
-	Format   string `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
-	Type     string `help:"Filter by log type (syslog, journald, auditd). Leave empty to analyze all types."`
-	Detailed bool   `help:"Show detailed results for each file separately."`
-	Summary  bool   `help:"Show collection summary only."`
-	Verbose  bool   `help:"Enable verbose output." short:"v"`
+	Path     string   `help:"Path to directory or triage archive (tar, tar.gz, zip) containing log files."`
+	RulesDir string   `help:"Path to sigma rules directory." default:"./rules"`
+	Format   string   `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
+	Type     string   `help:"Filter by log type (syslog, journald, auditd). Leave empty to analyze all types."`
+	Detailed bool     `help:"Show detailed results for each file separately."`
+	Summary  bool     `help:"Show collection summary only."`
+	Verbose  bool     `help:"Enable verbose output." short:"v"`
+	Threads  int      `help:"Number of rule evaluation workers (0 = number of CPUs)." default:"0"`
+	Timezone string   `help:"Timezone of log timestamps without an offset, e.g. Europe/Berlin (default: local)."`
+	Year     int      `help:"Year of the first syslog timestamp (default: inferred from each file's modification time)."`
+	Start    string   `help:"Skip entries before this time (RFC 3339, or relative to now like -48h)."`
+	End      string   `help:"Skip entries after this time (RFC 3339, or relative to now like -1h)."`
+	HostMap  string   `help:"YAML file mapping paths in the collection to the hosts they came from."`
+	Include  []string `help:"Only examine files matching these globs (comma-separated, e.g. 'auth.log*,**/audit/*')."`
+	Exclude  []string `help:"Skip files and directories matching these globs, in addition to binaries and documents."`
+	MaxDepth int      `help:"Directory levels to descend into (1 = only files directly in --path, 0 = unlimited)." default:"0"`
+	MaxSize  string   `help:"Skip files larger than this, e.g. 500MB or 2GB (default: no limit)."`
+	Symlinks string   `help:"Whether to follow symlinks (skip, follow)." default:"skip" enum:"skip,follow"`
+	Hidden   bool     `help:"Examine hidden files and directories."`
+	Manifest string   `help:"Write a manifest of analyzed files with their hashes to this file (CSV, or JSON if it ends in .json)."`
+	Hash     []string `help:"Extra hashes for the manifest besides SHA-256 (md5, sha1)."`
+	NoCache  bool     `help:"Evaluate every file against every rule, without reading or writing the result cache."`
+	CacheDir string   `help:"Directory of the result cache (default: ~/.hayanix/cache)."`
+	State    string   `help:"State file to resume each log file from where the last run with it left off, and to checkpoint them in."`
+}
+
+type ServeCmd struct {
+	Rules            string        `help:"Path to sigma rules directory." default:"./rules"`
+	Output           string        `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
+	UDP              string        `name:"udp" help:"Address to receive syslog datagrams on, e.g. :514."`
+	TCP              string        `name:"tcp" help:"Address to receive syslog over TCP on, octet-counted or newline-framed, e.g. :514."`
+	TLS              string        `name:"tls" help:"Address to receive syslog over TLS on, e.g. :6514. Needs --tls-cert and --tls-key."`
+	TLSCert          string        `name:"tls-cert" help:"PEM certificate to present to TLS senders."`
+	TLSKey           string        `name:"tls-key" help:"PEM private key of --tls-cert."`
+	TLSClientCA      string        `name:"tls-client-ca" help:"PEM CA certificates that TLS senders must present a certificate from (default: no client certificates)."`
+	RateLimit        float64       `help:"Messages per second accepted from each sender; more are dropped (0 = unlimited)." default:"0"`
+	Burst            int           `help:"Messages a sender may send at once before --rate-limit applies (default: one second's worth)." default:"0"`
+	MaxMessageSize   string        `help:"Truncate messages longer than this, e.g. 64KB." default:"64KB"`
+	MaxConnections   int           `help:"TCP and TLS connections served at once; more are refused." default:"1024"`
+	IdleTimeout      time.Duration `help:"Close TCP and TLS connections that take longer than this to send a message." default:"5m"`
+	HandshakeTimeout time.Duration `help:"Close TLS connections that take longer than this to complete the handshake." default:"10s"`
+	Timezone         string        `help:"Timezone of syslog timestamps without an offset, e.g. Europe/Berlin (default: local)."`
+	StatsInterval    time.Duration `help:"Write per-sender stats to stderr this often, as well as on exit (0 = only on exit)." default:"0"`
+	Verbose          bool          `help:"Enable verbose output." short:"v"`
+}
+
+type CacheCmd struct {
+	Prune CachePruneCmd `cmd:"" help:"Remove cached results that haven't been used recently."`
+}
+
+type CachePruneCmd struct {
+	OlderThan time.Duration `help:"Remove results not used for this long." default:"720h"`
+	All       bool          `help:"Remove all cached results."`
+	CacheDir  string        `help:"Directory of the result cache (default: ~/.hayanix/cache)."`
 }
 
 type RulesCmd struct {
@@ -56,6 +134,12 @@ type RulesCmd struct {
 	Remove   RulesRemoveCmd   `cmd:"" help:"Remove a rule source."`
 	Enable   RulesEnableCmd   `cmd:"" help:"Enable a rule source."`
 	Disable  RulesDisableCmd  `cmd:"" help:"Disable a rule source."`
+	Rollback RulesRollbackCmd `cmd:"" help:"Restore a previously downloaded version of a rule source."`
+	Verify   RulesVerifyCmd   `cmd:"" help:"Show how a rule source was verified, or verify a rule bundle."`
+	Pin      RulesPinCmd      `cmd:"" help:"Pin the SHA-256 of installed rules so strict rule verification trusts them as they are."`
+	Search   RulesSearchCmd   `cmd:"" help:"Search the loaded rules."`
+	Show     RulesShowCmd     `cmd:"" help:"Show a rule with its compiled condition and field mappings."`
+	Stats    RulesStatsCmd    `cmd:"" help:"Summarise the loaded rules and list rules that can never fire."`
 }
 
 type RulesListCmd struct {
@@ -75,11 +159,13 @@ type RulesUpdateCmd struct {
 }
 
 type RulesAddCmd struct {
-	Name        string `help:"Source name."`
-	URL         string `help:"Repository URL."`
-	Branch      string `help:"Branch name." default:"master"`
-	Description string `help:"Source description."`
-	RulesDir    string `help:"Path to rules directory." default:"./rules"`
+	Name        string   `help:"Source name."`
+	URL         string   `help:"Repository URL."`
+	Branch      string   `help:"Branch name." default:"master"`
+	Description string   `help:"Source description."`
+	Strategy    string   `help:"Download strategy (archive, api)." default:"archive" enum:"archive,api"`
+	Paths       []string `help:"Repository paths containing rules (comma-separated)."`
+	RulesDir    string   `help:"Path to rules directory." default:"./rules"`
 }
 
 type RulesRemoveCmd struct {
@@ -97,6 +183,50 @@ type RulesDisableCmd struct {
 	RulesDir string `help:"Path to rules directory." default:"./rules"`
 }
 
+type RulesRollbackCmd struct {
+	Source   string `help:"Source name to roll back."`
+	Version  string `name:"to" help:"Version to restore (defaults to the most recent)."`
+	List     bool   `help:"List kept versions instead of restoring one."`
+	RulesDir string `help:"Path to rules directory." default:"./rules"`
+}
+
+type RulesVerifyCmd struct {
+	Source       string `help:"Installed source to show the verification record for."`
+	File         string `help:"Rule bundle or source archive to verify."`
+	SHA256       string `name:"sha256" help:"Expected SHA-256 of the file."`
+	ChecksumFile string `help:"sha256sum-style checksum file listing the file."`
+	PublicKey    string `help:"Minisign or base64 ed25519 public key."`
+	Signature    string `help:"Detached signature file (defaults to <file>.minisig)."`
+	RulesDir     string `help:"Path to rules directory." default:"./rules"`
+}
+
+type RulesPinCmd struct {
+	Source   string `help:"Pin only this installed source, or local for the rules outside external/ (defaults to every source that isn't verified)."`
+	RulesDir string `help:"Path to rules directory." default:"./rules"`
+}
+
+type RulesSearchCmd struct {
+	Query     string `arg:"" optional:"" help:"Text to find in rule titles and descriptions."`
+	Tag       string `help:"Only rules with a tag containing this text (e.g. attack.t1059)."`
+	Level     string `help:"Only rules with this level."`
+	Status    string `help:"Only rules with this status."`
+	Logsource string `help:"Only rules whose logsource product, category or service is this."`
+	Author    string `help:"Only rules whose author contains this text."`
+	Source    string `help:"Only rules from this source (local, chopchopgo, sigmahq, ...)."`
+	Output    string `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
+	RulesDir  string `help:"Path to rules directory." default:"./rules"`
+}
+
+type RulesShowCmd struct {
+	ID       string `arg:"" help:"Rule ID."`
+	RulesDir string `help:"Path to rules directory." default:"./rules"`
+}
+
+type RulesStatsCmd struct {
+	Output   string `help:"Output format (table, csv, json)." default:"table" enum:"table,csv,json"`
+	RulesDir string `help:"Path to rules directory." default:"./rules"`
+}
+
 type WizardCmd struct {
 	// No additional parameters needed for wizard
 }
@@ -165,9 +295,46 @@ func (ac *AnalyzeCmd) Run() error {
 		return fmt.Errorf("invalid output format: %s. Valid formats are: table, csv, json", output)
 	}
 
+	strict, err := strictVerification()
+	if err != nil {
+		return err
+	}
+
+	parseOptions, err := parserOptions(ac.Timezone, ac.Year, ac.Start, ac.End)
+	if err != nil {
+		return err
+	}
+	parseOptions.Host = ac.Host
+
 	// Create engine and run analysis
 	eng := engine.New(target, rulesDir, file, output, false)
-	return eng.Run()
+	eng.SetStrictVerification(strict)
+	eng.SetToolVersion(Version)
+	eng.SetThreads(ac.Threads)
+	eng.SetParserOptions(parseOptions)
+	eng.SetFollow(ac.Follow)
+
+	var state *checkpoint.State
+	if ac.State != "" {
+		if state, err = checkpoint.Load(ac.State); err != nil {
+			return err
+		}
+		eng.SetState(state)
+	}
+
+	// Stop parsing cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := eng.RunContext(ctx); err != nil {
+		return err
+	}
+
+	// Save checkpoints
+	if state != nil {
+		return state.Save()
+	}
+	return nil
 }
 
 func (rc *RulesListCmd) Run() error {
@@ -198,7 +365,19 @@ func (rc *RulesListCmd) Run() error {
 }
 
 func (rc *RulesDownloadCmd) Run() error {
+	strict, err := strictVerification()
+	if err != nil {
+		return err
+	}
+
+	token, err := githubToken()
+	if err != nil {
+		return err
+	}
+
 	rm := rules.NewRuleManager(rc.RulesDir)
+	rm.SetStrict(strict)
+	rm.SetGitHubToken(token)
 
 	if err := rm.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize rule manager: %w", err)
@@ -213,7 +392,9 @@ func (rc *RulesDownloadCmd) Run() error {
 		for _, source := range sources {
 			if source.Enabled {
 				fmt.Printf("Downloading rules from %s...\n", source.Name)
-				if err := rm.DownloadRules(source.Name); err != nil {
+				if err := rm.DownloadRules(source.Name); errors.Is(err, rules.ErrSourceUnchanged) {
+					fmt.Printf("Rules from %s are already up to date\n", source.Name)
+				} else if err != nil {
 					fmt.Printf("Warning: failed to download from %s: %v\n", source.Name, err)
 				} else {
 					fmt.Printf("Successfully downloaded rules from %s\n", source.Name)
@@ -222,7 +403,12 @@ func (rc *RulesDownloadCmd) Run() error {
 		}
 	} else if rc.Source != "" {
 		fmt.Printf("Downloading rules from %s...\n", rc.Source)
-		if err := rm.DownloadRules(rc.Source); err != nil {
+		err := rm.DownloadRules(rc.Source)
+		if errors.Is(err, rules.ErrSourceUnchanged) {
+			fmt.Printf("Rules from %s are already up to date\n", rc.Source)
+			return nil
+		}
+		if err != nil {
 			return fmt.Errorf("failed to download rules: %w", err)
 		}
 		fmt.Printf("Successfully downloaded rules from %s\n", rc.Source)
@@ -256,6 +442,8 @@ func (rc *RulesAddCmd) Run() error {
 		Branch:      rc.Branch,
 		Description: rc.Description,
 		Enabled:     true,
+		Strategy:    rc.Strategy,
+		Paths:       rc.Paths,
 	}
 
 	if err := rm.AddSource(source); err != nil {
@@ -299,6 +487,374 @@ func (rc *RulesDisableCmd) Run() error {
 	return nil
 }
 
+func (rc *RulesRollbackCmd) Run() error {
+	if rc.Source == "" {
+		return fmt.Errorf("please specify a source name with --source")
+	}
+
+	rm := rules.NewRuleManager(rc.RulesDir)
+
+	if rc.List {
+		versions, err := rm.ListVersions(rc.Source)
+		if err != nil {
+			return fmt.Errorf("failed to list versions: %w", err)
+		}
+
+		if len(versions) == 0 {
+			fmt.Printf("No previous versions of %s are kept\n", rc.Source)
+			return nil
+		}
+
+		fmt.Printf("Kept versions of %s (newest first):\n", rc.Source)
+		for _, version := range versions {
+			fmt.Printf("• %s\n", version.Version)
+		}
+		return nil
+	}
+
+	version, err := rm.Rollback(rc.Source, rc.Version)
+	if err != nil {
+		return fmt.Errorf("failed to roll back source: %w", err)
+	}
+
+	fmt.Printf("Successfully restored %s to version %s\n", rc.Source, version)
+	return nil
+}
+
+func (rc *RulesVerifyCmd) Run() error {
+	if rc.File != "" {
+		return rc.verifyFile()
+	}
+
+	verifications, err := rules.CheckSources(rc.RulesDir, false)
+	if err != nil {
+		return fmt.Errorf("failed to read verification records: %w", err)
+	}
+
+	found := false
+	for _, verification := range verifications {
+		if rc.Source != "" && !strings.EqualFold(verification.Source, rc.Source) {
+			continue
+		}
+		found = true
+
+		fmt.Printf("• %s: %s\n", verification.Source, verification.Status())
+		if verification.ArchiveURL != "" {
+			fmt.Printf("  Archive: %s\n", verification.ArchiveURL)
+		}
+		if verification.SHA256 != "" {
+			fmt.Printf("  SHA-256: %s\n", verification.SHA256)
+		}
+		fmt.Printf("  Checksum: %s\n", verification.Checksum)
+		fmt.Printf("  Signature: %s\n", verification.Signature)
+		if verification.KeyID != "" {
+			fmt.Printf("  Key ID: %s\n", verification.KeyID)
+		}
+		if verification.VerifiedAt != "" {
+			fmt.Printf("  Checked: %s\n", verification.VerifiedAt)
+		}
+		for _, path := range verification.Modified {
+			fmt.Printf("  Modified: %s\n", path)
+		}
+		fmt.Println()
+	}
+
+	if !found {
+		if rc.Source != "" {
+			return fmt.Errorf("source %s is not installed", rc.Source)
+		}
+		fmt.Println("No rules are installed")
+	}
+
+	return nil
+}
+
+func (rc *RulesVerifyCmd) verifyFile() error {
+	data, err := os.ReadFile(rc.File)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", rc.File, err)
+	}
+
+	source := rules.RuleSource{
+		Name:      filepath.Base(rc.File),
+		SHA256:    rc.SHA256,
+		PublicKey: rc.PublicKey,
+	}
+
+	var checksumFile string
+	if rc.ChecksumFile != "" {
+		listing, err := os.ReadFile(rc.ChecksumFile)
+		if err != nil {
+			return fmt.Errorf("failed to read checksum file: %w", err)
+		}
+		checksumFile = string(listing)
+	}
+
+	var signature []byte
+	if rc.PublicKey != "" {
+		signaturePath := rc.Signature
+		if signaturePath == "" {
+			signaturePath = rc.File + ".minisig"
+		}
+		signature, err = os.ReadFile(signaturePath)
+		if err != nil {
+			return fmt.Errorf("failed to read signature: %w", err)
+		}
+	}
+
+	verification, err := rules.VerifyArchive(source, filepath.Base(rc.File), data, checksumFile, signature)
+	if err != nil {
+		return fmt.Errorf("verification of %s failed: %w", rc.File, err)
+	}
+
+	fmt.Printf("%s: %s (checksum %s, signature %s)\n", rc.File, verification.Status(), verification.Checksum, verification.Signature)
+	fmt.Printf("SHA-256: %s\n", verification.SHA256)
+	if verification.Checksum == "published" && !verification.Verified {
+		return fmt.Errorf("the checksum file only shows %s is intact; give --sha256 or --public-key to verify it", rc.File)
+	}
+	if !verification.Verified {
+		return fmt.Errorf("no checksum or public key given; nothing was verified")
+	}
+
+	return nil
+}
+
+func (rc *RulesPinCmd) Run() error {
+	rm := rules.NewRuleManager(rc.RulesDir)
+	if rc.Source != "" {
+		if strings.EqualFold(rc.Source, "local") {
+			if err := rules.PinLocalRules(rc.RulesDir); err != nil {
+				return err
+			}
+		} else if err := rm.PinSource(rc.Source); err != nil {
+			return fmt.Errorf("failed to pin %s: %w", rc.Source, err)
+		}
+		fmt.Printf("Successfully pinned %s\n", rc.Source)
+		return nil
+	}
+
+	// Pin whatever isn't verified, leaving verified downloads as they are
+	verifications, err := rules.CheckSources(rc.RulesDir, false)
+	if err != nil {
+		return fmt.Errorf("failed to read verification records: %w", err)
+	}
+	for _, verification := range verifications {
+		if verification.Verified {
+			continue
+		}
+		if verification.Source == "local" {
+			err = rules.PinLocalRules(rc.RulesDir)
+		} else {
+			err = rm.PinSource(verification.Source)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to pin %s: %w", verification.Source, err)
+		}
+		fmt.Printf("Successfully pinned %s\n", verification.Source)
+	}
+
+	return nil
+}
+
+func (rc *RulesSearchCmd) Run() error {
+	ruleEngine, err := rules.NewEngine(rc.RulesDir)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	found := ruleEngine.Search(rules.Filter{
+		Text:      rc.Query,
+		Tag:       rc.Tag,
+		Level:     rc.Level,
+		Status:    rc.Status,
+		Logsource: rc.Logsource,
+		Author:    rc.Author,
+		Source:    rc.Source,
+	})
+
+	return output.NewOutputter(rc.Output).WriteRules(found)
+}
+
+func (rc *RulesShowCmd) Run() error {
+	ruleEngine, err := rules.NewEngine(rc.RulesDir)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	rule, ok := ruleEngine.FindRule(rc.ID)
+	if !ok {
+		return fmt.Errorf("rule %s not found in %s", rc.ID, rc.RulesDir)
+	}
+
+	content, err := os.ReadFile(rule.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	fmt.Printf("Rule: %s (%s)\n", rule.Title, rule.ID)
+	fmt.Printf("Path: %s\n", rule.Path)
+	fmt.Printf("Source: %s\n", rule.Source)
+	fmt.Printf("Condition: %s\n", rule.Condition())
+	fmt.Println()
+
+	fmt.Println("Field mappings:")
+	fields := rule.DetectionFields()
+	for _, target := range rules.Targets {
+		status := "applies"
+		if !target.Accepts(rule.Logsource) {
+			status = "logsource does not match"
+		}
+		fmt.Printf("• %s (%s)\n", target.Name, status)
+
+		if len(fields) == 0 {
+			fmt.Println("  keyword search on message")
+		}
+		for _, field := range fields {
+			if target.Supports(field) {
+				fmt.Printf("  %s -> %s\n", field, target.MapField(field))
+			} else {
+				fmt.Printf("  %s -> not available\n", field)
+			}
+		}
+	}
+	fmt.Println()
+
+	fmt.Println(strings.TrimRight(string(content), "\n"))
+	return nil
+}
+
+func (rc *RulesStatsCmd) Run() error {
+	ruleEngine, err := rules.NewEngine(rc.RulesDir)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	return output.NewOutputter(rc.Output).WriteRuleStats(ruleEngine.Stats())
+}
+
+// strictVerification reports whether the saved configuration requires rule
+// sources to be verified.
+func strictVerification() (bool, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return false, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return cfg.StrictRuleVerification, nil
+}
+
+// parserOptions builds the timestamp options for the --timezone, --year,
+// --start and --end flags.
+func parserOptions(timezone string, year int, start, end string) (parser.Options, error) {
+	opts := parser.Options{Year: year}
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load timezone %s: %w", timezone, err)
+		}
+		opts.Location = location
+	}
+
+	now := time.Now()
+	var err error
+	if opts.Start, err = parseTimeBound(start, now); err != nil {
+		return opts, fmt.Errorf("invalid --start: %w", err)
+	}
+	if opts.End, err = parseTimeBound(end, now); err != nil {
+		return opts, fmt.Errorf("invalid --end: %w", err)
+	}
+	if !opts.Start.IsZero() && !opts.End.IsZero() && opts.End.Before(opts.Start) {
+		return opts, fmt.Errorf("--end %s is before --start %s", end, start)
+	}
+
+	return opts, nil
+}
+
+// parseTimeBound parses an RFC 3339 time or a duration relative to now, such
+// as -48h. An empty value is the zero time.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration like -48h", value)
+}
+
+// parseSize parses a size in bytes with an optional KB, MB, GB or TB suffix,
+// in powers of 1024. An empty value is 0.
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if trimmed, ok := strings.CutSuffix(number, suffix); ok {
+			number, multiplier = trimmed, int64(1)<<(10*(i+1))
+			break
+		}
+	}
+	number = strings.TrimSuffix(number, "B")
+
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%q is not a size like 500MB", value)
+	}
+	return size * multiplier, nil
+}
+
+// cacheDir returns the result cache directory, defaulting to the one under
+// the config directory.
+func cacheDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	return config.GetCacheDir()
+}
+
+func (cc *CachePruneCmd) Run() error {
+	dir, err := cacheDir(cc.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	olderThan := cc.OlderThan
+	if cc.All {
+		olderThan = 0
+	} else if olderThan <= 0 {
+		return fmt.Errorf("--older-than must be positive; use --all to remove everything")
+	}
+
+	removed, freed, err := collection.NewResultCache(dir).Prune(olderThan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d cached results (%.2f MB) from %s\n", removed, float64(freed)/(1024*1024), dir)
+	return nil
+}
+
+// githubToken returns the token for GitHub API downloads from the environment,
+// falling back to the saved configuration.
+func githubToken() (string, error) {
+	for _, name := range []string{"HAYANIX_GITHUB_TOKEN", "GITHUB_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token, nil
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return cfg.GitHubToken, nil
+}
+
 func (wc *WizardCmd) Run() error {
 	w := wizard.NewWizard()
 
@@ -321,13 +877,13 @@ func (cc *CollectionCmd) Run() error {
 		return fmt.Errorf("collection path does not exist: %s", cc.Path)
 	}
 
-	// Check if path is a directory
+	// Check if path is a directory or triage archive
 	fileInfo, err := os.Stat(cc.Path)
 	if err != nil {
 		return fmt.Errorf("cannot access collection path %s: %w", cc.Path, err)
 	}
-	if !fileInfo.IsDir() {
-		return fmt.Errorf("collection path must be a directory: %s", cc.Path)
+	if !fileInfo.IsDir() && !collection.IsArchive(cc.Path) {
+		return fmt.Errorf("collection path must be a directory or archive: %s", cc.Path)
 	}
 
 	// Validate output format
@@ -356,6 +912,36 @@ func (cc *CollectionCmd) Run() error {
 
 	// Discover log files
 	collector := collection.NewCollector(cc.Path)
+	if cc.HostMap != "" {
+		hosts, err := collection.LoadHostMap(cc.HostMap)
+		if err != nil {
+			return err
+		}
+		collector.SetHostMap(hosts)
+	}
+	discovery := collection.DefaultDiscoveryOptions
+	discovery.Include = cc.Include
+	discovery.Exclude = append(append([]string(nil), discovery.Exclude...), cc.Exclude...)
+	discovery.MaxDepth = cc.MaxDepth
+	if discovery.MaxSize, err = parseSize(cc.MaxSize); err != nil {
+		return fmt.Errorf("invalid --max-size: %w", err)
+	}
+	discovery.FollowSymlinks = cc.Symlinks == "follow"
+	discovery.IncludeHidden = cc.Hidden
+	collector.SetDiscoveryOptions(discovery)
+	var md5, sha1 bool
+	for _, algorithm := range cc.Hash {
+		switch strings.ToLower(algorithm) {
+		case "md5":
+			md5 = true
+		case "sha1", "sha-1":
+			sha1 = true
+		case "sha256", "sha-256":
+		default:
+			return fmt.Errorf("invalid hash: %s. Valid hashes are: md5, sha1", algorithm)
+		}
+	}
+	collector.SetExtraHashes(md5, sha1)
 	logCollection, err := collector.DiscoverLogFiles()
 	if err != nil {
 		return fmt.Errorf("failed to discover log files: %w", err)
@@ -386,9 +972,55 @@ func (cc *CollectionCmd) Run() error {
 	}
 	fmt.Println()
 
-	// If summary only, return here
+	if len(logCollection.Summary.FilesByHost) > 0 {
+		fmt.Println("Files by Host:")
+		for host, count := range logCollection.Summary.FilesByHost {
+			fmt.Printf("  %s: %d files\n", host, count)
+		}
+		fmt.Println()
+	}
+
+	if cc.Verbose {
+		fmt.Println("Detected Files:")
+		for _, logFile := range logCollection.LogFiles {
+			fmt.Printf("  %s: %s (%.0f%% confidence)\n", logFile.Path, logFile.Type, logFile.Confidence*100)
+		}
+		fmt.Println()
+	}
+
+	// Show what wasn't examined, so nothing is missed unknowingly
+	if skipped := logCollection.Summary.Skipped; len(skipped) > 0 {
+		if cc.Verbose {
+			fmt.Println("Skipped Files:")
+			for _, file := range skipped {
+				fmt.Printf("  %s: %s\n", file.Path, file.Reason)
+			}
+		} else {
+			fmt.Printf("Skipped Files: %d (use --verbose to list them with reasons)\n", len(skipped))
+		}
+		fmt.Println()
+	}
+
+	// If summary only, return here, hashing the files only for a manifest
 	if cc.Summary {
-		return nil
+		if cc.Manifest == "" {
+			return nil
+		}
+		if err := collector.HashFiles(logCollection); err != nil {
+			return err
+		}
+		_, err := writeManifest(logCollection, cc.Manifest)
+		return err
+	}
+
+	strict, err := strictVerification()
+	if err != nil {
+		return err
+	}
+
+	parseOptions, err := parserOptions(cc.Timezone, cc.Year, cc.Start, cc.End)
+	if err != nil {
+		return err
 	}
 
 	// Create analyzer
@@ -396,13 +1028,52 @@ func (cc *CollectionCmd) Run() error {
 	if err != nil {
 		return fmt.Errorf("failed to create analyzer: %w", err)
 	}
+	analyzer.SetToolVersion(Version)
+	analyzer.SetStrictVerification(strict)
+	analyzer.SetThreads(cc.Threads)
+	analyzer.SetParserOptions(parseOptions)
+	if !cc.NoCache {
+		dir, err := cacheDir(cc.CacheDir)
+		if err != nil {
+			return err
+		}
+		analyzer.SetCache(collection.NewResultCache(dir))
+	}
+	var state *checkpoint.State
+	if cc.State != "" {
+		if state, err = checkpoint.Load(cc.State); err != nil {
+			return err
+		}
+		analyzer.SetState(state)
+	}
+	if !cc.Verbose && collection.IsTerminal(os.Stderr) {
+		analyzer.SetProgress(os.Stderr)
+	}
 
-	// Analyze collection
-	result, err := analyzer.AnalyzeCollection()
+	// Analyze collection, stopping cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := analyzer.AnalyzeCollectionContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to analyze collection: %w", err)
 	}
 
+	// Save checkpoints of the files analyzed
+	if state != nil {
+		if err := state.Save(); err != nil {
+			return err
+		}
+	}
+
+	// Record exactly which files were analyzed, as they were hashed while
+	// analyzing them, for chain of custody
+	manifestHash, err := writeManifest(logCollection, cc.Manifest)
+	if err != nil {
+		return err
+	}
+	analyzer.SetManifestHash(manifestHash)
+
 	// Write results
 	if cc.Detailed {
 		if err := analyzer.WriteDetailedResults(result); err != nil {
@@ -419,3 +1090,122 @@ func (cc *CollectionCmd) Run() error {
 
 	return nil
 }
+
+// writeManifest writes the manifest of a collection to path, if given, and
+// returns its hash.
+func writeManifest(logCollection *collection.Collection, path string) (string, error) {
+	manifest := logCollection.Manifest()
+	if path == "" {
+		return manifest.Hash()
+	}
+
+	manifestHash, err := manifest.WriteFile(path)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Manifest: %s (sha256 %s)\n\n", path, manifestHash)
+	return manifestHash, nil
+}
+
+func (sc *ServeCmd) Run() error {
+	if sc.UDP == "" && sc.TCP == "" && sc.TLS == "" {
+		return fmt.Errorf("nothing to listen on. Use --udp, --tcp or --tls to give an address")
+	}
+
+	// Validate rules directory
+	if _, err := os.Stat(sc.Rules); os.IsNotExist(err) {
+		return fmt.Errorf("rules directory does not exist: %s. Run 'hayanix wizard' to set up rules or create the directory manually", sc.Rules)
+	}
+
+	strict, err := strictVerification()
+	if err != nil {
+		return err
+	}
+
+	parseOptions, err := parserOptions(sc.Timezone, 0, "", "")
+	if err != nil {
+		return err
+	}
+
+	maxMessageSize, err := parseSize(sc.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+
+	// Create server
+	server, err := receiver.NewServer(sc.Rules, sc.Output, sc.Verbose)
+	if err != nil {
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+	server.SetToolVersion(Version)
+	server.SetStrictVerification(strict)
+	server.SetParserOptions(parseOptions)
+	server.SetRateLimit(receiver.RateLimit{PerSecond: sc.RateLimit, Burst: sc.Burst})
+	server.SetMaxMessageSize(int(maxMessageSize))
+	server.SetMaxConnections(sc.MaxConnections)
+	server.SetTimeouts(sc.IdleTimeout, sc.HandshakeTimeout)
+	server.SetStats(os.Stderr, sc.StatsInterval)
+
+	// Listen on every address given
+	if sc.UDP != "" {
+		addr, err := server.ListenUDP(sc.UDP)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Listening for syslog on UDP %s\n", addr)
+	}
+	if sc.TCP != "" {
+		addr, err := server.ListenTCP(sc.TCP)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Listening for syslog on TCP %s\n", addr)
+	}
+	if sc.TLS != "" {
+		tlsConfig, err := serverTLSConfig(sc.TLSCert, sc.TLSKey, sc.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		addr, err := server.ListenTLS(sc.TLS, tlsConfig)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Listening for syslog on TLS %s\n", addr)
+	}
+
+	// Receive until Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return server.Serve(ctx)
+}
+
+// serverTLSConfig loads the certificate presented to TLS senders and, if
+// clientCA is set, requires senders to present one it issued.
+func serverTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("--tls needs --tls-cert and --tls-key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		data, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
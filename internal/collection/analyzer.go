@@ -1,13 +1,19 @@
 package collection
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"path/filepath"
+	"os"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/wellknittech/hayanix/internal/checkpoint"
 	"github.com/wellknittech/hayanix/internal/output"
 	"github.com/wellknittech/hayanix/internal/parser"
 	"github.com/wellknittech/hayanix/internal/rules"
@@ -15,18 +21,33 @@ import (
 
 type CollectionAnalyzer struct {
 	collection *Collection
+	rulesDir   string
 	ruleEngine *rules.Engine
 	outputter  *output.Outputter
 	verbose    bool
+	strict     bool
+	threads    int
+	progress   io.Writer
+	parse      parser.Options
+	limits     ArchiveLimits
+	metadata   *output.Metadata
+	cache      *ResultCache
+	state      *checkpoint.State
 }
 
 type AnalysisResult struct {
 	LogFile     string
 	LogType     string
+	Host        string
 	Entries     []parser.LogEntry
 	MatchCount  int
 	ProcessTime time.Duration
 	Error       error
+	// Cached is set when every rule's results came from the cache.
+	Cached bool
+	// Hashes are of the file as read, covering its first Size bytes.
+	Hashes FileHashes
+	Size   int64
 }
 
 type CollectionResult struct {
@@ -36,6 +57,7 @@ type CollectionResult struct {
 	TotalFiles     int
 	ProcessedFiles int
 	FailedFiles    int
+	CachedFiles    int
 	TotalTime      time.Duration
 }
 
@@ -51,18 +73,91 @@ func NewCollectionAnalyzer(collection *Collection, rulesDir string, outputFormat
 
 	return &CollectionAnalyzer{
 		collection: collection,
+		rulesDir:   rulesDir,
 		ruleEngine: ruleEngine,
 		outputter:  outputter,
 		verbose:    verbose,
+		threads:    runtime.NumCPU(),
+		limits:     DefaultArchiveLimits,
+		metadata:   &output.Metadata{RuleSetHash: ruleEngine.Hash()},
 	}, nil
 }
 
+// SetToolVersion records the version of hayanix in the output metadata.
+func (ca *CollectionAnalyzer) SetToolVersion(version string) {
+	ca.metadata.ToolVersion = version
+}
+
+// SetManifestHash records the hash of the collection's manifest in the
+// output metadata, tying the results to the evidence analyzed. Files are
+// hashed as they are analyzed, so it is set once analysis is done.
+func (ca *CollectionAnalyzer) SetManifestHash(hash string) {
+	ca.metadata.ManifestHash = hash
+}
+
+// SetCache reuses results from cache for files and rules that were analyzed
+// before, and stores new results in it.
+func (ca *CollectionAnalyzer) SetCache(cache *ResultCache) {
+	ca.cache = cache
+}
+
+// SetState resumes each log file from its checkpoint in state and
+// checkpoints it again once analyzed, so only lines written since the last
+// run are analyzed. The caller saves state.
+func (ca *CollectionAnalyzer) SetState(state *checkpoint.State) {
+	ca.state = state
+}
+
+// SetArchiveLimits bounds what is read from the archive a collection is in.
+func (ca *CollectionAnalyzer) SetArchiveLimits(limits ArchiveLimits) {
+	ca.limits = limits
+}
+
+// SetStrictVerification refuses to analyze with rules from unverified sources.
+func (ca *CollectionAnalyzer) SetStrictVerification(strict bool) {
+	ca.strict = strict
+}
+
+// SetThreads sets how many workers evaluate rules. Zero or less uses one per
+// CPU.
+func (ca *CollectionAnalyzer) SetThreads(threads int) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	ca.threads = threads
+}
+
+// SetParserOptions sets the timezone and year used for timestamps that lack
+// them, and the time window entries must fall in.
+func (ca *CollectionAnalyzer) SetParserOptions(opts parser.Options) {
+	ca.parse = opts
+}
+
+// SetProgress redraws a live progress line on w while files are analyzed.
+func (ca *CollectionAnalyzer) SetProgress(w io.Writer) {
+	ca.progress = w
+}
+
 func (ca *CollectionAnalyzer) AnalyzeCollection() (*CollectionResult, error) {
+	return ca.AnalyzeCollectionContext(context.Background())
+}
+
+// AnalyzeCollectionContext analyzes every file in the collection, stopping
+// early when ctx is cancelled.
+func (ca *CollectionAnalyzer) AnalyzeCollectionContext(ctx context.Context) (*CollectionResult, error) {
 	startTime := time.Now()
 
+	// Record how the installed rule sources were verified
+	verifications, err := rules.CheckSources(ca.rulesDir, ca.strict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rule sources: %w", err)
+	}
+	ca.metadata.RuleSources = verifications
+	ca.outputter.SetMetadata(ca.metadata)
+
 	result := &CollectionResult{
 		Collection: ca.collection,
-		Results:    make([]AnalysisResult, 0),
+		Results:    make([]AnalysisResult, len(ca.collection.LogFiles)),
 		TotalFiles: len(ca.collection.LogFiles),
 	}
 
@@ -70,19 +165,76 @@ func (ca *CollectionAnalyzer) AnalyzeCollection() (*CollectionResult, error) {
 		log.Printf("Starting collection analysis of %d files", result.TotalFiles)
 	}
 
+	// Files are analyzed concurrently, sharing the worker budget: each file
+	// worker evaluates its entries on an equal share of the threads. Members
+	// of an archive are streamed in archive order by a single worker.
+	jobs := ca.jobs()
+	fileWorkers := ca.threads
+	if fileWorkers > len(jobs) {
+		fileWorkers = len(jobs)
+	}
+	evalThreads := 1
+	if fileWorkers > 0 {
+		evalThreads = ca.threads / fileWorkers
+	}
+
+	progress := newProgress(ca.progress, result.TotalFiles, ca.collection.Summary.TotalSize)
+	progress.run()
+
+	queue := make(chan analysisJob)
+	var wg sync.WaitGroup
+	for i := 0; i < fileWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				// Each worker writes only its own slots
+				if job.archive == "" {
+					index := job.files[0]
+					result.Results[index] = ca.analyzeLogFile(ctx, ca.collection.LogFiles[index], nil, evalThreads, progress)
+					progress.filesDone.Add(1)
+				} else {
+					ca.analyzeArchive(ctx, job, result.Results, evalThreads, progress)
+				}
+			}
+		}()
+	}
+
 	// Process each log file
-	for _, logFile := range ca.collection.LogFiles {
-		analysisResult := ca.analyzeLogFile(logFile)
-		result.Results = append(result.Results, analysisResult)
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	progress.finish()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
+	// Record the files as they were read, for the manifest
+	for i, analysisResult := range result.Results {
+		if analysisResult.Hashes.SHA256 != "" {
+			ca.collection.LogFiles[i].Hashes = analysisResult.Hashes
+			ca.collection.LogFiles[i].Size = analysisResult.Size
+		}
+	}
+
+	for _, analysisResult := range result.Results {
 		if analysisResult.Error != nil {
 			result.FailedFiles++
 			if ca.verbose {
-				log.Printf("Failed to analyze %s: %v", logFile.Path, analysisResult.Error)
+				log.Printf("Failed to analyze %s: %v", analysisResult.LogFile, analysisResult.Error)
 			}
 		} else {
 			result.ProcessedFiles++
 			result.TotalMatches += analysisResult.MatchCount
+			if analysisResult.Cached {
+				result.CachedFiles++
+			}
 		}
 	}
 
@@ -96,14 +248,97 @@ func (ca *CollectionAnalyzer) AnalyzeCollection() (*CollectionResult, error) {
 	return result, nil
 }
 
-func (ca *CollectionAnalyzer) analyzeLogFile(logFile LogFile) AnalysisResult {
+// analysisJob is a file to analyze, or the members of an archive.
+type analysisJob struct {
+	archive string
+	files   []int
+}
+
+// jobs splits the collection into files that can be analyzed concurrently
+// and archives whose members are read in one pass.
+func (ca *CollectionAnalyzer) jobs() []analysisJob {
+	var jobs []analysisJob
+	archives := make(map[string]int)
+	for index, logFile := range ca.collection.LogFiles {
+		if logFile.Archive == "" {
+			jobs = append(jobs, analysisJob{files: []int{index}})
+			continue
+		}
+		if job, ok := archives[logFile.Archive]; ok {
+			jobs[job].files = append(jobs[job].files, index)
+			continue
+		}
+		archives[logFile.Archive] = len(jobs)
+		jobs = append(jobs, analysisJob{archive: logFile.Archive, files: []int{index}})
+	}
+	return jobs
+}
+
+// analyzeArchive streams an archive once, hashing it and analyzing the job's
+// members as they come up. Members the archive didn't yield record why.
+func (ca *CollectionAnalyzer) analyzeArchive(ctx context.Context, job analysisJob, results []AnalysisResult, threads int, progress *progress) {
+	members := make(map[string]int, len(job.files))
+	for _, index := range job.files {
+		members[ca.collection.LogFiles[index].Member] = index
+	}
+
+	// The archive the collection was read from is hashed as it is read
+	var hasher *fileHasher
+	var hash io.Writer
+	if ca.collection.Archive != nil && ca.collection.Archive.Path == job.archive {
+		hasher = newHasher(ca.collection.ExtraHashes)
+		hash = hasher
+	}
+	err := walkArchiveHashing(job.archive, ca.limits, hash, func(member archiveMember, r io.Reader) error {
+		index, ok := members[member.name]
+		if !ok {
+			return ctx.Err()
+		}
+		delete(members, member.name)
+
+		results[index] = ca.analyzeLogFile(ctx, ca.collection.LogFiles[index], r, threads, progress)
+		progress.filesDone.Add(1)
+		return ctx.Err()
+	}, nil)
+	if err == nil {
+		if hasher != nil {
+			ca.collection.Archive.FileHashes = hasher.hashes()
+		}
+		err = fmt.Errorf("not found in %s", job.archive)
+	}
+
+	for _, index := range members {
+		logFile := ca.collection.LogFiles[index]
+		results[index] = AnalysisResult{
+			LogFile: logFile.Path,
+			LogType: logFile.Type,
+			Host:    logFile.Host,
+			Error:   fmt.Errorf("failed to read archive member: %w", err),
+		}
+		progress.filesDone.Add(1)
+	}
+}
+
+// analyzeLogFile parses and evaluates one file, read from r if given or else
+// opened from its path. Errors, including a panic in a parser, are recorded
+// on the result so other files carry on.
+func (ca *CollectionAnalyzer) analyzeLogFile(ctx context.Context, logFile LogFile, r io.Reader, threads int, progress *progress) (result AnalysisResult) {
 	startTime := time.Now()
 
-	result := AnalysisResult{
+	result = AnalysisResult{
 		LogFile: logFile.Path,
 		LogType: logFile.Type,
+		Host:    logFile.Host,
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			result.Entries = nil
+			result.MatchCount = 0
+			result.Error = fmt.Errorf("analysis of %s panicked: %v", logFile.Path, r)
+		}
+	}()
+
 	// Create parser
 	logParser, err := parser.NewParser(logFile.Type, logFile.Path)
 	if err != nil {
@@ -111,35 +346,300 @@ func (ca *CollectionAnalyzer) analyzeLogFile(logFile LogFile) AnalysisResult {
 		return result
 	}
 
-	// Parse log file
-	entries, err := logParser.Parse()
-	if err != nil {
-		result.Error = fmt.Errorf("failed to parse log file: %w", err)
+	// Infer missing years relative to when the file was last written, and
+	// name entries after the host the file came from
+	opts := ca.parse
+	if opts.Reference.IsZero() && !logFile.ModTime.IsZero() {
+		opts.Reference = logFile.ModTime
+	} else if info, err := os.Stat(logFile.Path); err == nil && opts.Reference.IsZero() {
+		opts.Reference = info.ModTime()
+	}
+	if logFile.Host != "" {
+		opts.Host = logFile.Host
+	}
+	logParser.SetOptions(opts)
+
+	// Read only what was written since the file's checkpoint. Entries
+	// lines appended later could still add to are held back for the next
+	// run, unless the file was rotated and won't be written to again.
+	var resumed *checkpoint.Reader
+	var from checkpoint.Checkpoint
+	var held []string
+	if ca.state != nil && r == nil && resumable(logParser, logFile.Path) {
+		if from, _, err = ca.state.Resume(logFile.Path); err != nil {
+			result.Error = fmt.Errorf("failed to resume: %w", err)
+			return result
+		}
+		if resumed, err = checkpoint.Open(logFile.Path, from.Offset); err != nil {
+			result.Error = err
+			return result
+		}
+		defer resumed.Close()
+		r = resumed
+		progress.bytesRead.Add(from.Offset)
+	}
+	record := func() {
+		if resumed == nil {
+			return
+		}
+		if err := ca.state.Record(logFile.Path, resumed.File(), resumed.End(), held); err != nil {
+			result.Error = fmt.Errorf("failed to checkpoint: %w", err)
+		}
+	}
+
+	// Reuse what is cached for the file, evaluating only the rules it
+	// hasn't been evaluated against. Results for part of a file, as read
+	// from a checkpoint, aren't cached.
+	if ca.cache != nil && resumed == nil {
+		matches, found, all, err := ca.scanCached(ctx, logParser, logFile, r, opts, threads, progress)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to parse log file: %w", err)
+			return result
+		}
+		result.Entries = matches
+		result.MatchCount = len(matches)
+		result.ProcessTime = time.Since(startTime)
+		result.Cached = all
+		result.Hashes, result.Size = found.hashes, found.size
+		progress.matches.Add(int64(result.MatchCount))
+
+		if ca.verbose {
+			if all {
+				log.Printf("Reused cached results for %s: %d matches", logFile.Path, result.MatchCount)
+			} else {
+				log.Printf("Analyzed %s: %d entries, %d matches in %v",
+					logFile.Path, found.processed, result.MatchCount, result.ProcessTime)
+			}
+		}
 		return result
 	}
 
-	// Evaluate rules against entries
 	var matchingEntries []parser.LogEntry
-	for _, entry := range entries {
-		matches := ca.ruleEngine.Evaluate(entry)
-		if len(matches) > 0 {
-			entry.MatchedRules = matches
-			matchingEntries = append(matchingEntries, entry)
-		}
+	found, err := ca.scan(ctx, logParser, logFile, r, resumed, from.Held, ca.ruleEngine, threads, progress, func(index int, entry parser.LogEntry) {
+		matchingEntries = append(matchingEntries, entry)
+		progress.matches.Add(1)
+	})
+	if err != nil {
+		result.Error = fmt.Errorf("failed to parse log file: %w", err)
+		return result
 	}
+	held = found.held
 
 	result.Entries = matchingEntries
 	result.MatchCount = len(matchingEntries)
 	result.ProcessTime = time.Since(startTime)
+	result.Hashes, result.Size = found.hashes, found.size
 
 	if ca.verbose {
+		if from.Offset > 0 {
+			log.Printf("Resumed %s at byte %d", logFile.Path, from.Offset)
+		}
 		log.Printf("Analyzed %s: %d entries, %d matches in %v",
-			logFile.Path, len(entries), result.MatchCount, result.ProcessTime)
+			logFile.Path, found.processed, result.MatchCount, result.ProcessTime)
 	}
 
+	record()
 	return result
 }
 
+// scanCached evaluates a file against the rules its cached results don't
+// cover, and returns its matches, cached and new. Results are found by the
+// file's hash, which is guessed from what the file hashed to when last
+// analyzed with the same path, size, modification time and inode, and
+// checked as the file is read; a file that changed anyway is scanned again
+// in full. all is set when every rule's results came from the cache, so the
+// file was only hashed.
+func (ca *CollectionAnalyzer) scanCached(ctx context.Context, logParser parser.Parser, logFile LogFile, r io.Reader, opts parser.Options, threads int, progress *progress) (matches []parser.LogEntry, found scanned, all bool, err error) {
+	ruleList := ca.ruleEngine.Rules()
+	identity := fileIdentity(logFile, ca.collection.Archive)
+	key := func(sha256 string) string {
+		keyed := logFile
+		keyed.Hashes.SHA256 = sha256
+		return fileKey(keyed, opts, ca.metadata.ToolVersion)
+	}
+	store := func(sha256 string, entry *cacheEntry) {
+		if err := ca.cache.store(key(sha256), entry); err != nil && ca.verbose {
+			log.Printf("Failed to cache results for %s: %v", logFile.Path, err)
+		}
+		if err := ca.cache.remember(identity, sha256); err != nil && ca.verbose {
+			log.Printf("Failed to cache the hash of %s: %v", logFile.Path, err)
+		}
+	}
+
+	// Cached results cover the whole file, whatever the time window
+	whole := opts
+	whole.Start, whole.End = time.Time{}, time.Time{}
+	logParser.SetOptions(whole)
+
+	if guess := ca.cache.guess(identity); guess != "" {
+		cached := ca.cache.load(key(guess))
+		missing := cached.missing(ruleList)
+		if len(missing) == 0 {
+			// Only the hash is needed to be sure the results are the file's
+			if found.hashes, found.size, err = ca.hash(logFile, r); err != nil {
+				return nil, found, false, err
+			}
+			if found.hashes.SHA256 == guess {
+				progress.bytesRead.Add(found.size)
+				return windowed(cached.entries(ruleList), opts), found, true, nil
+			}
+		} else {
+			engine := ca.ruleEngine.Subset(missing)
+			newMatches := make(map[int]parser.LogEntry)
+			found, err = ca.scan(ctx, logParser, logFile, r, nil, nil, engine, threads, progress, func(index int, entry parser.LogEntry) {
+				newMatches[index] = entry
+			})
+			if err != nil {
+				return nil, found, false, err
+			}
+			if found.hashes.SHA256 == guess {
+				cached.add(ruleList, engine.Rules(), newMatches)
+				store(guess, cached)
+				return windowed(cached.entries(ruleList), opts), found, false, nil
+			}
+		}
+
+		// A member of an archive can only be read once
+		if r != nil {
+			return nil, found, false, fmt.Errorf("%s changed since its results were cached: read sha256 %s, expected %s", logFile.Path, found.hashes.SHA256, guess)
+		}
+	}
+
+	// Evaluate every rule, caching the results under the hash read
+	cached := &cacheEntry{Rules: make(map[string]bool)}
+	newMatches := make(map[int]parser.LogEntry)
+	found, err = ca.scan(ctx, logParser, logFile, r, nil, nil, ca.ruleEngine, threads, progress, func(index int, entry parser.LogEntry) {
+		newMatches[index] = entry
+	})
+	if err != nil {
+		return nil, found, false, err
+	}
+	cached.add(ruleList, ruleList, newMatches)
+	store(found.hashes.SHA256, cached)
+	return windowed(cached.entries(ruleList), opts), found, false, nil
+}
+
+// scanned is what scanning a file found besides its matches.
+type scanned struct {
+	processed int
+	hashes    FileHashes
+	size      int64
+	held      []string
+}
+
+// scan parses a file, read from r if given, from its checkpoint if resumed
+// or else opened from its path, and evaluates engine's rules against its
+// entries as they are parsed, passing each match to match with its index
+// among the entries read.
+func (ca *CollectionAnalyzer) scan(ctx context.Context, logParser parser.Parser, logFile LogFile, r io.Reader, resumed *checkpoint.Reader, held []string, engine *rules.Engine, threads int, progress *progress, match func(int, parser.LogEntry)) (scanned, error) {
+	// Stop the parser if evaluation bails out early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var found scanned
+	entries := make(chan parser.LogEntry, 256)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("parser panicked: %v", r)
+			}
+		}()
+		var err error
+		found.hashes, found.size, found.held, err = ca.stream(ctx, logParser, logFile, r, resumed, held, &progress.bytesRead, entries)
+		errc <- err
+	}()
+
+	processed := engine.EvaluateStreamIndexed(entries, threads, match)
+	if err := <-errc; err != nil {
+		return scanned{}, err
+	}
+	found.processed = processed
+	return found, nil
+}
+
+// resumable reports whether a file can be resumed from a checkpoint: a text
+// log, read by a parser that can start from any offset.
+func resumable(logParser parser.Parser, path string) bool {
+	if _, ok := logParser.(parser.ReaderParser); !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && !parser.IsJournalFile(path)
+}
+
+// stream streams a log file to out, adding the bytes read to count, and
+// returns the hashes of the file as read and how many bytes they cover. A
+// file is read only as far as it was when discovered and hashed as it is
+// parsed, so its results are of exactly the evidence the manifest records.
+// A resumed file is read from its checkpoint, hashing what came before it
+// first, and returns the lines held back for the next run. Parsers that
+// can't read from a reader are counted and hashed once they finish.
+func (ca *CollectionAnalyzer) stream(ctx context.Context, logParser parser.Parser, logFile LogFile, r io.Reader, resumed *checkpoint.Reader, held []string, count *atomic.Int64, out chan<- parser.LogEntry) (FileHashes, int64, []string, error) {
+	extra := ca.collection.ExtraHashes
+	readerParser, ok := logParser.(parser.ReaderParser)
+	if !ok {
+		if r != nil {
+			return FileHashes{}, 0, nil, fmt.Errorf("%s parser can't read archive members", logFile.Type)
+		}
+		if err := logParser.Stream(ctx, out); err != nil {
+			return FileHashes{}, 0, nil, err
+		}
+		count.Add(logFile.Size)
+		hashes, size, err := hashFile(logFile.Path, logFile.Size, extra)
+		if err != nil {
+			return FileHashes{}, 0, nil, fmt.Errorf("failed to hash %s: %w", logFile.Path, err)
+		}
+		return hashes, size, nil, nil
+	}
+
+	var hashing *hashingReader
+	if resumed != nil {
+		hashing = newHashingReader(resumed, extra)
+		if _, err := io.Copy(hashing.hasher, io.NewSectionReader(resumed.File(), 0, resumed.Start())); err != nil {
+			return FileHashes{}, 0, nil, fmt.Errorf("failed to hash %s: %w", logFile.Path, err)
+		}
+		hashing.n = resumed.Start()
+
+		var err error
+		held, err = parser.StreamResumable(ctx, readerParser, countingReader{r: hashing, count: count}, held, !rotated(logFile.Path), out)
+		if err != nil {
+			return FileHashes{}, 0, nil, err
+		}
+	} else {
+		if r == nil {
+			file, err := os.Open(logFile.Path)
+			if err != nil {
+				return FileHashes{}, 0, nil, fmt.Errorf("failed to open file %s: %w", logFile.Path, err)
+			}
+			defer file.Close()
+			r = file
+		}
+
+		hashing = newHashingReader(limitSize(r, logFile.Size), extra)
+		if err := readerParser.StreamReader(ctx, countingReader{r: hashing, count: count}, out); err != nil {
+			return FileHashes{}, 0, nil, err
+		}
+		held = nil
+	}
+
+	hashes, size, err := hashing.finish()
+	if err != nil {
+		return FileHashes{}, 0, nil, fmt.Errorf("failed to hash %s: %w", logFile.Path, err)
+	}
+	return hashes, size, held, nil
+}
+
+// hash hashes a file, read from r if given or else opened from its path,
+// without parsing it.
+func (ca *CollectionAnalyzer) hash(logFile LogFile, r io.Reader) (FileHashes, int64, error) {
+	if r == nil {
+		return hashFile(logFile.Path, logFile.Size, ca.collection.ExtraHashes)
+	}
+	return newHashingReader(limitSize(r, logFile.Size), ca.collection.ExtraHashes).finish()
+}
+
 func (ca *CollectionAnalyzer) WriteResults(result *CollectionResult) error {
 	// Collect all matching entries from all files
 	var allEntries []parser.LogEntry
@@ -149,9 +649,13 @@ func (ca *CollectionAnalyzer) WriteResults(result *CollectionResult) error {
 		}
 	}
 
-	// Sort entries by timestamp if possible
-	sort.Slice(allEntries, func(i, j int) bool {
-		return allEntries[i].Timestamp < allEntries[j].Timestamp
+	// Sort entries by time, leaving those without a valid timestamp last
+	sort.SliceStable(allEntries, func(i, j int) bool {
+		a, b := allEntries[i], allEntries[j]
+		if a.InvalidTimestamp || b.InvalidTimestamp {
+			return !a.InvalidTimestamp && b.InvalidTimestamp
+		}
+		return a.Time.Before(b.Time)
 	})
 
 	// Write results
@@ -165,6 +669,9 @@ func (ca *CollectionAnalyzer) WriteSummary(result *CollectionResult) {
 	fmt.Printf("Total Files: %d\n", result.TotalFiles)
 	fmt.Printf("Processed Files: %d\n", result.ProcessedFiles)
 	fmt.Printf("Failed Files: %d\n", result.FailedFiles)
+	if result.CachedFiles > 0 {
+		fmt.Printf("Reused from cache: %d files\n", result.CachedFiles)
+	}
 	fmt.Printf("Total Matches: %d\n", result.TotalMatches)
 	fmt.Printf("Processing Time: %v\n", result.TotalTime)
 	fmt.Println()
@@ -176,6 +683,14 @@ func (ca *CollectionAnalyzer) WriteSummary(result *CollectionResult) {
 	}
 	fmt.Println()
 
+	if len(result.Collection.Summary.FilesByHost) > 0 {
+		fmt.Println("🖥️  Files by Host:")
+		for host, count := range result.Collection.Summary.FilesByHost {
+			fmt.Printf("  %s: %d files\n", host, count)
+		}
+		fmt.Println()
+	}
+
 	// Show results by file
 	if len(result.Results) > 0 {
 		fmt.Println("📋 Results by File:")
@@ -185,9 +700,13 @@ func (ca *CollectionAnalyzer) WriteSummary(result *CollectionResult) {
 				status = "❌"
 			}
 
-			relativePath, _ := filepath.Rel(result.Collection.BasePath, analysisResult.LogFile)
+			relativePath := relativeLogPath(result.Collection.BasePath, analysisResult.LogFile)
+			logType := analysisResult.LogType
+			if analysisResult.Host != "" {
+				logType += ", " + analysisResult.Host
+			}
 			fmt.Printf("  %s %s (%s): %d matches\n",
-				status, relativePath, analysisResult.LogType, analysisResult.MatchCount)
+				status, relativePath, logType, analysisResult.MatchCount)
 		}
 	}
 }
@@ -204,11 +723,12 @@ func (ca *CollectionAnalyzer) WriteDetailedResults(result *CollectionResult) err
 		}
 
 		// Create file-specific outputter
-		relativePath, _ := filepath.Rel(result.Collection.BasePath, analysisResult.LogFile)
+		relativePath := relativeLogPath(result.Collection.BasePath, analysisResult.LogFile)
 		fmt.Printf("\n📄 Results for %s (%s):\n", relativePath, analysisResult.LogType)
 		fmt.Println(strings.Repeat("=", 50))
 
 		fileOutputter := output.NewOutputter("table")
+		fileOutputter.SetMetadata(ca.metadata)
 		if err := fileOutputter.Write(analysisResult.Entries); err != nil {
 			return fmt.Errorf("failed to write results for %s: %w", analysisResult.LogFile, err)
 		}
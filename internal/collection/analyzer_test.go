@@ -0,0 +1,398 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wellknittech/hayanix/internal/checkpoint"
+)
+
+const testRule = `title: Failed Login
+id: failed-login
+logsource:
+    product: linux
+    service: syslog
+detection:
+    keywords:
+        - 'failed password'
+    condition: keywords`
+
+func TestCollectionAnalyzer_AnalyzeCollection(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	collection := &Collection{BasePath: tmpDir}
+	for i := 0; i < 8; i++ {
+		var content strings.Builder
+		for j := 0; j <= i; j++ {
+			fmt.Fprintf(&content, "Jan  1 10:30:%02d host%d sshd[1]: Failed password for root\n", j, i)
+		}
+		path := filepath.Join(tmpDir, fmt.Sprintf("host%d.log", i))
+		if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+		collection.LogFiles = append(collection.LogFiles, LogFile{Path: path, Type: "syslog", Size: int64(content.Len())})
+		collection.Summary.TotalSize += int64(content.Len())
+	}
+
+	// A file that fails must not hold up the others
+	collection.LogFiles = append(collection.LogFiles, LogFile{Path: filepath.Join(tmpDir, "missing.log"), Type: "syslog"})
+
+	analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+	if err != nil {
+		t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+	}
+	analyzer.SetThreads(4)
+	var progress bytes.Buffer
+	analyzer.SetProgress(&progress)
+
+	result, err := analyzer.AnalyzeCollection()
+	if err != nil {
+		t.Fatalf("AnalyzeCollection() error = %v", err)
+	}
+
+	if result.ProcessedFiles != 8 || result.FailedFiles != 1 {
+		t.Errorf("Expected 8 processed and 1 failed file, got %d and %d", result.ProcessedFiles, result.FailedFiles)
+	}
+	if result.TotalMatches != 36 {
+		t.Errorf("Expected 36 matches, got %d", result.TotalMatches)
+	}
+
+	// Results stay in collection order
+	for i, analysisResult := range result.Results {
+		if analysisResult.LogFile != collection.LogFiles[i].Path {
+			t.Errorf("Result %d is for %s, expected %s", i, analysisResult.LogFile, collection.LogFiles[i].Path)
+		}
+		if i < 8 && analysisResult.MatchCount != i+1 {
+			t.Errorf("Expected %d matches in %s, got %d", i+1, analysisResult.LogFile, analysisResult.MatchCount)
+		}
+	}
+
+	if !strings.Contains(progress.String(), "[9/9 files]") || !strings.Contains(progress.String(), "36 matches") {
+		t.Errorf("Expected a final progress line, got %q", progress.String())
+	}
+}
+
+func TestCollectionAnalyzer_State(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	logDir := filepath.Join(tmpDir, "evidence")
+	for _, dir := range []string{rulesDir, logDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	logFile := filepath.Join(logDir, "auth.log")
+	appendLog := func(content string) {
+		t.Helper()
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+	}
+	appendLog("Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n" +
+		"Jan  1 10:30:01 web01 sshd[1]: Accepted password for alice\n")
+
+	statePath := filepath.Join(tmpDir, "state.json")
+	analyze := func() []string {
+		t.Helper()
+		state, err := checkpoint.Load(statePath)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		collection, err := NewCollector(logDir).DiscoverLogFiles()
+		if err != nil {
+			t.Fatalf("DiscoverLogFiles() error = %v", err)
+		}
+		analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+		if err != nil {
+			t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+		}
+		analyzer.SetThreads(1)
+		analyzer.SetState(state)
+		analyzer.SetCache(NewResultCache(filepath.Join(tmpDir, "cache")))
+		result, err := analyzer.AnalyzeCollection()
+		if err != nil {
+			t.Fatalf("AnalyzeCollection() error = %v", err)
+		}
+		if result.FailedFiles != 0 {
+			t.Fatalf("Analysis failed: %v", result.Results[0].Error)
+		}
+		if err := state.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		var matches []string
+		for _, entry := range result.Results[0].Entries {
+			match := entry.Time.Format("05")
+			if strings.HasSuffix(entry.Message, "from 10.0.0.1") {
+				match += " continued"
+			}
+			matches = append(matches, match)
+		}
+		return matches
+	}
+
+	// The last entry is held back for continuation lines until the next run
+	tests := []struct {
+		name   string
+		append string
+		want   []string
+	}{
+		{"first run", "", []string{"00"}},
+		{"nothing new", "", nil},
+		{"new lines", "Jan  1 10:30:02 web01 sshd[1]: Failed password for admin\nJan  1 10:30:03 web01 sshd[1]: Failed pass", nil},
+		{"completed line", "word for guest\n", []string{"02"}},
+		{"continuation line", "    from 10.0.0.1\n", nil},
+		{"next entry", "Jan  1 10:30:04 web01 sshd[1]: Accepted password for bob\n", []string{"03 continued"}},
+	}
+
+	for _, tt := range tests {
+		appendLog(tt.append)
+		if got := analyze(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got matches %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollectionAnalyzer_Metadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	logFile := filepath.Join(tmpDir, "auth.log")
+	content := "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	collection := &Collection{
+		BasePath: tmpDir,
+		LogFiles: []LogFile{{Path: logFile, Type: "syslog", Size: int64(len(content))}},
+	}
+
+	analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+	if err != nil {
+		t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+	}
+	analyzer.SetToolVersion("1.2.3")
+	result, err := analyzer.AnalyzeCollection()
+	if err != nil {
+		t.Fatalf("AnalyzeCollection() error = %v", err)
+	}
+	analyzer.SetManifestHash("51d2")
+
+	// Capture stdout
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err = analyzer.WriteResults(result)
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := strings.TrimSpace(buf.String())
+
+	if err != nil {
+		t.Fatalf("WriteResults() error = %v", err)
+	}
+
+	// Every output says how it was produced
+	for _, want := range []string{`"manifest_sha256": "51d2"`, `"tool_version": "1.2.3"`, `"rule_set_sha256": "`} {
+		if !strings.HasPrefix(output, "{") || !strings.Contains(output, want) {
+			t.Errorf("Expected metadata with %s, got output %s", want, output)
+		}
+	}
+}
+
+func TestCollectionAnalyzer_HashesAsRead(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	content := strings.Repeat("Jan  1 10:30:00 web01 sshd[1]: Accepted password for root\n", 2) +
+		"Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n"
+	modified := "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n" +
+		content[len("Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n"):]
+	tests := []struct {
+		name    string
+		change  string
+		flag    int
+		matches int
+		read    string
+	}{
+		// Lines appended after discovery are left for the next run
+		{"appended", "Jan  1 10:31:00 web01 sshd[1]: Failed password for admin\n", os.O_APPEND | os.O_WRONLY, 1, content},
+		{"modified in place", "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n", os.O_WRONLY, 2, modified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evidenceDir := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "-"))
+			if err := os.MkdirAll(evidenceDir, 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			logPath := filepath.Join(evidenceDir, "auth.log")
+			if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write log: %v", err)
+			}
+			collector := NewCollector(evidenceDir)
+			collector.SetExtraHashes(true, false)
+			collection, err := collector.DiscoverLogFiles()
+			if err != nil {
+				t.Fatalf("DiscoverLogFiles() error = %v", err)
+			}
+			if collection.LogFiles[0].Hashes.SHA256 != "" {
+				t.Errorf("Expected no hashes before analysis, got %+v", collection.LogFiles[0].Hashes)
+			}
+
+			f, err := os.OpenFile(logPath, tt.flag, 0644)
+			if err != nil {
+				t.Fatalf("Failed to open log: %v", err)
+			}
+			if _, err := f.WriteString(tt.change); err != nil {
+				t.Fatalf("Failed to change log: %v", err)
+			}
+			f.Close()
+
+			analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+			if err != nil {
+				t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+			}
+			result, err := analyzer.AnalyzeCollection()
+			if err != nil {
+				t.Fatalf("AnalyzeCollection() error = %v", err)
+			}
+			analysisResult := result.Results[0]
+			if analysisResult.Error != nil {
+				t.Fatalf("Analysis failed: %v", analysisResult.Error)
+			}
+			if analysisResult.MatchCount != tt.matches {
+				t.Errorf("Expected %d matches, got %d", tt.matches, analysisResult.MatchCount)
+			}
+
+			// The manifest records exactly what was analyzed
+			logFile := collection.LogFiles[0]
+			if want := sha256Hex(tt.read); logFile.Hashes.SHA256 != want {
+				t.Errorf("Expected sha256 %s, got %s", want, logFile.Hashes.SHA256)
+			}
+			if want := md5Hex(tt.read); logFile.Hashes.MD5 != want {
+				t.Errorf("Expected md5 %s, got %s", want, logFile.Hashes.MD5)
+			}
+			if logFile.Size != int64(len(tt.read)) {
+				t.Errorf("Expected size %d, got %d", len(tt.read), logFile.Size)
+			}
+		})
+	}
+}
+
+func TestCollectionAnalyzer_HashesResumed(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	logDir := filepath.Join(tmpDir, "evidence")
+	for _, dir := range []string{rulesDir, logDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	logFile := filepath.Join(logDir, "auth.log")
+	first := "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n" +
+		"Jan  1 10:30:01 web01 sshd[1]: Accepted password for alice\n"
+	if err := os.WriteFile(logFile, []byte(first), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	statePath := filepath.Join(tmpDir, "state.json")
+	analyze := func() LogFile {
+		t.Helper()
+		state, err := checkpoint.Load(statePath)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		collection, err := NewCollector(logDir).DiscoverLogFiles()
+		if err != nil {
+			t.Fatalf("DiscoverLogFiles() error = %v", err)
+		}
+		analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+		if err != nil {
+			t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+		}
+		analyzer.SetState(state)
+		result, err := analyzer.AnalyzeCollection()
+		if err != nil {
+			t.Fatalf("AnalyzeCollection() error = %v", err)
+		}
+		if result.FailedFiles != 0 {
+			t.Fatalf("Analysis failed: %v", result.Results[0].Error)
+		}
+		if err := state.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		return collection.LogFiles[0]
+	}
+
+	if got := analyze(); got.Hashes.SHA256 != sha256Hex(first) {
+		t.Errorf("First run: expected sha256 %s, got %s", sha256Hex(first), got.Hashes.SHA256)
+	}
+
+	// A resumed file is hashed from its start, not from its checkpoint, up
+	// to the last complete line read
+	second := first + "Jan  1 10:30:02 web01 sshd[1]: Failed password for admin\n"
+	if err := os.WriteFile(logFile, []byte(second+"Jan  1 10:30:03 web01 sshd[1]: Fail"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	got := analyze()
+	if got.Hashes.SHA256 != sha256Hex(second) {
+		t.Errorf("Resumed run: expected sha256 %s, got %s", sha256Hex(second), got.Hashes.SHA256)
+	}
+	if got.Size != int64(len(second)) {
+		t.Errorf("Resumed run: expected size %d, got %d", len(second), got.Size)
+	}
+}
+
+func sha256Hex(s string) string {
+	digest := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(digest[:])
+}
+
+func md5Hex(s string) string {
+	digest := md5.Sum([]byte(s))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,328 @@
+package collection
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+// ArchiveLimits bound what is read from an archive, guarding against
+// archive bombs and hostile member names. Members are streamed, never
+// extracted to disk.
+type ArchiveLimits struct {
+	// MaxMemberSize is the most bytes read from one member.
+	MaxMemberSize int64
+	// MaxTotalSize is the most bytes read from all members together.
+	MaxTotalSize int64
+	// MaxMembers is the most members an archive may hold.
+	MaxMembers int
+	// MaxPathLength is the longest member name accepted.
+	MaxPathLength int
+}
+
+// DefaultArchiveLimits are generous enough for triage collections.
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxMemberSize: 4 << 30,
+	MaxTotalSize:  64 << 30,
+	MaxMembers:    1000000,
+	MaxPathLength: 4096,
+}
+
+// archiveSeparator joins an archive path and a member path in LogFile.Path,
+// as in triage.tar.gz!/var/log/auth.log.
+const archiveSeparator = "!/"
+
+// archiveExts are stripped from archive names when inferring hosts.
+var archiveExts = []string{".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst", ".tgz", ".tar", ".zip"}
+
+// archiveMember is a regular file in an archive.
+type archiveMember struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// errArchiveLimit reports an archive exceeding its limits.
+var errArchiveLimit = errors.New("archive limit exceeded")
+
+// IsArchive reports whether path is a tar archive, optionally compressed, or
+// a zip archive, judged by its contents.
+func IsArchive(path string) bool {
+	format, _ := archiveFormat(path)
+	return format != ""
+}
+
+// archiveFormat returns "zip" or "tar" for an archive, or "" for other files.
+func archiveFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if n, _ := io.ReadFull(file, magic); n == 4 && (bytes.Equal(magic, []byte("PK\x03\x04")) || bytes.Equal(magic, []byte("PK\x05\x06"))) {
+		return "zip", nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	reader, err := parser.Decompress(file)
+	if err != nil {
+		return "", nil
+	}
+	defer reader.Close()
+
+	header := make([]byte, 512)
+	if n, _ := io.ReadFull(reader, header); n == 512 && bytes.HasPrefix(header[257:], []byte("ustar")) {
+		return "tar", nil
+	}
+	return "", nil
+}
+
+// walkArchive calls fn with each regular file in the archive at archivePath
+// and a reader of its contents, in archive order. Compressed tar archives are
+// decompressed as they are read. fn must not keep the reader. Members refused
+// by the limits are passed to skip, if set, with the reason.
+func walkArchive(archivePath string, limits ArchiveLimits, fn func(member archiveMember, r io.Reader) error, skip func(name, reason string)) error {
+	return walkArchiveHashing(archivePath, limits, nil, fn, skip)
+}
+
+// walkArchiveHashing walks an archive like walkArchive and also writes the
+// archive as stored to hash, if set, so it is hashed in the same pass. Zip
+// archives are read out of order, so they are hashed in a pass of their own.
+func walkArchiveHashing(archivePath string, limits ArchiveLimits, hash io.Writer, fn func(member archiveMember, r io.Reader) error, skip func(name, reason string)) error {
+	format, err := archiveFormat(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+
+	if skip == nil {
+		skip = func(string, string) {}
+	}
+	walker := &archiveWalker{limits: limits, hash: hash, fn: fn, skip: skip}
+	switch format {
+	case "zip":
+		err = walker.zip(archivePath)
+	case "tar":
+		err = walker.tar(archivePath)
+	default:
+		return fmt.Errorf("not a tar or zip archive: %s", archivePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+	}
+	return nil
+}
+
+type archiveWalker struct {
+	limits  ArchiveLimits
+	hash    io.Writer
+	fn      func(member archiveMember, r io.Reader) error
+	skip    func(name, reason string)
+	members int
+	total   int64
+}
+
+func (w *archiveWalker) tar(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var source io.Reader = file
+	if w.hash != nil {
+		source = io.TeeReader(file, w.hash)
+	}
+	reader, err := parser.Decompress(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			// Hash any padding after the end of the archive too
+			if w.hash != nil {
+				_, err = io.Copy(w.hash, file)
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		member := archiveMember{name: header.Name, size: header.Size, modTime: header.ModTime}
+		if err := w.visit(member, archive); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *archiveWalker) zip(archivePath string) error {
+	if w.hash != nil {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w.hash, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		member := archiveMember{name: entry.Name, size: int64(entry.UncompressedSize64), modTime: entry.Modified}
+		reader, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+		err = w.visit(member, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit checks a member against the limits and hands it to fn, cutting the
+// read off where a limit is reached whatever the header claimed.
+func (w *archiveWalker) visit(member archiveMember, r io.Reader) error {
+	w.members++
+	if w.members > w.limits.MaxMembers {
+		return fmt.Errorf("%w: more than %d members", errArchiveLimit, w.limits.MaxMembers)
+	}
+
+	// Members that are hostile or too large to analyze are skipped rather
+	// than failing the whole archive
+	name, ok := cleanMemberName(member.name, w.limits.MaxPathLength)
+	if !ok {
+		w.skip(member.name, "unsafe or overlong member name")
+		return nil
+	}
+	member.name = name
+
+	if member.size > w.limits.MaxMemberSize {
+		w.skip(name, fmt.Sprintf("larger than the %d byte member limit", w.limits.MaxMemberSize))
+		return nil
+	}
+
+	limited := &limitedReader{r: r, remaining: w.limits.MaxMemberSize, name: name}
+	if remaining := w.limits.MaxTotalSize - w.total; remaining < limited.remaining {
+		limited.remaining = remaining
+	}
+	err := w.fn(member, limited)
+	w.total += limited.read
+	return err
+}
+
+// cleanMemberName normalises a member name to a relative slash path. Names
+// that are absolute, escape the archive with .. or are too long are refused.
+func cleanMemberName(name string, maxLength int) (string, bool) {
+	if len(name) > maxLength || strings.ContainsRune(name, 0) {
+		return "", false
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// limitedReader fails once more than remaining bytes are read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	read      int64
+	name      string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Fine if the member ends exactly at the limit
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("%w: reading %s", errArchiveLimit, l.name)
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	l.read += int64(n)
+	return n, err
+}
+
+// memberPath is how a member is shown, as in triage.tar.gz!/var/log/auth.log.
+func memberPath(archivePath, member string) string {
+	return archivePath + archiveSeparator + member
+}
+
+// relativeLogPath shows a log file relative to the collection's base path.
+// Members of an archive keep the archive name, as in
+// triage.tar.gz!/var/log/auth.log.
+func relativeLogPath(basePath, logFile string) string {
+	if archivePath, member, ok := strings.Cut(logFile, archiveSeparator); ok && archivePath == basePath {
+		return filepath.Base(archivePath) + archiveSeparator + member
+	}
+	relativePath, err := filepath.Rel(basePath, logFile)
+	if err != nil {
+		return logFile
+	}
+	return relativePath
+}
+
+// trimArchiveExt strips an archive extension, so uac-web01-...tar.gz names
+// its host like the directory it unpacks to.
+func trimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
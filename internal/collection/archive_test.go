@@ -0,0 +1,182 @@
+package collection
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const archiveLine = "Jan  1 10:30:00 localhost sshd[1]: Failed password for root from 10.0.0.1 port 22 ssh2\n"
+
+// archiveMembers are the files written to test archives.
+func archiveMembers() map[string][]byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(strings.Repeat(archiveLine, 2)))
+	writer.Close()
+
+	return map[string][]byte{
+		"uac-web01-linux-20240101120000/[root]/var/log/auth.log":      []byte(strings.Repeat(archiveLine, 3)),
+		"uac-web01-linux-20240101120000/[root]/var/log/auth.log.1.gz": compressed.Bytes(),
+		"../evil/auth.log": []byte(strings.Repeat(archiveLine, 3)),
+	}
+}
+
+func sortedNames(members map[string][]byte) []string {
+	var names []string
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTarGz(t *testing.T, path string, members map[string][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	compressor := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(compressor)
+	for _, name := range sortedNames(members) {
+		data := members[name]
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		archive.Write(data)
+	}
+	archive.Close()
+	compressor.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+}
+
+func writeZip(t *testing.T, path string, members map[string][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range sortedNames(members) {
+		data := members[name]
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+		writer.Write(data)
+	}
+	archive.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+}
+
+func TestCollector_Archive(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(*testing.T, string, map[string][]byte)
+	}{
+		{"triage.tar.gz", writeTarGz},
+		{"triage.zip", writeZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(tmpDir, tt.name)
+			tt.write(t, archivePath, archiveMembers())
+			if !IsArchive(archivePath) {
+				t.Fatalf("IsArchive(%s) = false", archivePath)
+			}
+
+			collection, err := NewCollector(archivePath).DiscoverLogFiles()
+			if err != nil {
+				t.Fatalf("DiscoverLogFiles() error = %v", err)
+			}
+
+			// The member escaping the archive is skipped
+			if len(collection.LogFiles) != 2 {
+				t.Fatalf("Expected 2 log files, got %d: %+v", len(collection.LogFiles), collection.LogFiles)
+			}
+			expected := memberPath(archivePath, "uac-web01-linux-20240101120000/[root]/var/log/auth.log")
+			if collection.LogFiles[1].Path != expected {
+				t.Errorf("Path = %q, expected %q", collection.LogFiles[1].Path, expected)
+			}
+			for _, logFile := range collection.LogFiles {
+				if logFile.Type != "syslog" || logFile.Host != "web01" {
+					t.Errorf("%s detected as %q from %q", logFile.Path, logFile.Type, logFile.Host)
+				}
+			}
+
+			analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+			if err != nil {
+				t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+			}
+			result, err := analyzer.AnalyzeCollection()
+			if err != nil {
+				t.Fatalf("AnalyzeCollection() error = %v", err)
+			}
+			if result.FailedFiles != 0 || result.TotalMatches != 5 {
+				t.Errorf("Expected 5 matches and no failures, got %d and %d", result.TotalMatches, result.FailedFiles)
+			}
+			if got := relativeLogPath(archivePath, expected); got != tt.name+"!/uac-web01-linux-20240101120000/[root]/var/log/auth.log" {
+				t.Errorf("relativeLogPath() = %q", got)
+			}
+		})
+	}
+}
+
+func TestWalkArchive_Limits(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "triage.tar.gz")
+	writeTarGz(t, archivePath, archiveMembers())
+
+	tests := []struct {
+		name    string
+		limits  ArchiveLimits
+		members int
+		wantErr bool
+	}{
+		{"defaults", DefaultArchiveLimits, 2, false},
+		{"member size", ArchiveLimits{MaxMemberSize: 200, MaxTotalSize: 1 << 20, MaxMembers: 10, MaxPathLength: 4096}, 1, false},
+		{"total size", ArchiveLimits{MaxMemberSize: 1 << 20, MaxTotalSize: 300, MaxMembers: 10, MaxPathLength: 4096}, 1, true},
+		{"member count", ArchiveLimits{MaxMemberSize: 1 << 20, MaxTotalSize: 1 << 20, MaxMembers: 2, MaxPathLength: 4096}, 1, true},
+		{"path length", ArchiveLimits{MaxMemberSize: 1 << 20, MaxTotalSize: 1 << 20, MaxMembers: 10, MaxPathLength: 20}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := 0
+			err := walkArchive(archivePath, tt.limits, func(member archiveMember, r io.Reader) error {
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					return err
+				}
+				members++
+				return nil
+			}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("walkArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errArchiveLimit) {
+				t.Errorf("Expected a limit error, got %v", err)
+			}
+			if members != tt.members {
+				t.Errorf("Read %d members, expected %d", members, tt.members)
+			}
+		})
+	}
+}
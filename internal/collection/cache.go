@@ -0,0 +1,352 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+	"github.com/wellknittech/hayanix/internal/rules"
+)
+
+// ResultCache keeps the matches of each rule against each analyzed file on
+// disk, so a collection can be analyzed again without re-evaluating rules
+// against files they were already evaluated against. Files are identified by
+// their SHA-256 and the settings they were parsed with, rules by their ID
+// and the SHA-256 of their file. Results cover the whole file: the time
+// window is applied to them as they are read back. Files are hashed as they
+// are analyzed, so the cache also remembers what each file last hashed to,
+// to find its results before reading it.
+type ResultCache struct {
+	dir string
+}
+
+// NewResultCache uses dir for the cache, creating it when results are
+// stored.
+func NewResultCache(dir string) *ResultCache {
+	return &ResultCache{dir: dir}
+}
+
+// cacheEntry is what is cached for one file.
+type cacheEntry struct {
+	// Rules are the keys of the rules the file was evaluated against.
+	Rules map[string]bool `json:"rules"`
+	// Matches are the entries any of those rules matched.
+	Matches []cachedMatch `json:"matches"`
+}
+
+// cachedMatch is a matching entry, at Index among the file's entries, with
+// the keys of the rules that matched it.
+type cachedMatch struct {
+	Index int             `json:"index"`
+	Rules []string        `json:"rules"`
+	Entry parser.LogEntry `json:"entry"`
+}
+
+// ruleKey identifies a rule by its ID and content, so an edited rule is
+// evaluated again.
+func ruleKey(rule rules.Rule) string {
+	return rule.ID + "@" + rule.Digest()
+}
+
+// fileKey identifies a file's parsed entries: its contents, its type and
+// everything that changes how it is parsed, except the time window.
+func fileKey(logFile LogFile, opts parser.Options, toolVersion string) string {
+	location := "Local"
+	if opts.Location != nil {
+		location = opts.Location.String()
+	}
+	timeKey := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+
+	key := strings.Join([]string{
+		logFile.Hashes.SHA256,
+		logFile.Type,
+		toolVersion,
+		location,
+		fmt.Sprint(opts.Year),
+		timeKey(opts.Reference),
+		opts.Host,
+	}, "\n")
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+// fileIdentity identifies a file by where it is and its metadata, so its hash
+// can be guessed before it is read. Members of an archive are identified
+// along with the archive.
+func fileIdentity(logFile LogFile, archive *ManifestEntry) string {
+	fields := []string{
+		logFile.Path,
+		fmt.Sprint(logFile.Size),
+		logFile.ModTime.UTC().Format(time.RFC3339Nano),
+		fmt.Sprint(logFile.Inode),
+	}
+	if logFile.Archive != "" && archive != nil {
+		fields = append(fields,
+			fmt.Sprint(archive.Size),
+			archive.ModTime.UTC().Format(time.RFC3339Nano),
+			fmt.Sprint(archive.Inode))
+	}
+	digest := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(digest[:])
+}
+
+func (c *ResultCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *ResultCache) hintPath(identity string) string {
+	return filepath.Join(c.dir, "hints", identity[:2], identity+".json")
+}
+
+// hint is the SHA-256 a file last hashed to.
+type hint struct {
+	SHA256 string `json:"sha256"`
+}
+
+// guess returns the SHA-256 the file with identity last hashed to, or "" if
+// it wasn't analyzed before. Like loading an entry, it marks the hint used.
+func (c *ResultCache) guess(identity string) string {
+	path := c.hintPath(identity)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var h hint
+	if err := json.Unmarshal(data, &h); err != nil {
+		return ""
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return h.SHA256
+}
+
+// remember records the SHA-256 the file with identity hashed to.
+func (c *ResultCache) remember(identity, sha256 string) error {
+	data, err := json.Marshal(hint{SHA256: sha256})
+	if err != nil {
+		return fmt.Errorf("failed to encode cache hint: %w", err)
+	}
+	return writeAtomic(c.hintPath(identity), data)
+}
+
+// load returns what is cached for key, or an empty entry. Loading an entry
+// marks it used, for Prune.
+func (c *ResultCache) load(key string) *cacheEntry {
+	entry := &cacheEntry{Rules: make(map[string]bool)}
+
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry
+	}
+	if err := json.Unmarshal(data, entry); err != nil || entry.Rules == nil {
+		// A corrupt entry is as good as none
+		return &cacheEntry{Rules: make(map[string]bool)}
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return entry
+}
+
+// store saves entry under key, replacing it atomically.
+func (c *ResultCache) store(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	return writeAtomic(c.path(key), data)
+}
+
+// writeAtomic writes data to the cache file at path, replacing it
+// atomically.
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// missing returns the IDs of the rules the file wasn't evaluated against.
+func (e *cacheEntry) missing(ruleList []rules.Rule) []string {
+	var ids []string
+	for _, rule := range ruleList {
+		if !e.Rules[ruleKey(rule)] {
+			ids = append(ids, rule.ID)
+		}
+	}
+	return ids
+}
+
+// add records matches of newly evaluated rules, a subset of the loaded
+// rules in ruleList. The entry is left covering the loaded rules alone:
+// keys and matches of rules that were since edited or removed are dropped,
+// so they don't build up as the rules change.
+func (e *cacheEntry) add(ruleList, evaluated []rules.Rule, matches map[int]parser.LogEntry) {
+	current := make(map[string]bool, len(ruleList))
+	for _, rule := range ruleList {
+		current[ruleKey(rule)] = true
+	}
+	keys := make(map[string]string, len(evaluated))
+	for _, rule := range evaluated {
+		keys[rule.ID] = ruleKey(rule)
+	}
+
+	// Keep what the cache already held for the loaded rules
+	covered := make(map[string]bool, len(ruleList))
+	for key := range e.Rules {
+		if current[key] {
+			covered[key] = true
+		}
+	}
+	for _, key := range keys {
+		covered[key] = true
+	}
+	e.Rules = covered
+
+	kept := e.Matches[:0]
+	existing := make(map[int]int, len(e.Matches))
+	for _, match := range e.Matches {
+		var matched []string
+		for _, key := range match.Rules {
+			if current[key] {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		match.Rules = matched
+		existing[match.Index] = len(kept)
+		kept = append(kept, match)
+	}
+	e.Matches = kept
+
+	for index, entry := range matches {
+		var matched []string
+		for _, id := range entry.MatchedRules {
+			matched = append(matched, keys[id])
+		}
+		if i, ok := existing[index]; ok {
+			e.Matches[i].Rules = append(e.Matches[i].Rules, matched...)
+			continue
+		}
+		entry.MatchedRules = nil
+		e.Matches = append(e.Matches, cachedMatch{Index: index, Rules: matched, Entry: entry})
+	}
+
+	sort.Slice(e.Matches, func(i, j int) bool { return e.Matches[i].Index < e.Matches[j].Index })
+}
+
+// entries returns the cached entries matched by the loaded rules, in file
+// order, with MatchedRules in rule order. Matches of rules that were since
+// removed or changed are left out.
+func (e *cacheEntry) entries(ruleList []rules.Rule) []parser.LogEntry {
+	order := make(map[string]int, len(ruleList))
+	ids := make(map[string]string, len(ruleList))
+	for i, rule := range ruleList {
+		order[ruleKey(rule)] = i
+		ids[ruleKey(rule)] = rule.ID
+	}
+
+	var entries []parser.LogEntry
+	for _, match := range e.Matches {
+		var matched []string
+		for _, key := range match.Rules {
+			if _, ok := order[key]; ok {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		sort.Slice(matched, func(i, j int) bool { return order[matched[i]] < order[matched[j]] })
+
+		entry := match.Entry
+		entry.MatchedRules = make([]string, len(matched))
+		for i, key := range matched {
+			entry.MatchedRules[i] = ids[key]
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// windowed returns the entries that fall within the time window of opts.
+func windowed(entries []parser.LogEntry, opts parser.Options) []parser.LogEntry {
+	if opts.Start.IsZero() && opts.End.IsZero() {
+		return entries
+	}
+	var kept []parser.LogEntry
+	for _, entry := range entries {
+		if opts.InWindow(entry) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// Prune removes cache entries that haven't been used for olderThan, or all of
+// them if olderThan is 0, and returns how many were removed and their size.
+func (c *ResultCache) Prune(olderThan time.Duration) (int, int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	var freed int64
+
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == c.dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		if olderThan > 0 && info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove cache entry %s: %w", path, err)
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, fmt.Errorf("failed to prune cache %s: %w", c.dir, err)
+	}
+	return removed, freed, nil
+}
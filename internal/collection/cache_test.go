@@ -0,0 +1,269 @@
+package collection
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+const acceptedRule = `title: Accepted Login
+id: accepted-login
+logsource:
+    product: linux
+    service: syslog
+detection:
+    keywords:
+        - 'accepted password'
+    condition: keywords`
+
+func TestCollectionAnalyzer_Cache(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	logDir := filepath.Join(tmpDir, "evidence")
+	cacheDir := filepath.Join(tmpDir, "cache")
+	for _, dir := range []string{rulesDir, logDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	writeRule := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(rulesDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write rule: %v", err)
+		}
+	}
+	writeRule("failed.yml", testRule)
+
+	content := "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n" +
+		"Jan  1 10:30:01 web01 sshd[1]: Accepted password for alice\n" +
+		"Jan  1 10:30:02 web01 sshd[1]: Failed password for admin\n" +
+		"Jan  1 10:30:03 web01 sshd[1]: Accepted password for bob\n" +
+		"Jan  1 10:30:04 web01 sshd[1]: Failed password for guest\n"
+	if err := os.WriteFile(filepath.Join(logDir, "auth.log"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	collection, err := NewCollector(logDir).DiscoverLogFiles()
+	if err != nil {
+		t.Fatalf("DiscoverLogFiles() error = %v", err)
+	}
+
+	opts := parser.Options{Location: time.UTC}
+	var year int
+	analyze := func(cached bool) []string {
+		t.Helper()
+		analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+		if err != nil {
+			t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+		}
+		analyzer.SetThreads(1)
+		analyzer.SetParserOptions(opts)
+		if cached {
+			analyzer.SetCache(NewResultCache(cacheDir))
+		}
+		result, err := analyzer.AnalyzeCollection()
+		if err != nil {
+			t.Fatalf("AnalyzeCollection() error = %v", err)
+		}
+		if result.FailedFiles != 0 {
+			t.Fatalf("Analysis failed: %v", result.Results[0].Error)
+		}
+
+		var matches []string
+		for _, entry := range result.Results[0].Entries {
+			matches = append(matches, entry.Time.Format("05")+" "+strings.Join(entry.MatchedRules, ","))
+			year = entry.Time.Year()
+		}
+		if result.TotalMatches != len(matches) {
+			t.Errorf("TotalMatches = %d, expected %d", result.TotalMatches, len(matches))
+		}
+		if result.Results[0].Cached != (result.CachedFiles == 1) {
+			t.Errorf("Cached = %v but CachedFiles = %d", result.Results[0].Cached, result.CachedFiles)
+		}
+		return matches
+	}
+	expect := func(step string, matches []string, cached bool, want ...string) {
+		t.Helper()
+		if strings.Join(matches, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: got matches %q, expected %q", step, matches, want)
+		}
+		entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*.json"))
+		if cached && len(entries) != 1 {
+			t.Errorf("%s: expected 1 cache entry, got %d", step, len(entries))
+		}
+	}
+
+	// Without the cache nothing is stored
+	expect("no cache", analyze(false), false, "00 failed-login", "02 failed-login", "04 failed-login")
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no cache directory without a cache, got %v", err)
+	}
+
+	expect("first run", analyze(true), true, "00 failed-login", "02 failed-login", "04 failed-login")
+
+	// Tamper with the cached results, so reuse shows
+	entryPaths, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*.json"))
+	if len(entryPaths) != 1 {
+		t.Fatalf("Expected 1 cache entry, got %d", len(entryPaths))
+	}
+	var entry cacheEntry
+	data, err := os.ReadFile(entryPaths[0])
+	if err != nil {
+		t.Fatalf("Failed to read cache entry: %v", err)
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Failed to decode cache entry: %v", err)
+	}
+	entry.Matches = entry.Matches[:2]
+	data, _ = json.Marshal(entry)
+	if err := os.WriteFile(entryPaths[0], data, 0644); err != nil {
+		t.Fatalf("Failed to write cache entry: %v", err)
+	}
+
+	expect("cached", analyze(true), true, "00 failed-login", "02 failed-login")
+
+	// A new rule is evaluated on its own, merged with the cached results
+	writeRule("accepted.yml", acceptedRule)
+	expect("new rule", analyze(true), true,
+		"00 failed-login", "01 accepted-login", "02 failed-login", "03 accepted-login")
+	expect("new rule cached", analyze(true), true,
+		"00 failed-login", "01 accepted-login", "02 failed-login", "03 accepted-login")
+
+	// A changed rule is evaluated again, and a removed one drops out
+	writeRule("failed.yml", strings.Replace(testRule, "Failed Login", "Failed SSH Login", 1))
+	if err := os.Remove(filepath.Join(rulesDir, "accepted.yml")); err != nil {
+		t.Fatalf("Failed to remove rule: %v", err)
+	}
+	expect("changed rule", analyze(true), true, "00 failed-login", "02 failed-login", "04 failed-login")
+
+	// Keys of the old and removed rules are pruned rather than kept alongside
+	data, err = os.ReadFile(entryPaths[0])
+	if err != nil {
+		t.Fatalf("Failed to read cache entry: %v", err)
+	}
+	entry = cacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Failed to decode cache entry: %v", err)
+	}
+	if len(entry.Rules) != 1 {
+		t.Errorf("Expected 1 rule key in the cache entry, got %v", entry.Rules)
+	}
+	if len(entry.Matches) != 3 {
+		t.Errorf("Expected 3 cached matches, got %d", len(entry.Matches))
+	}
+	for _, match := range entry.Matches {
+		if len(match.Rules) != 1 || !entry.Rules[match.Rules[0]] {
+			t.Errorf("Cached match %d has stale rule keys %v", match.Index, match.Rules)
+		}
+	}
+
+	// The time window applies to cached results rather than keying them
+	opts.Start = time.Date(year, time.January, 1, 10, 30, 1, 0, time.UTC)
+	opts.End = time.Date(year, time.January, 1, 10, 30, 3, 0, time.UTC)
+	expect("windowed", analyze(true), true, "02 failed-login")
+	writeRule("accepted.yml", acceptedRule)
+	expect("windowed new rule", analyze(true), true, "01 accepted-login", "02 failed-login", "03 accepted-login")
+	opts.Start, opts.End = time.Time{}, time.Time{}
+	expect("whole file", analyze(true), true,
+		"00 failed-login", "01 accepted-login", "02 failed-login", "03 accepted-login", "04 failed-login")
+
+	// Recently used entries survive pruning by age
+	cache := NewResultCache(cacheDir)
+	if removed, _, err := cache.Prune(time.Hour); err != nil || removed != 0 {
+		t.Errorf("Prune(1h) = %d, %v; expected nothing removed", removed, err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(entryPaths[0], old, old); err != nil {
+		t.Fatalf("Failed to age cache entry: %v", err)
+	}
+	removed, freed, err := cache.Prune(time.Hour)
+	if err != nil || removed != 1 || freed == 0 {
+		t.Errorf("Prune(1h) = %d, %d, %v; expected 1 entry removed", removed, freed, err)
+	}
+	if removed, _, err := NewResultCache(filepath.Join(tmpDir, "missing")).Prune(0); err != nil || removed != 0 {
+		t.Errorf("Prune() of a missing cache = %d, %v; expected nothing", removed, err)
+	}
+}
+
+func TestCollectionAnalyzer_CacheChangedFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	logDir := filepath.Join(tmpDir, "evidence")
+	cacheDir := filepath.Join(tmpDir, "cache")
+	for _, dir := range []string{rulesDir, logDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	logPath := filepath.Join(logDir, "auth.log")
+	modTime := time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)
+	writeLog := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+		if err := os.Chtimes(logPath, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	analyze := func() (AnalysisResult, LogFile) {
+		t.Helper()
+		collection, err := NewCollector(logDir).DiscoverLogFiles()
+		if err != nil {
+			t.Fatalf("DiscoverLogFiles() error = %v", err)
+		}
+		analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+		if err != nil {
+			t.Fatalf("NewCollectionAnalyzer() error = %v", err)
+		}
+		analyzer.SetCache(NewResultCache(cacheDir))
+		result, err := analyzer.AnalyzeCollection()
+		if err != nil {
+			t.Fatalf("AnalyzeCollection() error = %v", err)
+		}
+		if result.FailedFiles != 0 {
+			t.Fatalf("Analysis failed: %v", result.Results[0].Error)
+		}
+		return result.Results[0], collection.LogFiles[0]
+	}
+
+	failed := "Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n"
+	accepted := "Jan  1 10:30:00 web01 sshd[1]: Accepted password for al\n"
+	writeLog(failed + accepted)
+	if result, _ := analyze(); result.Cached || result.MatchCount != 1 {
+		t.Errorf("First run: expected 1 match analyzed, got %d, cached %v", result.MatchCount, result.Cached)
+	}
+
+	// A file cached under the same metadata is only hashed, and still
+	// recorded for the manifest
+	result, logFile := analyze()
+	if !result.Cached || result.MatchCount != 1 {
+		t.Errorf("Second run: expected 1 cached match, got %d, cached %v", result.MatchCount, result.Cached)
+	}
+	if logFile.Hashes.SHA256 != sha256Hex(failed+accepted) {
+		t.Errorf("Second run: expected sha256 %s, got %s", sha256Hex(failed+accepted), logFile.Hashes.SHA256)
+	}
+
+	// Rewritten to the same size and modification time, the hash tells
+	writeLog(failed + failed)
+	result, logFile = analyze()
+	if result.Cached || result.MatchCount != 2 {
+		t.Errorf("Changed file: expected 2 matches analyzed, got %d, cached %v", result.MatchCount, result.Cached)
+	}
+	if logFile.Hashes.SHA256 != sha256Hex(failed+failed) {
+		t.Errorf("Changed file: expected sha256 %s, got %s", sha256Hex(failed+failed), logFile.Hashes.SHA256)
+	}
+	if result, _ := analyze(); !result.Cached || result.MatchCount != 2 {
+		t.Errorf("Changed file cached: expected 2 cached matches, got %d, cached %v", result.MatchCount, result.Cached)
+	}
+}
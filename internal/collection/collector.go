@@ -1,29 +1,58 @@
 package collection
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/wellknittech/hayanix/internal/parser"
 )
 
 type Collector struct {
-	basePath string
+	basePath  string
+	hosts     map[string]string
+	limits    ArchiveLimits
+	discovery DiscoveryOptions
+	extra     ExtraHashes
 }
 
 type LogFile struct {
-	Path     string
-	Type     string
-	Size     int64
-	Modified string
+	Path string
+	Type string
+	// Confidence is how sure the type detection is, from 0 to 1: the share
+	// of sniffed lines the parser recognised, or less for types guessed
+	// from the file name.
+	Confidence float64
+	Size       int64
+	Modified   string
+	// Host is the host the file came from, if it could be told.
+	Host string
+
+	// Archive and Member locate a file inside an archive; Path then reads
+	// archive!/member.
+	Archive string
+	Member  string
+	ModTime time.Time
+
+	// Inode is the file's inode number, where known. Hashes are of the file
+	// as stored, for the manifest, computed as it is analyzed; they cover
+	// its first Size bytes, which are all that is analyzed.
+	Inode  uint64
+	Hashes FileHashes
 }
 
 type Collection struct {
 	BasePath string
 	LogFiles []LogFile
 	Summary  CollectionSummary
+	// Archive records the archive the collection was read from, if any.
+	Archive *ManifestEntry
+	// ExtraHashes are the digests computed for the manifest besides SHA-256.
+	ExtraHashes ExtraHashes
 }
 
 type CollectionSummary struct {
@@ -31,64 +60,156 @@ type CollectionSummary struct {
 	TotalSize       int64
 	FilesByType     map[string]int
 	SizeByType      map[string]int64
+	FilesByHost     map[string]int
 	CompatibleTypes []string
+	// Skipped lists what wasn't examined, and why.
+	Skipped []SkippedFile
 }
 
 func NewCollector(basePath string) *Collector {
 	return &Collector{
-		basePath: basePath,
+		basePath:  basePath,
+		limits:    DefaultArchiveLimits,
+		discovery: DefaultDiscoveryOptions,
 	}
 }
 
+// SetDiscoveryOptions controls which files DiscoverLogFiles examines.
+func (c *Collector) SetDiscoveryOptions(opts DiscoveryOptions) {
+	c.discovery = opts
+}
+
+// SetExtraHashes adds MD5 and SHA-1 digests to the SHA-256 computed for
+// every file in the collection as it is analyzed.
+func (c *Collector) SetExtraHashes(md5, sha1 bool) {
+	c.extra = ExtraHashes{MD5: md5, SHA1: sha1}
+}
+
+// SetArchiveLimits bounds what is read when the base path is an archive.
+func (c *Collector) SetArchiveLimits(limits ArchiveLimits) {
+	c.limits = limits
+}
+
+// SetHostMap names the hosts that files came from by their path relative to
+// the collection's base path, overriding the host inferred from the layout.
+func (c *Collector) SetHostMap(hosts map[string]string) {
+	c.hosts = hosts
+}
+
 func (c *Collector) DiscoverLogFiles() (*Collection, error) {
 	collection := &Collection{
-		BasePath: c.basePath,
-		LogFiles: make([]LogFile, 0),
+		BasePath:    c.basePath,
+		ExtraHashes: c.extra,
+		LogFiles:    make([]LogFile, 0),
 		Summary: CollectionSummary{
 			FilesByType:     make(map[string]int),
 			SizeByType:      make(map[string]int64),
-			CompatibleTypes: []string{"syslog", "journald", "auditd"},
+			FilesByHost:     make(map[string]int),
+			CompatibleTypes: append([]string(nil), parser.Targets...),
 		},
 	}
 
 	// Check if base path exists
-	if _, err := os.Stat(c.basePath); os.IsNotExist(err) {
+	info, err := os.Stat(c.basePath)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("path does not exist: %s", c.basePath)
 	}
 
-	// Walk through the directory tree
-	err := filepath.Walk(c.basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	add := func(logFile LogFile) {
+		collection.LogFiles = append(collection.LogFiles, logFile)
+		collection.Summary.FilesByType[logFile.Type]++
+		collection.Summary.SizeByType[logFile.Type] += logFile.Size
+		if logFile.Host != "" {
+			collection.Summary.FilesByHost[logFile.Host]++
 		}
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	skip := func(path, reason string) {
+		collection.Summary.Skipped = append(collection.Summary.Skipped, SkippedFile{Path: path, Reason: reason})
+	}
+
+	if err == nil && !info.IsDir() && IsArchive(c.basePath) {
+		// The archive is evidence in its own right; it is hashed as it is
+		// analyzed, like the files in it
+		collection.Archive = &ManifestEntry{
+			Path:    c.basePath,
+			Type:    "archive",
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			Inode:   fileInode(info),
 		}
 
-		// Check if file is a log file
-		logType := c.detectLogType(path, info)
-		if logType != "" {
-			logFile := LogFile{
-				Path:     path,
-				Type:     logType,
-				Size:     info.Size(),
-				Modified: info.ModTime().Format("2006-01-02 15:04:05"),
+		// Stream the members of an archive without extracting them
+		err = walkArchive(c.basePath, c.limits, func(member archiveMember, r io.Reader) error {
+			path := memberPath(c.basePath, member.name)
+			if reason := c.discovery.skipReason(member.name, member.size); reason != "" {
+				skip(path, reason)
+				return nil
 			}
 
-			collection.LogFiles = append(collection.LogFiles, logFile)
-			collection.Summary.FilesByType[logType]++
-			collection.Summary.SizeByType[logType] += info.Size()
-		}
+			head, err := readHead(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", member.name, err)
+			}
 
-		return nil
-	})
+			logType, confidence := c.detectLogType(member.name, head)
+			if logType == "" {
+				skip(path, "not a recognised log format")
+				return nil
+			}
+			add(LogFile{
+				Path:       path,
+				Type:       logType,
+				Confidence: confidence,
+				Size:       member.size,
+				Modified:   member.modTime.Format("2006-01-02 15:04:05"),
+				Host:       c.inferHost(path, member.name),
+				Archive:    c.basePath,
+				Member:     member.name,
+				ModTime:    member.modTime,
+			})
+			return nil
+		}, func(name, reason string) {
+			skip(memberPath(c.basePath, name), reason)
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Walk through the directory tree
+		err := c.walkCollection(func(path, rel string, info os.FileInfo) error {
+			// Check if file is a log file
+			head, err := readFileHead(path)
+			if err != nil {
+				skip(path, fmt.Sprintf("unreadable: %v", err))
+				return nil
+			}
+			logType, confidence := c.detectLogType(path, head)
+			if logType == "" {
+				skip(path, "not a recognised log format")
+				return nil
+			}
+			add(LogFile{
+				Path:       path,
+				Type:       logType,
+				Confidence: confidence,
+				Size:       info.Size(),
+				Modified:   info.ModTime().Format("2006-01-02 15:04:05"),
+				Host:       c.inferHost(path, rel),
+				ModTime:    info.ModTime(),
+				Inode:      fileInode(info),
+			})
+			return nil
+		}, skip)
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory: %w", err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %w", err)
+		}
 	}
 
+	// Rotated logs are analyzed oldest first
+	sortRotations(collection.LogFiles)
+
 	// Calculate summary
 	collection.Summary.TotalFiles = len(collection.LogFiles)
 	for _, size := range collection.Summary.SizeByType {
@@ -98,76 +219,91 @@ func (c *Collector) DiscoverLogFiles() (*Collection, error) {
 	return collection, nil
 }
 
-func (c *Collector) detectLogType(path string, info os.FileInfo) string {
-	// Get file extension and basename
-	ext := strings.ToLower(filepath.Ext(path))
-	basename := strings.ToLower(filepath.Base(path))
-	dirname := strings.ToLower(filepath.Dir(path))
+// Confidence thresholds for content sniffing. A type recognised in at least
+// conclusiveConfidence of a file's first lines is taken as it is; below that,
+// filename hints decide, with at least hintConfidence, and a file without one
+// is left unrecognised.
+const (
+	conclusiveConfidence = 0.5
+	hintConfidence       = 0.25
+)
 
-	// Skip very small files (likely not real logs)
-	if info.Size() < 100 {
-		return ""
+// detectLogType picks the log type of a file by sniffing its first bytes,
+// decompressed, against every parser, and reports how confident it is. The
+// file's name and directory are only consulted when sniffing is
+// inconclusive.
+func (c *Collector) detectLogType(path string, head []byte) (string, float64) {
+	// Get basename and directory, looking past compression and rotation so
+	// rotated logs like auth.log.2.gz are recognised
+	rotatedFrom, _ := rotationKey(path)
+	basename := strings.ToLower(filepath.Base(rotatedFrom))
+	dirname := strings.ToLower(filepath.ToSlash(filepath.Dir(path)))
+
+	// Pick the best-scoring parser, ties going to the first registered
+	scores := parser.Sniff(head)
+	best, confidence := "", 0.0
+	for _, target := range parser.Targets {
+		if scores[target] > confidence {
+			best, confidence = target, scores[target]
+		}
 	}
 
-	// Skip binary files and common non-log extensions
-	skipExts := map[string]bool{
-		".exe": true, ".bin": true, ".so": true, ".dll": true,
-		".zip": true, ".tar": true, ".gz": true, ".bz2": true,
-		".pdf": true, ".doc": true, ".docx": true, ".xls": true,
-		".jpg": true, ".png": true, ".gif": true, ".mp4": true,
+	switch {
+	case confidence >= conclusiveConfidence:
+		return best, confidence
+	case scores != nil && confidence == 0:
+		// Readable, but in no format we parse, like nginx/error.log
+		return "", 0
 	}
 
-	if skipExts[ext] {
-		return ""
+	// Sniffing is inconclusive, so fall back to filename hints
+	if hint := logTypeHint(basename, dirname); hint != "" {
+		return hint, max(scores[hint], hintConfidence)
 	}
+	return "", 0
+}
 
-	// Detect log type based on filename patterns
-	logPatterns := map[string][]string{
-		"syslog": {
-			"messages", "syslog", "auth", "auth.log", "secure",
-			"mail", "mail.log", "daemon", "daemon.log",
-			"kern", "kern.log", "user", "user.log",
-			"local0", "local1", "local2", "local3",
+// logTypeHint guesses a log type from well-known file and directory names.
+func logTypeHint(basename, dirname string) string {
+	// Check filename patterns
+	filePatterns := []struct {
+		logType  string
+		patterns []string
+	}{
+		{"journald", []string{"journal"}},
+		{"auditd", []string{"audit"}},
+		{"syslog", []string{
+			"messages", "syslog", "auth", "secure", "mail", "daemon",
+			"kern", "user", "local0", "local1", "local2", "local3",
 			"local4", "local5", "local6", "local7",
-		},
-		"journald": {
-			"journal", "system.journal", "user.journal",
-		},
-		"auditd": {
-			"audit.log", "audit", "auditd",
-		},
+		}},
 	}
 
-	// Check filename patterns
-	for logType, patterns := range logPatterns {
-		for _, pattern := range patterns {
+	for _, hint := range filePatterns {
+		for _, pattern := range hint.patterns {
 			if strings.Contains(basename, pattern) {
-				return logType
+				return hint.logType
 			}
 		}
 	}
 
 	// Check directory patterns
-	dirPatterns := map[string][]string{
-		"syslog":   {"log", "logs", "var/log"},
-		"journald": {"journal", "systemd"},
-		"auditd":   {"audit", "auditd"},
+	dirPatterns := []struct {
+		logType  string
+		patterns []string
+	}{
+		{"journald", []string{"journal"}},
+		{"auditd", []string{"audit"}},
 	}
 
-	for logType, patterns := range dirPatterns {
-		for _, pattern := range patterns {
+	for _, hint := range dirPatterns {
+		for _, pattern := range hint.patterns {
 			if strings.Contains(dirname, pattern) {
-				return logType
+				return hint.logType
 			}
 		}
 	}
 
-	// Check file extension
-	if ext == ".log" || ext == "" {
-		// Default to syslog for .log files and files without extensions
-		return "syslog"
-	}
-
 	return ""
 }
 
@@ -179,18 +315,30 @@ func (c *Collector) FilterByType(collection *Collection, logType string) *Collec
 	filtered := &Collection{
 		BasePath: collection.BasePath,
 		LogFiles: make([]LogFile, 0),
+		Archive:  collection.Archive,
 		Summary: CollectionSummary{
 			FilesByType:     make(map[string]int),
 			SizeByType:      make(map[string]int64),
+			FilesByHost:     make(map[string]int),
 			CompatibleTypes: collection.Summary.CompatibleTypes,
+			Skipped:         append([]SkippedFile(nil), collection.Summary.Skipped...),
 		},
 	}
 
 	for _, logFile := range collection.LogFiles {
-		if logFile.Type == logType {
-			filtered.LogFiles = append(filtered.LogFiles, logFile)
-			filtered.Summary.FilesByType[logFile.Type]++
-			filtered.Summary.SizeByType[logFile.Type] += logFile.Size
+		if logFile.Type != logType {
+			filtered.Summary.Skipped = append(filtered.Summary.Skipped, SkippedFile{
+				Path:   logFile.Path,
+				Reason: fmt.Sprintf("%s log filtered out by type", logFile.Type),
+			})
+			continue
+		}
+
+		filtered.LogFiles = append(filtered.LogFiles, logFile)
+		filtered.Summary.FilesByType[logFile.Type]++
+		filtered.Summary.SizeByType[logFile.Type] += logFile.Size
+		if logFile.Host != "" {
+			filtered.Summary.FilesByHost[logFile.Host]++
 		}
 	}
 
@@ -229,3 +377,37 @@ func (c *Collector) ValidateCollection(collection *Collection) error {
 
 	return nil
 }
+
+// minLogSize is the size below which files are unlikely to be real logs.
+const minLogSize = 100
+
+// readHead reads the first bytes of a log, decompressed, for detection.
+func readHead(r io.Reader) ([]byte, error) {
+	reader, err := parser.Decompress(r)
+	if err != nil {
+		// Corrupt compressed data is left to the parser to report
+		return nil, nil
+	}
+	defer reader.Close()
+
+	head := make([]byte, parser.SniffSize)
+	n, err := io.ReadFull(reader, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if errors.Is(err, errArchiveLimit) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// readFileHead reads the first bytes of a file, decompressed.
+func readFileHead(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readHead(file)
+}
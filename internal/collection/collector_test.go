@@ -0,0 +1,182 @@
+package collection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollector_DetectByContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	json := `{"__CURSOR":"s=1;i=1","__REALTIME_TIMESTAMP":"1700000000000000","MESSAGE":"hello","_HOSTNAME":"vm"}` + "\n"
+	export := "__CURSOR=s=1;i=1\n__REALTIME_TIMESTAMP=1700000000000000\nMESSAGE=hello\n_HOSTNAME=vm\n\n"
+	files := map[string]string{
+		"capture.txt":    strings.Repeat(json, 3),
+		"export.log":     strings.Repeat(export, 3),
+		"plain.log":      strings.Repeat("Jan  1 10:30:00 host sshd[1]: Accepted password for root\n", 3),
+		"binary.journal": "LPKSHHRH" + strings.Repeat("\x00", 200),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	collection, err := NewCollector(tmpDir).DiscoverLogFiles()
+	if err != nil {
+		t.Fatalf("DiscoverLogFiles() error = %v", err)
+	}
+
+	types := make(map[string]string)
+	for _, logFile := range collection.LogFiles {
+		types[filepath.Base(logFile.Path)] = logFile.Type
+	}
+
+	expected := map[string]string{
+		"capture.txt":    "journald",
+		"export.log":     "journald",
+		"plain.log":      "syslog",
+		"binary.journal": "journald",
+	}
+	for name, logType := range expected {
+		if types[name] != logType {
+			t.Errorf("%s detected as %q, expected %q", name, types[name], logType)
+		}
+	}
+}
+
+func TestCollector_InferHost(t *testing.T) {
+	tmpDir := t.TempDir()
+	line := "Jan  1 10:30:00 localhost sshd[1]: Accepted password for root\n"
+	files := map[string]string{
+		"web01/var/log/auth.log":                              "web01",
+		"uac-db01-linux-20240101120000/[root]/var/log/secure": "db01",
+		"evidence/disk2/var/log/messages":                     "mail01",
+		"evidence/disk2/home/alice/notes.log":                 "mail01",
+		"loose/syslog":                                        "",
+	}
+	for name := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat(line, 3)), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	hostMap := filepath.Join(tmpDir, "hosts.yml")
+	if err := os.WriteFile(hostMap, []byte("evidence/disk2: mail01\n"), 0644); err != nil {
+		t.Fatalf("Failed to write host map: %v", err)
+	}
+	hosts, err := LoadHostMap(hostMap)
+	if err != nil {
+		t.Fatalf("LoadHostMap() error = %v", err)
+	}
+
+	collector := NewCollector(tmpDir)
+	collector.SetHostMap(hosts)
+	collection, err := collector.DiscoverLogFiles()
+	if err != nil {
+		t.Fatalf("DiscoverLogFiles() error = %v", err)
+	}
+
+	found := make(map[string]string)
+	for _, logFile := range collection.LogFiles {
+		rel, _ := filepath.Rel(tmpDir, logFile.Path)
+		found[filepath.ToSlash(rel)] = logFile.Host
+	}
+	for name, host := range files {
+		name = filepath.ToSlash(filepath.Clean(name))
+		got, ok := found[name]
+		if !ok {
+			t.Errorf("%s was not collected", name)
+			continue
+		}
+		if got != host {
+			t.Errorf("%s attributed to %q, expected %q", name, got, host)
+		}
+	}
+	if collection.Summary.FilesByHost["mail01"] != 2 {
+		t.Errorf("Expected 2 files from mail01, got %d", collection.Summary.FilesByHost["mail01"])
+	}
+}
+
+func TestCollector_Rotations(t *testing.T) {
+	tmpDir := t.TempDir()
+	line := "Jan  1 10:30:00 web01 sshd[1]: Accepted password for root from 10.0.0.1 port 22 ssh2\n"
+	names := []string{
+		"auth.log", "auth.log.1", "auth.log.2.gz", "auth.log.10.gz", "auth.log.3.gz",
+		"messages", "messages-20240108", "messages-20240101.gz",
+	}
+	for _, name := range names {
+		data := []byte(strings.Repeat(line, 3))
+		if strings.HasSuffix(name, ".gz") {
+			var compressed bytes.Buffer
+			writer := gzip.NewWriter(&compressed)
+			writer.Write(data)
+			writer.Close()
+			data = compressed.Bytes()
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	collection, err := NewCollector(tmpDir).DiscoverLogFiles()
+	if err != nil {
+		t.Fatalf("DiscoverLogFiles() error = %v", err)
+	}
+
+	var order []string
+	for _, logFile := range collection.LogFiles {
+		order = append(order, filepath.Base(logFile.Path))
+		if logFile.Type != "syslog" {
+			t.Errorf("%s detected as %q", logFile.Path, logFile.Type)
+		}
+	}
+	expected := []string{
+		"auth.log.10.gz", "auth.log.3.gz", "auth.log.2.gz", "auth.log.1", "auth.log",
+		"messages-20240101.gz", "messages-20240108", "messages",
+	}
+	if strings.Join(order, " ") != strings.Join(expected, " ") {
+		t.Errorf("Files in order %v, expected %v", order, expected)
+	}
+}
+
+func TestCollector_DetectLogType(t *testing.T) {
+	syslogLine := "Jan  1 10:30:00 web01 sshd[1]: Accepted password for root from 10.0.0.1 port 22 ssh2\n"
+	auditLine := `type=USER_LOGIN msg=audit(1704105000.123:456): pid=1 uid=0 auid=0 ses=1 msg='op=login acct="root" exe="/usr/sbin/sshd" res=success'` + "\n"
+	nginxLine := "2024/01/01 10:30:00 [error] 123#0: *1 open() \"/var/www/favicon.ico\" failed (2: No such file or directory)\n"
+	configLine := "log_file = /var/log/audit/audit.log\n"
+
+	tests := []struct {
+		name       string
+		path       string
+		content    string
+		logType    string
+		confidence float64
+	}{
+		{"syslog", "evidence/host.dat", strings.Repeat(syslogLine, 4), "syslog", 1},
+		{"renamed audit log", "evidence/exhibit-12.txt", strings.Repeat(auditLine, 4), "auditd", 1},
+		{"audit log under logs", "logs/audit.log", strings.Repeat(auditLine, 4), "auditd", 1},
+		{"nginx error log", "var/log/nginx/error.log", strings.Repeat(nginxLine, 4), "", 0},
+		{"auditd config", "etc/audit/auditd.conf", strings.Repeat(configLine, 4), "", 0},
+		{"inconclusive with hint", "var/log/messages", syslogLine + strings.Repeat(nginxLine, 3), "syslog", 0.25},
+		{"inconclusive without hint", "var/log/app.log", strings.Repeat(nginxLine, 2) + strings.Repeat(auditLine, 1) + nginxLine, "", 0},
+		{"unreadable with hint", "var/log/auth.log.2.gz", "", "syslog", 0.25},
+	}
+
+	collector := NewCollector(".")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logType, confidence := collector.detectLogType(tt.path, []byte(tt.content))
+			if logType != tt.logType || confidence != tt.confidence {
+				t.Errorf("detectLogType() = %q, %v, expected %q, %v", logType, confidence, tt.logType, tt.confidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package collection
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DiscoveryOptions control which files in a collection are examined.
+type DiscoveryOptions struct {
+	// Include, when set, limits discovery to files matching one of these
+	// globs. Exclude skips files and whole directories matching one of its
+	// globs, and wins over Include. Globs without a slash match the file or
+	// directory name; others match the path relative to the collection, with
+	// ** matching any number of directories.
+	Include []string
+	Exclude []string
+	// MaxDepth is how many directory levels deep files are examined, as with
+	// find -maxdepth: 1 examines only the files directly in the collection.
+	// 0 is unlimited.
+	MaxDepth int
+	// MinSize and MaxSize bound the size of examined files. A MaxSize of 0
+	// is unlimited.
+	MinSize int64
+	MaxSize int64
+	// FollowSymlinks descends into symlinked directories and reads
+	// symlinked files; otherwise symlinks are skipped.
+	FollowSymlinks bool
+	// IncludeHidden examines dot files and directories.
+	IncludeHidden bool
+}
+
+// DefaultDiscoveryOptions skip tiny files, binaries and documents, hidden
+// files and symlinks.
+var DefaultDiscoveryOptions = DiscoveryOptions{
+	Exclude: []string{
+		"*.exe", "*.bin", "*.so", "*.dll", "*.zip", "*.tar", "*.tgz",
+		"*.pdf", "*.doc", "*.docx", "*.xls", "*.jpg", "*.png", "*.gif", "*.mp4",
+	},
+	MinSize: minLogSize,
+}
+
+// SkippedFile is a file or directory that discovery didn't examine.
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// skipReason says why the file at rel, relative to the collection, isn't
+// examined, or returns "" if it should be.
+func (o DiscoveryOptions) skipReason(rel string, size int64) string {
+	rel = filepath.ToSlash(rel)
+	if reason := o.skipPath(rel, false); reason != "" {
+		return reason
+	}
+
+	if len(o.Include) > 0 && matchGlobs(o.Include, rel) == "" {
+		return "not matched by an include pattern"
+	}
+	if size < o.MinSize {
+		return fmt.Sprintf("smaller than %d bytes", o.MinSize)
+	}
+	if o.MaxSize > 0 && size > o.MaxSize {
+		return fmt.Sprintf("larger than %d bytes", o.MaxSize)
+	}
+	return ""
+}
+
+// skipPath applies the checks files and directories share: exclusion,
+// hidden names and depth. A directory at rel is skipped when its files would
+// be too deep.
+func (o DiscoveryOptions) skipPath(rel string, dir bool) string {
+	if pattern := matchGlobs(o.Exclude, rel); pattern != "" {
+		return "excluded by " + pattern
+	}
+
+	if !o.IncludeHidden {
+		for _, part := range strings.Split(rel, "/") {
+			if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+				return "hidden"
+			}
+		}
+	}
+
+	depth := strings.Count(rel, "/") + 1
+	if dir {
+		depth++
+	}
+	if o.MaxDepth > 0 && depth > o.MaxDepth {
+		return fmt.Sprintf("deeper than %d levels", o.MaxDepth)
+	}
+	return ""
+}
+
+// matchGlobs returns the first of patterns that matches rel, or "".
+func matchGlobs(patterns []string, rel string) string {
+	for _, pattern := range patterns {
+		if matchGlob(filepath.ToSlash(pattern), rel) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// matchGlob matches a slash path against a glob. Globs without a slash
+// match the last element of the path.
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path elements against glob elements, where **
+// stands for any number of elements.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], parts[0])
+	return matched && matchSegments(pattern[1:], parts[1:])
+}
+
+// walkFunc is called by walkCollection with each file to examine, its path
+// relative to the base path, and the file's (followed) info.
+type walkFunc func(path, rel string, info os.FileInfo) error
+
+// walkCollection walks the directory tree under basePath in lexical order,
+// calling fn with each regular file that passes the discovery options and
+// skip with everything else. Symlinks are followed if the options say so;
+// a directory reached twice, as through a symlink loop, is only walked once,
+// and so is a file.
+func (c *Collector) walkCollection(fn walkFunc, skip func(path, reason string)) error {
+	root, err := filepath.EvalSymlinks(c.basePath)
+	if err != nil {
+		return err
+	}
+	w := &collectionWalker{
+		collector: c,
+		fn:        fn,
+		skip:      skip,
+		visited:   map[string]string{root: c.basePath},
+	}
+	return w.walkDir(c.basePath, "")
+}
+
+type collectionWalker struct {
+	collector *Collector
+	fn        walkFunc
+	skip      func(path, reason string)
+	// visited maps the resolved paths of directories and files walked so
+	// far to the path they were first reached by.
+	visited map[string]string
+}
+
+func (w *collectionWalker) walkDir(dir, rel string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if rel == "" {
+			return err
+		}
+		w.skip(dir, fmt.Sprintf("unreadable: %v", err))
+		return nil
+	}
+
+	opts := w.collector.discovery
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		entryRel := path.Join(rel, entry.Name())
+
+		info, err := os.Lstat(entryPath)
+		if err != nil {
+			w.skip(entryPath, fmt.Sprintf("unreadable: %v", err))
+			continue
+		}
+
+		// Resolve symlinks, or skip them
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !opts.FollowSymlinks {
+				w.skip(entryPath, "symlink")
+				continue
+			}
+			info, err = os.Stat(entryPath)
+			if err != nil {
+				w.skip(entryPath, "broken symlink")
+				continue
+			}
+		}
+
+		if info.IsDir() {
+			if reason := opts.skipPath(entryRel, true); reason != "" {
+				w.skip(entryPath, reason)
+				continue
+			}
+			if first := w.visit(entryPath); first != "" {
+				w.skip(entryPath, "already walked as "+first)
+				continue
+			}
+			if err := w.walkDir(entryPath, entryRel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			w.skip(entryPath, "not a regular file")
+			continue
+		}
+		if reason := opts.skipReason(entryRel, info.Size()); reason != "" {
+			w.skip(entryPath, reason)
+			continue
+		}
+		if first := w.visit(entryPath); first != "" {
+			w.skip(entryPath, "same file as "+first)
+			continue
+		}
+		if err := w.fn(entryPath, entryRel, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit records a walked path by where it resolves to, returning the path it
+// was first reached by if it was walked before.
+func (w *collectionWalker) visit(path string) string {
+	if !w.collector.discovery.FollowSymlinks {
+		// Without symlinks, nothing is reached twice
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	if first, ok := w.visited[resolved]; ok {
+		return first
+	}
+	w.visited[resolved] = path
+	return ""
+}
@@ -0,0 +1,170 @@
+package collection
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"*.log", "var/log/auth.log", true},
+		{"auth.log*", "var/log/auth.log.1.gz", true},
+		{"*.log", "var/log/messages", false},
+		{"var/log/*", "var/log/auth.log", true},
+		{"var/log/*", "var/log/audit/audit.log", false},
+		{"**/audit/*", "host/var/log/audit/audit.log", true},
+		{"**/audit/*", "audit/audit.log", true},
+		{"var/**/*.log", "var/log/nginx/access.log", true},
+		{"./var/log/*", "var/log/syslog", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestCollector_DiscoveryOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	line := "Jan  1 10:30:00 web01 sshd[1]: Accepted password for root from 10.0.0.1 port 22 ssh2\n"
+	files := map[string]string{
+		"auth.log":                 strings.Repeat(line, 3),
+		"big.log":                  strings.Repeat(line, 30),
+		"tiny.log":                 line[:50],
+		"tool.exe":                 strings.Repeat(line, 3),
+		".hidden.log":              strings.Repeat(line, 3),
+		"nested/deeper/secure":     strings.Repeat(line, 3),
+		"nested/messages":          strings.Repeat(line, 3),
+		"cache/messages":           strings.Repeat(line, 3),
+		"notes.txt":                strings.Repeat("not a log line at all\n", 10),
+		".git/objects/log.txt":     strings.Repeat(line, 3),
+		"elsewhere/linked/syslog":  strings.Repeat(line, 3),
+		"elsewhere/linked/loop/.k": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, "collection", name)
+		if strings.HasPrefix(name, "elsewhere/") {
+			path = filepath.Join(tmpDir, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// A symlinked directory holding a link back to itself, and a second
+	// link to a file in the collection
+	base := filepath.Join(tmpDir, "collection")
+	linked := filepath.Join(tmpDir, "elsewhere", "linked")
+	symlinks := map[string]string{
+		filepath.Join(base, "link"):            linked,
+		filepath.Join(linked, "loop", "again"): linked,
+		filepath.Join(base, "copy-of-auth"):    filepath.Join(base, "auth.log"),
+		filepath.Join(base, "dangling"):        filepath.Join(tmpDir, "missing"),
+	}
+	for link, target := range symlinks {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    func(*DiscoveryOptions)
+		found   []string
+		skipped map[string]string
+	}{
+		{
+			name:  "defaults",
+			opts:  func(*DiscoveryOptions) {},
+			found: []string{"auth.log", "big.log", "cache/messages", "nested/deeper/secure", "nested/messages"},
+			skipped: map[string]string{
+				"tiny.log":    "smaller than 100 bytes",
+				"tool.exe":    "excluded by *.exe",
+				".hidden.log": "hidden",
+				".git":        "hidden",
+				"link":        "symlink",
+				"notes.txt":   "not a recognised log format",
+			},
+		},
+		{
+			name: "include, exclude and size",
+			opts: func(o *DiscoveryOptions) {
+				o.Include = []string{"*.log", "**/messages"}
+				o.Exclude = append(o.Exclude, "cache")
+				o.MaxSize = 1000
+			},
+			found: []string{"auth.log", "nested/messages"},
+			skipped: map[string]string{
+				"big.log":              "larger than 1000 bytes",
+				"cache":                "excluded by cache",
+				"nested/deeper/secure": "not matched by an include pattern",
+			},
+		},
+		{
+			name:  "depth and hidden",
+			opts:  func(o *DiscoveryOptions) { o.MaxDepth = 1; o.IncludeHidden = true },
+			found: []string{".hidden.log", "auth.log", "big.log"},
+			skipped: map[string]string{
+				"nested": "deeper than 1 levels",
+				".git":   "deeper than 1 levels",
+			},
+		},
+		{
+			name:  "follow symlinks",
+			opts:  func(o *DiscoveryOptions) { o.FollowSymlinks = true },
+			found: []string{"auth.log", "big.log", "cache/messages", "link/syslog", "nested/deeper/secure", "nested/messages"},
+			skipped: map[string]string{
+				"copy-of-auth":    "same file as " + filepath.Join(base, "auth.log"),
+				"link/loop/again": "already walked as " + filepath.Join(base, "link"),
+				"dangling":        "broken symlink",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultDiscoveryOptions
+			opts.Exclude = append([]string(nil), opts.Exclude...)
+			tt.opts(&opts)
+			collector := NewCollector(base)
+			collector.SetDiscoveryOptions(opts)
+
+			collection, err := collector.DiscoverLogFiles()
+			if err != nil {
+				t.Fatalf("DiscoverLogFiles() error = %v", err)
+			}
+
+			var found []string
+			for _, logFile := range collection.LogFiles {
+				rel, _ := filepath.Rel(base, logFile.Path)
+				found = append(found, filepath.ToSlash(rel))
+			}
+			sort.Strings(found)
+			if strings.Join(found, " ") != strings.Join(tt.found, " ") {
+				t.Errorf("Found %v, expected %v", found, tt.found)
+			}
+
+			skipped := make(map[string]string)
+			for _, file := range collection.Summary.Skipped {
+				rel, _ := filepath.Rel(base, file.Path)
+				skipped[filepath.ToSlash(rel)] = file.Reason
+			}
+			for rel, reason := range tt.skipped {
+				if skipped[rel] != reason {
+					t.Errorf("%s skipped for %q, expected %q", rel, skipped[rel], reason)
+				}
+			}
+		})
+	}
+}
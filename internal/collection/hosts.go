@@ -0,0 +1,102 @@
+package collection
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// uacRegex matches the output directory of a UAC collection,
+// uac-<host>-<os>-<YYYYMMDDhhmmss>.
+var uacRegex = regexp.MustCompile(`^uac-(.+)-(?:linux|aix|esxi|freebsd|macos|netbsd|netscaler|openbsd|solaris|android)-\d{14}$`)
+
+// mountDirs are directories triage tools put a host's filesystem under, which
+// are not host names themselves.
+var mountDirs = map[string]bool{
+	"[root]": true, "root": true, "rootfs": true, "fs": true, "mnt": true,
+}
+
+// LoadHostMap reads a YAML file mapping paths in a collection, relative to
+// its base path, to the host their logs came from:
+//
+//	evidence/web01: web01.example.com
+//	disk2/var/log/audit: db01
+func LoadHostMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host map %s: %w", path, err)
+	}
+
+	var hosts map[string]string
+	if err := yaml.Unmarshal(data, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse host map %s: %w", path, err)
+	}
+	return hosts, nil
+}
+
+// inferHost works out which host a file in the collection came from, given
+// its path and its path relative to the base path or archive. An explicit
+// host map entry for the file or one of its directories wins, the longest
+// match first. Otherwise the host is taken from a UAC output
+// directory (uac-<host>-linux-<time>), or from the directory holding a
+// host's filesystem, as in <host>/var/log/..., which is also how CyLR
+// archives named after the host unpack.
+func (c *Collector) inferHost(path, rel string) string {
+	if rel != "" && !strings.HasPrefix(rel, "..") {
+		rel = filepath.ToSlash(rel)
+		best := -1
+		var host string
+		for prefix, mapped := range c.hosts {
+			prefix = strings.Trim(filepath.ToSlash(filepath.Clean(prefix)), "/")
+			if prefix == "." {
+				prefix = ""
+			}
+			if prefix != "" && rel != prefix && !strings.HasPrefix(rel, prefix+"/") {
+				continue
+			}
+			if len(prefix) > best {
+				best, host = len(prefix), mapped
+			}
+		}
+		if best >= 0 {
+			return host
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	// Archives name hosts like the directories they unpack to
+	parts := strings.Split(strings.ReplaceAll(filepath.ToSlash(abs), archiveSeparator, "/"), "/")
+	for i, part := range parts {
+		parts[i] = trimArchiveExt(part)
+	}
+
+	for _, part := range parts {
+		if matches := uacRegex.FindStringSubmatch(part); matches != nil {
+			return matches[1]
+		}
+	}
+
+	// The directory above the filesystem root, skipping mount directories
+	for i := len(parts) - 2; i > 0; i-- {
+		if parts[i] != "var" || parts[i+1] != "log" {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if parts[j] == "" {
+				return ""
+			}
+			if !mountDirs[strings.ToLower(parts[j])] {
+				return parts[j]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package collection
+
+import "os"
+
+// fileInode returns 0, as inode numbers are only known on Unix.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
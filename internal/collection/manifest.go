@@ -0,0 +1,269 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileHashes are digests of a file as stored, before any decompression, so
+// they can be checked against the evidence with sha256sum and the like.
+type FileHashes struct {
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+}
+
+// ManifestEntry records a file that was analyzed, for chain of custody.
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+	// Inode is 0 for archive members and where inodes are unknown.
+	Inode uint64 `json:"inode,omitempty"`
+	FileHashes
+}
+
+// Manifest lists every analyzed file with its hashes and metadata. For a
+// collection read from an archive, the archive itself comes first.
+type Manifest struct {
+	BasePath string          `json:"base_path"`
+	Files    []ManifestEntry `json:"files"`
+}
+
+// ExtraHashes picks the digests computed for the manifest besides SHA-256.
+type ExtraHashes struct {
+	MD5  bool
+	SHA1 bool
+}
+
+// fileHasher computes the SHA-256 of what is written to it, and MD5 and
+// SHA-1 if asked to.
+type fileHasher struct {
+	io.Writer
+	sha256 hash.Hash
+	md5    hash.Hash
+	sha1   hash.Hash
+}
+
+func newHasher(extra ExtraHashes) *fileHasher {
+	h := &fileHasher{sha256: sha256.New()}
+	writers := []io.Writer{h.sha256}
+	if extra.MD5 {
+		h.md5 = md5.New()
+		writers = append(writers, h.md5)
+	}
+	if extra.SHA1 {
+		h.sha1 = sha1.New()
+		writers = append(writers, h.sha1)
+	}
+	h.Writer = io.MultiWriter(writers...)
+	return h
+}
+
+func (h *fileHasher) hashes() FileHashes {
+	hashes := FileHashes{SHA256: hex.EncodeToString(h.sha256.Sum(nil))}
+	if h.md5 != nil {
+		hashes.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	}
+	if h.sha1 != nil {
+		hashes.SHA1 = hex.EncodeToString(h.sha1.Sum(nil))
+	}
+	return hashes
+}
+
+// hashingReader hashes what is read through it, counting the bytes.
+type hashingReader struct {
+	r      io.Reader
+	hasher *fileHasher
+	n      int64
+}
+
+func newHashingReader(r io.Reader, extra ExtraHashes) *hashingReader {
+	return &hashingReader{r: r, hasher: newHasher(extra)}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hasher.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+// finish reads what the parser left unread, so the hashes cover everything
+// up to the end of the reader, and returns them with the bytes hashed.
+func (h *hashingReader) finish() (FileHashes, int64, error) {
+	if _, err := io.Copy(io.Discard, h); err != nil {
+		return FileHashes{}, 0, err
+	}
+	return h.hasher.hashes(), h.n, nil
+}
+
+// limitSize bounds a file's reader to the size it had when discovered, so
+// lines appended since are left for the next run. Files that were empty
+// when discovered are read to their end.
+func limitSize(r io.Reader, size int64) io.Reader {
+	if size <= 0 {
+		return r
+	}
+	return io.LimitReader(r, size)
+}
+
+// hashFile hashes the file at path up to size, as hashFiles and the analysis
+// of files whose parser reads them itself do.
+func hashFile(path string, size int64, extra ExtraHashes) (FileHashes, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileHashes{}, 0, err
+	}
+	defer file.Close()
+
+	return newHashingReader(limitSize(file, size), extra).finish()
+}
+
+// HashFiles hashes every file in a collection for its manifest without
+// analyzing them, as when only the collection summary is shown. Analysis
+// hashes files as it reads them instead.
+func (c *Collector) HashFiles(collection *Collection) error {
+	if collection.Archive != nil {
+		archiveHasher := newHasher(collection.ExtraHashes)
+		members := make(map[string]int)
+		for i, logFile := range collection.LogFiles {
+			if logFile.Archive != "" {
+				members[logFile.Member] = i
+			}
+		}
+
+		err := walkArchiveHashing(collection.Archive.Path, c.limits, archiveHasher, func(member archiveMember, r io.Reader) error {
+			index, ok := members[member.name]
+			if !ok {
+				return nil
+			}
+			logFile := &collection.LogFiles[index]
+			hashes, size, err := newHashingReader(limitSize(r, logFile.Size), collection.ExtraHashes).finish()
+			if err != nil {
+				return fmt.Errorf("failed to hash %s: %w", member.name, err)
+			}
+			logFile.Hashes, logFile.Size = hashes, size
+			return nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+		collection.Archive.FileHashes = archiveHasher.hashes()
+	}
+
+	for i := range collection.LogFiles {
+		logFile := &collection.LogFiles[i]
+		if logFile.Archive != "" {
+			continue
+		}
+		hashes, size, err := hashFile(logFile.Path, logFile.Size, collection.ExtraHashes)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", logFile.Path, err)
+		}
+		logFile.Hashes, logFile.Size = hashes, size
+	}
+	return nil
+}
+
+// Manifest lists the files in the collection with their hashes. Files are
+// hashed as they are analyzed, so one that couldn't be read has none.
+func (c *Collection) Manifest() Manifest {
+	manifest := Manifest{BasePath: c.BasePath, Files: make([]ManifestEntry, 0, len(c.LogFiles)+1)}
+	if c.Archive != nil {
+		manifest.Files = append(manifest.Files, *c.Archive)
+	}
+	for _, logFile := range c.LogFiles {
+		manifest.Files = append(manifest.Files, ManifestEntry{
+			Path:       logFile.Path,
+			Type:       logFile.Type,
+			Size:       logFile.Size,
+			ModTime:    logFile.ModTime.UTC(),
+			Inode:      logFile.Inode,
+			FileHashes: logFile.Hashes,
+		})
+	}
+	return manifest
+}
+
+// WriteJSON writes the manifest as a JSON document.
+func (m Manifest) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// WriteCSV writes the manifest as CSV, one file per row.
+func (m Manifest) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"path", "type", "size", "modified", "inode", "sha256", "md5", "sha1"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, entry := range m.Files {
+		inode := ""
+		if entry.Inode != 0 {
+			inode = strconv.FormatUint(entry.Inode, 10)
+		}
+		record := []string{
+			entry.Path,
+			entry.Type,
+			strconv.FormatInt(entry.Size, 10),
+			entry.ModTime.Format(time.RFC3339),
+			inode,
+			entry.SHA256,
+			entry.MD5,
+			entry.SHA1,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Hash returns the SHA-256 of the manifest's JSON form.
+func (m Manifest) Hash() (string, error) {
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	digest := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// WriteFile writes the manifest to path, as JSON if it ends in .json and as
+// CSV otherwise, and returns the SHA-256 of what was written.
+func (m Manifest) WriteFile(path string) (string, error) {
+	var buf bytes.Buffer
+	write := m.WriteCSV
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		write = m.WriteJSON
+	}
+	if err := write(&buf); err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	digest := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(digest[:]), nil
+}
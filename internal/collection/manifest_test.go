@@ -0,0 +1,169 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCollector_Manifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := []byte(strings.Repeat(archiveLine, 3))
+	logPath := filepath.Join(tmpDir, "evidence", "auth.log")
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(logPath, content, 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	collector := NewCollector(filepath.Join(tmpDir, "evidence"))
+	collector.SetExtraHashes(true, true)
+	collection, err := collector.DiscoverLogFiles()
+	if err != nil {
+		t.Fatalf("DiscoverLogFiles() error = %v", err)
+	}
+	if len(collection.LogFiles) != 1 {
+		t.Fatalf("Expected 1 log file, got %d", len(collection.LogFiles))
+	}
+	if err := collector.HashFiles(collection); err != nil {
+		t.Fatalf("HashFiles() error = %v", err)
+	}
+
+	sha256Sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content)
+	sha1Sum := sha1.Sum(content)
+	expected := FileHashes{
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+		MD5:    hex.EncodeToString(md5Sum[:]),
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+	}
+	logFile := collection.LogFiles[0]
+	if logFile.Hashes != expected {
+		t.Errorf("Hashes = %+v, expected %+v", logFile.Hashes, expected)
+	}
+	if runtime.GOOS != "windows" && logFile.Inode == 0 {
+		t.Error("Expected an inode number")
+	}
+
+	manifest := collection.Manifest()
+	for _, name := range []string{"manifest.csv", "manifest.json"} {
+		path := filepath.Join(tmpDir, name)
+		hash, err := manifest.WriteFile(path)
+		if err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read manifest: %v", err)
+		}
+		if digest := sha256.Sum256(data); hex.EncodeToString(digest[:]) != hash {
+			t.Errorf("%s: returned hash %s doesn't match the file", name, hash)
+		}
+
+		if strings.HasSuffix(name, ".json") {
+			var decoded Manifest
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Failed to decode manifest: %v", err)
+			}
+			if len(decoded.Files) != 1 || decoded.Files[0].SHA256 != expected.SHA256 || decoded.Files[0].Size != int64(len(content)) {
+				t.Errorf("Unexpected JSON manifest %+v", decoded)
+			}
+			if jsonHash, _ := manifest.Hash(); jsonHash != hash {
+				t.Errorf("Hash() = %s, expected the hash of the JSON manifest %s", jsonHash, hash)
+			}
+		} else {
+			records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			if err != nil {
+				t.Fatalf("Failed to read CSV manifest: %v", err)
+			}
+			if len(records) != 2 || records[1][0] != logPath || records[1][5] != expected.SHA256 || records[1][6] != expected.MD5 {
+				t.Errorf("Unexpected CSV manifest %v", records)
+			}
+		}
+	}
+}
+
+func TestCollector_ManifestArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "triage.tar.gz")
+	members := archiveMembers()
+	writeTarGz(t, archivePath, members)
+
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	// Files are hashed as they are analyzed, or on their own for a summary
+	tests := []struct {
+		name string
+		hash func(collector *Collector, collection *Collection) error
+	}{
+		{"hashed", func(collector *Collector, collection *Collection) error {
+			return collector.HashFiles(collection)
+		}},
+		{"analyzed", func(collector *Collector, collection *Collection) error {
+			analyzer, err := NewCollectionAnalyzer(collection, rulesDir, "json", false)
+			if err != nil {
+				return err
+			}
+			result, err := analyzer.AnalyzeCollection()
+			if err != nil {
+				return err
+			}
+			if result.FailedFiles != 0 {
+				return result.Results[0].Error
+			}
+			return nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewCollector(archivePath)
+			collection, err := collector.DiscoverLogFiles()
+			if err != nil {
+				t.Fatalf("DiscoverLogFiles() error = %v", err)
+			}
+			if err := tt.hash(collector, collection); err != nil {
+				t.Fatalf("Hashing failed: %v", err)
+			}
+
+			manifest := collection.Manifest()
+			if len(manifest.Files) != 3 {
+				t.Fatalf("Expected the archive and 2 members in the manifest, got %d", len(manifest.Files))
+			}
+
+			archiveData, err := os.ReadFile(archivePath)
+			if err != nil {
+				t.Fatalf("Failed to read archive: %v", err)
+			}
+			archiveSum := sha256.Sum256(archiveData)
+			if manifest.Files[0].Path != archivePath || manifest.Files[0].SHA256 != hex.EncodeToString(archiveSum[:]) {
+				t.Errorf("Expected the archive first, got %+v", manifest.Files[0])
+			}
+
+			// Members are hashed as stored, compressed or not
+			for _, entry := range manifest.Files[1:] {
+				_, member, _ := strings.Cut(entry.Path, archiveSeparator)
+				memberSum := sha256.Sum256(members[member])
+				if entry.SHA256 != hex.EncodeToString(memberSum[:]) {
+					t.Errorf("%s hashed as %s", member, entry.SHA256)
+				}
+			}
+		})
+	}
+}
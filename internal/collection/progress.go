@@ -0,0 +1,121 @@
+package collection
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 250 * time.Millisecond
+
+// progress tracks a collection analysis across file workers and redraws a
+// single status line with files done, bytes read, matches and ETA.
+type progress struct {
+	w          io.Writer
+	totalFiles int
+	totalBytes int64
+	start      time.Time
+
+	filesDone atomic.Int64
+	bytesRead atomic.Int64
+	matches   atomic.Int64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func newProgress(w io.Writer, totalFiles int, totalBytes int64) *progress {
+	return &progress{
+		w:          w,
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		start:      time.Now(),
+		stop:       make(chan struct{}),
+	}
+}
+
+// run redraws the progress line until finish is called. A nil writer only
+// counts.
+func (p *progress) run() {
+	if p.w == nil {
+		return
+	}
+
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(p.w, "\r%s", p.line())
+			case <-p.stop:
+				fmt.Fprintf(p.w, "\r%s\n", p.line())
+				return
+			}
+		}
+	}()
+}
+
+func (p *progress) finish() {
+	close(p.stop)
+	p.done.Wait()
+}
+
+func (p *progress) line() string {
+	read := p.bytesRead.Load()
+	line := fmt.Sprintf("[%d/%d files] %s / %s read, %d matches",
+		p.filesDone.Load(), p.totalFiles, formatBytes(read), formatBytes(p.totalBytes), p.matches.Load())
+
+	// Estimate from the read rate so far
+	elapsed := time.Since(p.start)
+	if read > 0 && read < p.totalBytes {
+		remaining := time.Duration(float64(elapsed) * float64(p.totalBytes-read) / float64(read))
+		line += fmt.Sprintf(", ETA %s", remaining.Round(time.Second))
+	}
+
+	// Pad to overwrite a longer previous line
+	return fmt.Sprintf("%-80s", line)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// countingReader adds the bytes read through it to a shared counter.
+type countingReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// IsTerminal reports whether f is attached to a terminal, where a redrawn
+// progress line makes sense.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
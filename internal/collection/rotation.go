@@ -0,0 +1,65 @@
+package collection
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+var (
+	// auth.log.1, auth.log.2.gz: higher numbers are older
+	numberedRotation = regexp.MustCompile(`^(.+)\.(\d+)$`)
+	// messages-20240101.gz, as logrotate's dateext names them
+	datedRotation = regexp.MustCompile(`^(.+)-(\d{8}|\d{10})$`)
+)
+
+// rotationKey identifies the log a file was rotated from and how recent it
+// is, with the live log last.
+func rotationKey(path string) (string, int64) {
+	dir, name := filepath.Split(path)
+	name = parser.TrimCompressionExt(name)
+
+	if matches := numberedRotation.FindStringSubmatch(name); matches != nil {
+		number, _ := strconv.ParseInt(matches[2], 10, 64)
+		return dir + matches[1], -number
+	}
+	if matches := datedRotation.FindStringSubmatch(name); matches != nil {
+		date, _ := strconv.ParseInt(matches[2], 10, 64)
+		return dir + matches[1], date
+	}
+	return dir + name, math.MaxInt64
+}
+
+// rotated reports whether path is named as a rotated log, which is no longer
+// written to.
+func rotated(path string) bool {
+	_, recency := rotationKey(path)
+	return recency != math.MaxInt64
+}
+
+// sortRotations groups files by the log they were rotated from and orders
+// each group oldest first, e.g. auth.log.3.gz, auth.log.2.gz, auth.log.1,
+// auth.log, so entries can be correlated in time across rotations.
+func sortRotations(files []LogFile) {
+	type key struct {
+		base    string
+		recency int64
+	}
+	keys := make(map[string]key, len(files))
+	for _, file := range files {
+		base, recency := rotationKey(file.Path)
+		keys[file.Path] = key{base, recency}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := keys[files[i].Path], keys[files[j].Path]
+		if a.base != b.base {
+			return a.base < b.base
+		}
+		return a.recency < b.recency
+	})
+}
@@ -14,13 +14,32 @@ type Config struct {
 	OutputFormat string   `json:"output_format"`
 	RuleSources  []string `json:"rule_sources"`
 	LastUpdated  string   `json:"last_updated"`
+
+	// StrictRuleVerification refuses rule sources that weren't verified
+	// against a checksum or signature when they were downloaded.
+	StrictRuleVerification bool `json:"strict_rule_verification"`
+
+	// GitHubToken authenticates rule sources using the api download strategy.
+	// HAYANIX_GITHUB_TOKEN or GITHUB_TOKEN take precedence when set.
+	GitHubToken string `json:"github_token,omitempty"`
 }
 
 const (
 	ConfigFileName = "hayanix.json"
 	ConfigDir      = ".hayanix"
+	CacheDirName   = "cache"
 )
 
+// GetCacheDir returns where collection analysis results are cached.
+func GetCacheDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return filepath.Join(homeDir, ConfigDir, CacheDirName), nil
+}
+
 func GetConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -1,9 +1,17 @@
 package engine
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"log"
+	"os"
+	"runtime"
 
+	"github.com/wellknittech/hayanix/internal/checkpoint"
 	"github.com/wellknittech/hayanix/internal/output"
 	"github.com/wellknittech/hayanix/internal/parser"
 	"github.com/wellknittech/hayanix/internal/rules"
@@ -15,6 +23,15 @@ type Engine struct {
 	file    string
 	output  string
 	verbose bool
+	strict  bool
+	threads int
+	parse   parser.Options
+	version string
+	follow  bool
+	state   *checkpoint.State
+
+	// analyzed records the byte ranges of log files read, with their hashes
+	analyzed []output.AnalyzedFile
 }
 
 func New(target, rules, file, output string, verbose bool) *Engine {
@@ -24,14 +41,64 @@ func New(target, rules, file, output string, verbose bool) *Engine {
 		file:    file,
 		output:  output,
 		verbose: verbose,
+		threads: runtime.NumCPU(),
+	}
+}
+
+// SetThreads sets how many workers evaluate rules. Zero or less uses one per
+// CPU.
+func (e *Engine) SetThreads(threads int) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
 	}
+	e.threads = threads
+}
+
+// SetParserOptions sets the timezone and year used for timestamps that lack
+// them, and the time window entries must fall in.
+func (e *Engine) SetParserOptions(opts parser.Options) {
+	e.parse = opts
+}
+
+// SetToolVersion records the version of hayanix in the output metadata.
+func (e *Engine) SetToolVersion(version string) {
+	e.version = version
+}
+
+// SetStrictVerification refuses to run with rules from unverified sources.
+func (e *Engine) SetStrictVerification(strict bool) {
+	e.strict = strict
+}
+
+// SetFollow keeps reading the log as it is written, like tail -F, writing
+// each detection as it is found until the context is cancelled.
+func (e *Engine) SetFollow(follow bool) {
+	e.follow = follow
+}
+
+// SetState resumes the log from its checkpoint in state and checkpoints it
+// again when done, so only lines written since the last run are analyzed.
+// The caller saves state.
+func (e *Engine) SetState(state *checkpoint.State) {
+	e.state = state
 }
 
 func (e *Engine) Run() error {
+	return e.RunContext(context.Background())
+}
+
+// RunContext runs the analysis, stopping early when ctx is cancelled.
+func (e *Engine) RunContext(ctx context.Context) error {
 	if e.verbose {
 		log.Printf("Starting analysis with target: %s, rules: %s, output: %s", e.target, e.rules, e.output)
 	}
 
+	// Record how the installed rule sources were verified
+	verifications, err := rules.CheckSources(e.rules, e.strict)
+	if err != nil {
+		return fmt.Errorf("failed to check rule sources: %w", err)
+	}
+
 	// Load sigma rules from all directories
 	ruleEngine, err := rules.NewEngine(e.rules)
 	if err != nil {
@@ -49,15 +116,36 @@ func (e *Engine) Run() error {
 	if err != nil {
 		return fmt.Errorf("failed to create parser: %w", err)
 	}
+	logParser.SetOptions(e.parse)
+
+	outputter := output.NewOutputter(e.output)
+	metadata := &output.Metadata{
+		RuleSources: verifications,
+		ToolVersion: e.version,
+		RuleSetHash: ruleEngine.Hash(),
+	}
+	outputter.SetMetadata(metadata)
+
+	if e.follow {
+		return e.followLog(ctx, logFile, logParser, ruleEngine, outputter)
+	}
 
 	// Process logs
-	results, err := e.processLogs(logParser, ruleEngine)
+	var results []parser.LogEntry
+	if e.resumable(logFile, logParser) {
+		results, err = e.processResumed(ctx, logFile, logParser, ruleEngine)
+	} else if e.hashable(logFile, logParser) {
+		results, err = e.processFile(ctx, logFile, logParser, ruleEngine)
+	} else {
+		entries, errc := parser.StreamEntries(ctx, logParser)
+		results, err = e.processLogs(entries, errc, ruleEngine)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to process logs: %w", err)
 	}
 
-	// Output results
-	outputter := output.NewOutputter(e.output)
+	// Output results, with the hashes of what was read
+	metadata.Files = e.analyzed
 	return outputter.Write(results)
 }
 
@@ -79,29 +167,292 @@ func (e *Engine) getLogFilePath() string {
 	}
 }
 
-func (e *Engine) processLogs(logParser parser.Parser, ruleEngine *rules.Engine) ([]parser.LogEntry, error) {
+// processLogs evaluates entries across the worker pool as they are parsed, so
+// only matching entries are held in memory. Results keep the input order.
+func (e *Engine) processLogs(entries <-chan parser.LogEntry, errc <-chan error, ruleEngine *rules.Engine) ([]parser.LogEntry, error) {
 	var results []parser.LogEntry
 
-	entries, err := logParser.Parse()
-	if err != nil {
+	processed := ruleEngine.EvaluateStream(entries, e.threads, func(entry parser.LogEntry) {
+		results = append(results, entry)
+	})
+
+	if err := <-errc; err != nil {
 		return nil, fmt.Errorf("failed to parse log file: %w", err)
 	}
 
 	if e.verbose {
-		log.Printf("Processing %d log entries", len(entries))
+		log.Printf("Processed %d log entries", processed)
+		log.Printf("Found %d matching entries", len(results))
+	}
+
+	return results, nil
+}
+
+// resumable reports whether the log can be resumed from a checkpoint: a
+// text log file, read by a parser that can start from any offset.
+func (e *Engine) resumable(logFile string, logParser parser.Parser) bool {
+	if e.state == nil {
+		return false
 	}
+	if _, ok := logParser.(parser.ReaderParser); !ok {
+		return false
+	}
+	info, err := os.Stat(logFile)
+	if err != nil || !info.Mode().IsRegular() || parser.IsJournalFile(logFile) {
+		if e.verbose {
+			log.Printf("%s can't be resumed from a checkpoint; analyzing all of it", logFile)
+		}
+		return false
+	}
+	return true
+}
+
+// hashable reports whether the log is a file read by a parser that can read
+// from a reader, so it can be hashed as it is parsed. Directories such as
+// /var/log/journal are read file by file by their parser.
+func (e *Engine) hashable(logFile string, logParser parser.Parser) bool {
+	if _, ok := logParser.(parser.ReaderParser); !ok {
+		return false
+	}
+	info, err := os.Stat(logFile)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// processFile analyzes a whole log file, hashing it as it is read.
+func (e *Engine) processFile(ctx context.Context, path string, logParser parser.Parser, ruleEngine *rules.Engine) ([]parser.LogEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	// Infer missing years relative to when the file was last written
+	opts := e.parse
+	if info, err := file.Stat(); err == nil && opts.Reference.IsZero() {
+		opts.Reference = info.ModTime()
+	}
+	logParser.SetOptions(opts)
+
+	reader := newHashingReader(file)
+	entries, errc := parser.StreamReaderEntries(ctx, logParser.(parser.ReaderParser), reader)
+	results, err := e.processLogs(entries, errc, ruleEngine)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, e.recordAnalyzed(path, 0, reader)
+}
+
+// recordAnalyzed records the range of a log file read through reader, reading
+// to its end first so the hash covers anything the parser left unread.
+func (e *Engine) recordAnalyzed(path string, offset int64, reader *hashingReader) error {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	e.analyzed = append(e.analyzed, output.AnalyzedFile{
+		Path:   path,
+		Offset: offset,
+		Size:   reader.size,
+		SHA256: hex.EncodeToString(reader.hash.Sum(nil)),
+	})
+	return nil
+}
+
+// hashingReader hashes what is read through it.
+type hashingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
 
-	for _, entry := range entries {
-		matches := ruleEngine.Evaluate(entry)
-		if len(matches) > 0 {
-			entry.MatchedRules = matches
-			results = append(results, entry)
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, hash: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+// processResumed analyzes what was written to the log since its checkpoint,
+// first finishing the file it was rotated to, if any.
+func (e *Engine) processResumed(ctx context.Context, logFile string, logParser parser.Parser, ruleEngine *rules.Engine) ([]parser.LogEntry, error) {
+	var results []parser.LogEntry
+	if rotated, from, ok := e.state.Rotated(logFile); ok {
+		// Nothing more will be written to it, so nothing is held back
+		entries, err := e.processFrom(ctx, rotated, from, false, logParser, ruleEngine)
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, entries...)
 	}
 
+	from, _, err := e.state.Resume(logFile)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := e.processFrom(ctx, logFile, from, true, logParser, ruleEngine)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, entries...), nil
+}
+
+// processFrom analyzes a log file from a checkpoint to its last complete
+// line, and checkpoints it there. With hold, entries later lines could still
+// add to are left for the next run, in the checkpoint.
+func (e *Engine) processFrom(ctx context.Context, path string, from checkpoint.Checkpoint, hold bool, logParser parser.Parser, ruleEngine *rules.Engine) ([]parser.LogEntry, error) {
+	reader, err := checkpoint.Open(path, from.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
 	if e.verbose {
-		log.Printf("Found %d matching entries", len(results))
+		log.Printf("Resuming %s at byte %d", path, from.Offset)
 	}
 
+	// Infer missing years relative to when the file was last written
+	opts := e.parse
+	if info, err := reader.File().Stat(); err == nil && opts.Reference.IsZero() {
+		opts.Reference = info.ModTime()
+	}
+	logParser.SetOptions(opts)
+
+	hashed := newHashingReader(reader)
+	entries := make(chan parser.LogEntry, 256)
+	errc := make(chan error, 1)
+	var held []string
+	go func() {
+		defer close(entries)
+		var err error
+		held, err = parser.StreamResumable(ctx, logParser.(parser.ReaderParser), hashed, from.Held, hold, entries)
+		errc <- err
+	}()
+	results, err := e.processLogs(entries, errc, ruleEngine)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.recordAnalyzed(path, reader.Start(), hashed); err != nil {
+		return nil, err
+	}
+
+	if err := e.state.Record(path, reader.File(), reader.End(), held); err != nil {
+		return nil, fmt.Errorf("failed to checkpoint %s: %w", path, err)
+	}
 	return results, nil
 }
+
+// followLog tails a log file, writing detections as they are found. When ctx
+// is cancelled, entries already read are evaluated and written before it
+// returns.
+func (e *Engine) followLog(ctx context.Context, logFile string, logParser parser.Parser, ruleEngine *rules.Engine, outputter *output.Outputter) error {
+	follower, ok := logParser.(parser.Follower)
+	if !ok {
+		return fmt.Errorf("%s logs can't be followed", e.target)
+	}
+	if info, err := os.Stat(logFile); err == nil && !info.Mode().IsRegular() {
+		return fmt.Errorf("can't follow %s: only log files can be followed", logFile)
+	}
+
+	if err := outputter.Begin(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	// Catch up from the checkpoint, if there is one, finishing the file the
+	// log was rotated to first; otherwise start at the end
+	start := int64(-1)
+	var held []string
+	if e.state != nil {
+		if rotated, from, ok := e.state.Rotated(logFile); ok {
+			entries, err := e.processFrom(ctx, rotated, from, false, logParser, ruleEngine)
+			if err != nil {
+				return fmt.Errorf("failed to process logs: %w", err)
+			}
+			for _, entry := range entries {
+				if err := outputter.WriteEntry(entry); err != nil {
+					return fmt.Errorf("failed to write output: %w", err)
+				}
+			}
+			logParser.SetOptions(e.parse)
+		}
+
+		from, found, err := e.state.Resume(logFile)
+		if err != nil {
+			return err
+		}
+		if found {
+			start = from.Offset
+			held = from.Held
+		}
+	}
+
+	tail, err := parser.OpenTailAt(logFile, start)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+
+	// Stop following as soon as output can't be written
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read lines until ctx is cancelled, after those the last run held back
+	lines := make(chan string, 256)
+	tailErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		for _, line := range held {
+			lines <- line
+		}
+		tailErr <- tail.Lines(ctx, lines)
+	}()
+
+	// Parse them until the lines run out, so nothing read is lost on exit
+	entries := make(chan parser.LogEntry, 256)
+	parseErr := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		parseErr <- follower.Follow(context.Background(), lines, entries)
+	}()
+
+	if e.verbose {
+		log.Printf("Following %s", logFile)
+	}
+
+	var writeErr error
+	processed := ruleEngine.EvaluateEach(entries, func(entry parser.LogEntry) {
+		if writeErr != nil {
+			return
+		}
+		if writeErr = outputter.WriteEntry(entry); writeErr != nil {
+			cancel()
+		}
+	})
+
+	if err := <-tailErr; err != nil {
+		return fmt.Errorf("failed to follow log file: %w", err)
+	}
+	if err := <-parseErr; err != nil {
+		return fmt.Errorf("failed to parse log file: %w", err)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write output: %w", writeErr)
+	}
+
+	if e.verbose {
+		log.Printf("Stopped following %s after %d log entries", logFile, processed)
+	}
+
+	// Remember where following stopped
+	if e.state != nil {
+		if err := e.state.Record(logFile, tail.File(), tail.Offset(), nil); err != nil {
+			return fmt.Errorf("failed to checkpoint %s: %w", logFile, err)
+		}
+	}
+
+	return outputter.End()
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/checkpoint"
+)
+
+const testRule = `title: Failed Login
+id: failed-login
+logsource:
+    product: linux
+    service: syslog
+detection:
+    keywords:
+        - 'failed password'
+    condition: keywords`
+
+func failedLogin(user string) string {
+	return "Jan  1 10:30:00 host sshd[1]: Failed password for " + user + "\n"
+}
+
+// newFollowTest writes a rule and a log to a temporary directory, and
+// returns an engine following the log from its start.
+func newFollowTest(t *testing.T, content string) (*Engine, string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	rulesDir := filepath.Join(tmpDir, "rules")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create rules directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+
+	logFile := filepath.Join(tmpDir, "auth.log")
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	// Checkpoint the start of the log so lines already in it are followed
+	state, err := checkpoint.Load(filepath.Join(tmpDir, "state.json"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	file, err := os.Open(logFile)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+	if err := state.Record(logFile, file, 0, nil); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	e := New("syslog", rulesDir, logFile, "json", false)
+	e.SetFollow(true)
+	e.SetState(state)
+	return e, logFile
+}
+
+func TestEngine_Follow(t *testing.T) {
+	e, logFile := newFollowTest(t, failedLogin("alice")+"Jan  1 10:30:01 host sshd[1]: Accepted password for bob\n")
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	lines := make(chan string, 16)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.RunContext(ctx)
+	}()
+
+	expect := func(user string) {
+		t.Helper()
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, "Failed password for "+user) {
+				t.Fatalf("Expected a match for %s, got %s", user, line)
+			}
+		case err := <-done:
+			t.Fatalf("RunContext() returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a match for %s", user)
+		}
+	}
+
+	appendLog := func(content string) {
+		t.Helper()
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatalf("Failed to append to log: %v", err)
+		}
+	}
+
+	// How the detections are produced, then lines already written, then
+	// each append as it arrives
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, `{"metadata":`) {
+			t.Fatalf("Expected metadata first, got %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for metadata")
+	}
+	expect("alice")
+	appendLog(failedLogin("carol"))
+	expect("carol")
+
+	// Rotated away and replaced
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	if err := os.WriteFile(logFile, []byte(failedLogin("dave")+"Jan  1 10:30:02 host sshd[1]: Accepted password for bob\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	expect("dave")
+
+	// Truncated in place
+	if err := os.WriteFile(logFile, []byte(failedLogin("eve")), 0644); err != nil {
+		t.Fatalf("Failed to truncate log: %v", err)
+	}
+	expect("eve")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunContext() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunContext() didn't return after cancellation")
+	}
+
+	w.Close()
+	for line := range lines {
+		t.Errorf("Unexpected output after the last match: %s", line)
+	}
+}
+
+func TestEngine_FollowWriteError(t *testing.T) {
+	e, _ := newFollowTest(t, failedLogin("alice"))
+
+	// Output that can't be written, as when a pipe's reader exits
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	r.Close()
+	os.Stdout = w
+	defer func() {
+		os.Stdout = oldStdout
+		w.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.RunContext(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "failed to write output") {
+			t.Errorf("Expected a write error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunContext() kept following after output failed")
+	}
+}
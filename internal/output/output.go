@@ -5,14 +5,70 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/wellknittech/hayanix/internal/parser"
+	"github.com/wellknittech/hayanix/internal/rules"
 )
 
 type Outputter struct {
-	format string
+	format   string
+	metadata *Metadata
+	// csv writes entries streamed with WriteEntry.
+	csv *csv.Writer
+}
+
+// Metadata describes how an analysis was produced. When set it is written
+// ahead of the entries in every format.
+type Metadata struct {
+	RuleSources []rules.Verification `json:"rule_sources"`
+	// ToolVersion is the version of hayanix that produced the output.
+	ToolVersion string `json:"tool_version,omitempty"`
+	// RuleSetHash identifies the rules evaluated, see rules.Engine.Hash.
+	RuleSetHash string `json:"rule_set_sha256,omitempty"`
+	// ManifestHash is the SHA-256 of the manifest of analyzed files.
+	ManifestHash string `json:"manifest_sha256,omitempty"`
+	// Files are the log files analyzed on their own, hashed as they were read.
+	Files []AnalyzedFile `json:"files,omitempty"`
+}
+
+// AnalyzedFile records the byte range of a log file that was analyzed and the
+// SHA-256 of those bytes as stored, before any decompression.
+type AnalyzedFile struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (m *Metadata) lines() []string {
+	var lines []string
+	for _, source := range m.RuleSources {
+		line := fmt.Sprintf("rule source %s: %s (checksum %s, signature %s", source.Source, source.Status(), source.Checksum, source.Signature)
+		if source.KeyID != "" {
+			line += ", key " + source.KeyID
+		}
+		if source.SHA256 != "" {
+			line += ", sha256 " + source.SHA256
+		}
+		lines = append(lines, line+")")
+	}
+	if m.ToolVersion != "" {
+		lines = append(lines, "hayanix version: "+m.ToolVersion)
+	}
+	if m.RuleSetHash != "" {
+		lines = append(lines, "rule set sha256: "+m.RuleSetHash)
+	}
+	if m.ManifestHash != "" {
+		lines = append(lines, "manifest sha256: "+m.ManifestHash)
+	}
+	for _, file := range m.Files {
+		lines = append(lines, fmt.Sprintf("file %s: bytes %d-%d sha256 %s", file.Path, file.Offset, file.Offset+file.Size, file.SHA256))
+	}
+	return lines
 }
 
 func NewOutputter(format string) *Outputter {
@@ -23,6 +79,11 @@ func NewOutputter(format string) *Outputter {
 	return &Outputter{format: format}
 }
 
+// SetMetadata attaches analysis metadata to the output.
+func (o *Outputter) SetMetadata(metadata *Metadata) {
+	o.metadata = metadata
+}
+
 func (o *Outputter) Write(entries []parser.LogEntry) error {
 	switch o.format {
 	case "table":
@@ -37,6 +98,12 @@ func (o *Outputter) Write(entries []parser.LogEntry) error {
 }
 
 func (o *Outputter) writeTable(entries []parser.LogEntry) error {
+	if o.metadata != nil {
+		for _, line := range o.metadata.lines() {
+			fmt.Println(line)
+		}
+	}
+
 	if len(entries) == 0 {
 		fmt.Println("No matching entries found.")
 		return nil
@@ -72,6 +139,13 @@ func (o *Outputter) writeTable(entries []parser.LogEntry) error {
 }
 
 func (o *Outputter) writeCSV(entries []parser.LogEntry) error {
+	// Metadata goes in comment lines ahead of the header
+	if o.metadata != nil {
+		for _, line := range o.metadata.lines() {
+			fmt.Printf("# %s\n", line)
+		}
+	}
+
 	writer := csv.NewWriter(os.Stdout)
 	defer writer.Flush()
 
@@ -104,5 +178,287 @@ func (o *Outputter) writeJSON(entries []parser.LogEntry) error {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
 
+	if o.metadata != nil {
+		if entries == nil {
+			entries = []parser.LogEntry{}
+		}
+		return encoder.Encode(struct {
+			Metadata *Metadata         `json:"metadata"`
+			Entries  []parser.LogEntry `json:"entries"`
+		}{o.metadata, entries})
+	}
+
 	return encoder.Encode(entries)
 }
+
+// Begin starts output that is written an entry at a time with WriteEntry, as
+// entries are found, and finished with End. Table output gets a line per
+// entry, CSV a record per entry and JSON a document per line, metadata first.
+func (o *Outputter) Begin() error {
+	switch o.format {
+	case "table":
+		if o.metadata != nil {
+			for _, line := range o.metadata.lines() {
+				fmt.Println(line)
+			}
+		}
+		fmt.Println("Timestamp | Hostname | Program | Message | Tags")
+		return nil
+	case "csv":
+		if o.metadata != nil {
+			for _, line := range o.metadata.lines() {
+				fmt.Printf("# %s\n", line)
+			}
+		}
+		o.csv = csv.NewWriter(os.Stdout)
+		header := []string{"timestamp", "hostname", "program", "pid", "message", "matched_rules"}
+		if err := o.csv.Write(header); err != nil {
+			return fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		o.csv.Flush()
+		return o.csv.Error()
+	case "json":
+		if o.metadata != nil {
+			return json.NewEncoder(os.Stdout).Encode(struct {
+				Metadata *Metadata `json:"metadata"`
+			}{o.metadata})
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %s", o.format)
+	}
+}
+
+// WriteEntry writes one entry of output started with Begin, flushing it
+// straight away.
+func (o *Outputter) WriteEntry(entry parser.LogEntry) error {
+	switch o.format {
+	case "table":
+		fmt.Printf("%s | %s | %s | %s | %s\n", entry.Timestamp, entry.Hostname, entry.Program,
+			entry.Message, strings.Join(entry.MatchedRules, ", "))
+		return nil
+	case "csv":
+		record := []string{
+			entry.Timestamp,
+			entry.Hostname,
+			entry.Program,
+			entry.PID,
+			entry.Message,
+			strings.Join(entry.MatchedRules, ";"),
+		}
+		if err := o.csv.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV record: %w", err)
+		}
+		o.csv.Flush()
+		return o.csv.Error()
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(entry)
+	default:
+		return fmt.Errorf("unsupported output format: %s", o.format)
+	}
+}
+
+// End finishes output started with Begin.
+func (o *Outputter) End() error {
+	if o.csv != nil {
+		o.csv.Flush()
+		return o.csv.Error()
+	}
+	return nil
+}
+
+// WriteRules writes a list of rules, as found by a rule search.
+func (o *Outputter) WriteRules(ruleList []rules.Rule) error {
+	switch o.format {
+	case "table":
+		return o.writeRulesTable(ruleList)
+	case "csv":
+		return o.writeRulesCSV(ruleList)
+	case "json":
+		return o.writeRulesJSON(ruleList)
+	default:
+		return fmt.Errorf("unsupported output format: %s", o.format)
+	}
+}
+
+func (o *Outputter) writeRulesTable(ruleList []rules.Rule) error {
+	if len(ruleList) == 0 {
+		fmt.Println("No matching rules found.")
+		return nil
+	}
+
+	fmt.Printf("Found %d matching rules:\n\n", len(ruleList))
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Title", "Level", "Status", "Logsource", "Source"})
+	table.SetBorder(true)
+	table.SetCenterSeparator("|")
+	table.SetColumnSeparator("|")
+	table.SetRowSeparator("-")
+
+	for _, rule := range ruleList {
+		title := rule.Title
+		if len(title) > 50 {
+			title = title[:47] + "..."
+		}
+
+		table.Append([]string{
+			rule.ID,
+			title,
+			rule.Level,
+			rule.Status,
+			rule.Logsource.String(),
+			rule.Source,
+		})
+	}
+
+	table.Render()
+	return nil
+}
+
+func (o *Outputter) writeRulesCSV(ruleList []rules.Rule) error {
+	writer := csv.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	header := []string{"id", "title", "level", "status", "logsource", "author", "tags", "source", "path"}
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, rule := range ruleList {
+		record := []string{
+			rule.ID,
+			rule.Title,
+			rule.Level,
+			rule.Status,
+			rule.Logsource.String(),
+			rule.Author,
+			strings.Join(rule.Tags, ";"),
+			rule.Source,
+			rule.Path,
+		}
+
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Outputter) writeRulesJSON(ruleList []rules.Rule) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if ruleList == nil {
+		ruleList = []rules.Rule{}
+	}
+	return encoder.Encode(ruleList)
+}
+
+// WriteRuleStats writes a summary of the loaded rules.
+func (o *Outputter) WriteRuleStats(stats rules.Stats) error {
+	switch o.format {
+	case "table":
+		return o.writeRuleStatsTable(stats)
+	case "csv":
+		return o.writeRuleStatsCSV(stats)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	default:
+		return fmt.Errorf("unsupported output format: %s", o.format)
+	}
+}
+
+// statsSections lists the breakdowns in the order they are written.
+func statsSections(stats rules.Stats) []struct {
+	name   string
+	counts map[string]int
+} {
+	return []struct {
+		name   string
+		counts map[string]int
+	}{
+		{"level", stats.ByLevel},
+		{"status", stats.ByStatus},
+		{"product", stats.ByProduct},
+		{"category", stats.ByCategory},
+		{"service", stats.ByService},
+		{"source", stats.BySource},
+		{"tactic", stats.ByTactic},
+	}
+}
+
+// sortedCounts returns the keys of counts, largest count first.
+func sortedCounts(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func (o *Outputter) writeRuleStatsTable(stats rules.Stats) error {
+	fmt.Printf("Loaded rules: %d\n", stats.Total)
+
+	for _, section := range statsSections(stats) {
+		fmt.Printf("\nBy %s:\n", section.name)
+		for _, key := range sortedCounts(section.counts) {
+			fmt.Printf("  %-28s %d\n", key, section.counts[key])
+		}
+	}
+
+	fmt.Printf("\nUnreachable rules: %d\n", len(stats.Unreachable))
+	if len(stats.Unreachable) == 0 {
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Title", "Reason"})
+	table.SetBorder(true)
+	table.SetCenterSeparator("|")
+	table.SetColumnSeparator("|")
+	table.SetRowSeparator("-")
+
+	for _, rule := range stats.Unreachable {
+		table.Append([]string{rule.ID, rule.Title, rule.Reason})
+	}
+
+	table.Render()
+	return nil
+}
+
+func (o *Outputter) writeRuleStatsCSV(stats rules.Stats) error {
+	writer := csv.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"group", "value", "count"}); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	records := [][]string{{"total", "", strconv.Itoa(stats.Total)}}
+	for _, section := range statsSections(stats) {
+		for _, key := range sortedCounts(section.counts) {
+			records = append(records, []string{section.name, key, strconv.Itoa(section.counts[key])})
+		}
+	}
+	for _, rule := range stats.Unreachable {
+		records = append(records, []string{"unreachable", rule.ID, rule.Reason})
+	}
+
+	for _, record := range records {
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV record: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,13 @@ package output
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/wellknittech/hayanix/internal/parser"
+	"github.com/wellknittech/hayanix/internal/rules"
 )
 
 func TestNewOutputter(t *testing.T) {
@@ -193,3 +195,145 @@ func TestOutputter_InvalidFormat(t *testing.T) {
 		t.Errorf("Expected format to default to 'table', got '%s'", outputter.format)
 	}
 }
+
+func TestOutputter_WriteMetadata(t *testing.T) {
+	metadata := &Metadata{
+		RuleSources: []rules.Verification{
+			{Source: "sigmahq", SHA256: "abc123", Checksum: "matched", Signature: "none", Verified: true},
+		},
+		ToolVersion:  "1.2.3",
+		RuleSetHash:  "def456",
+		ManifestHash: "789abc",
+		Files:        []AnalyzedFile{{Path: "/var/log/auth.log", Offset: 100, Size: 50, SHA256: "fed987"}},
+	}
+
+	capture := func(format string) string {
+		outputter := NewOutputter(format)
+		outputter.SetMetadata(metadata)
+
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		err := outputter.Write(nil)
+		if err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		return buf.String()
+	}
+
+	t.Run("json format", func(t *testing.T) {
+		var decoded struct {
+			Metadata Metadata          `json:"metadata"`
+			Entries  []parser.LogEntry `json:"entries"`
+		}
+		if err := json.Unmarshal([]byte(capture("json")), &decoded); err != nil {
+			t.Fatalf("Failed to decode JSON output: %v", err)
+		}
+		if len(decoded.Metadata.RuleSources) != 1 || !decoded.Metadata.RuleSources[0].Verified {
+			t.Errorf("Expected verified rule source in metadata, got %+v", decoded.Metadata)
+		}
+		if decoded.Metadata.ToolVersion != "1.2.3" || decoded.Metadata.RuleSetHash != "def456" || decoded.Metadata.ManifestHash != "789abc" {
+			t.Errorf("Expected version and hashes in metadata, got %+v", decoded.Metadata)
+		}
+		if len(decoded.Metadata.Files) != 1 || decoded.Metadata.Files[0] != metadata.Files[0] {
+			t.Errorf("Expected analyzed file in metadata, got %+v", decoded.Metadata.Files)
+		}
+		if decoded.Entries == nil {
+			t.Error("Expected entries to be an empty array, not null")
+		}
+	})
+
+	t.Run("csv format", func(t *testing.T) {
+		output := capture("csv")
+		if !strings.HasPrefix(output, "# rule source sigmahq: verified") {
+			t.Errorf("Expected CSV to start with a metadata comment, got %q", output)
+		}
+		if !strings.Contains(output, "timestamp,hostname,program,pid,message,matched_rules") {
+			t.Error("Expected output to contain CSV header")
+		}
+		for _, line := range []string{"# hayanix version: 1.2.3", "# rule set sha256: def456", "# manifest sha256: 789abc", "# file /var/log/auth.log: bytes 100-150 sha256 fed987"} {
+			if !strings.Contains(output, line+"\n") {
+				t.Errorf("Expected metadata comment %q, got %q", line, output)
+			}
+		}
+	})
+}
+
+func TestOutputter_WriteEntry(t *testing.T) {
+	entry := parser.LogEntry{
+		Timestamp:    "2025-01-01T10:30:15.000",
+		Hostname:     "server1",
+		Program:      "sshd",
+		PID:          "1234",
+		Message:      "Failed password for root",
+		MatchedRules: []string{"failed-login"},
+	}
+
+	capture := func(format string) string {
+		outputter := NewOutputter(format)
+		outputter.SetMetadata(&Metadata{ToolVersion: "1.2.3"})
+
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		if err := outputter.Begin(); err != nil {
+			t.Errorf("Begin() error = %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := outputter.WriteEntry(entry); err != nil {
+				t.Errorf("WriteEntry() error = %v", err)
+			}
+		}
+		if err := outputter.End(); err != nil {
+			t.Errorf("End() error = %v", err)
+		}
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		return buf.String()
+	}
+
+	t.Run("table format", func(t *testing.T) {
+		output := capture("table")
+		row := "2025-01-01T10:30:15.000 | server1 | sshd | Failed password for root | failed-login\n"
+		if !strings.HasPrefix(output, "hayanix version: 1.2.3\n") || strings.Count(output, row) != 2 {
+			t.Errorf("Expected metadata and a line per entry, got %q", output)
+		}
+	})
+
+	t.Run("csv format", func(t *testing.T) {
+		output := capture("csv")
+		record := "2025-01-01T10:30:15.000,server1,sshd,1234,Failed password for root,failed-login\n"
+		if strings.Count(output, "timestamp,hostname,program,pid,message,matched_rules\n") != 1 || strings.Count(output, record) != 2 {
+			t.Errorf("Expected one header and a record per entry, got %q", output)
+		}
+	})
+
+	t.Run("json format", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(capture("json")), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected a metadata line and a line per entry, got %q", lines)
+		}
+		var header struct {
+			Metadata Metadata `json:"metadata"`
+		}
+		if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Metadata.ToolVersion != "1.2.3" {
+			t.Errorf("Expected metadata first, got %q (%v)", lines[0], err)
+		}
+		var decoded parser.LogEntry
+		if err := json.Unmarshal([]byte(lines[2]), &decoded); err != nil || decoded.Message != entry.Message {
+			t.Errorf("Expected an entry per line, got %q (%v)", lines[2], err)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auditd format: [node=...] type=... msg=audit(timestamp:serial): ... The
+// node prefix names the host when auditd's name_format is set. The timestamp
+// is epoch seconds, or a local date and time in ausearch -i output, which
+// also puts a space before the colon.
+var auditdRegex = regexp.MustCompile(`^(?:node=(\S+)\s+)?type=(\S+)\s+msg=audit\(([^)]+):(\d+)\)\s*:\s*(.*)$`)
+
+// auditInterpretedLayouts are the date formats ausearch -i prints.
+var auditInterpretedLayouts = []string{"01/02/2006 15:04:05.000", "01/02/06 15:04:05.000"}
+
+// auditPendingLimit bounds how many events wait for more records. Records of
+// one event are written close together, so an event this far behind the
+// newest one is complete even without an EOE record, which auditd only
+// writes after multi-record events.
+const auditPendingLimit = 64
+
+// auditRecord is one line of an auditd event.
+type auditRecord struct {
+	recordType string
+	body       string
+	fields     []auditField
+}
+
+// auditEvent collects the records sharing a timestamp and serial number.
+type auditEvent struct {
+	key      string
+	node     string
+	stamp    string
+	serial   string
+	records  []auditRecord
+	lines    []string
+	complete bool
+}
+
+// auditAssembler groups auditd records into events by serial number. Records
+// of different events may be interleaved or out of order; events are emitted
+// in the order they were first seen once their EOE record arrives, or once
+// they fall auditPendingLimit events behind.
+type auditAssembler struct {
+	opts    Options
+	pending map[string]*auditEvent
+	order   []*auditEvent
+}
+
+func newAuditAssembler(opts Options) *auditAssembler {
+	return &auditAssembler{opts: opts, pending: make(map[string]*auditEvent)}
+}
+
+// add files a record line and returns the events that are now ready. Lines
+// that aren't audit records are ignored, except the ---- lines ausearch
+// prints between events, which end every pending event.
+func (a *auditAssembler) add(line string) []LogEntry {
+	if strings.HasPrefix(line, "----") {
+		return a.flush()
+	}
+
+	matches := auditdRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil
+	}
+
+	// Serial numbers are only unique per host
+	node, recordType, stamp, serial, body := matches[1], matches[2], matches[3], matches[4], matches[5]
+	key := node + " " + stamp + ":" + serial
+	event, ok := a.pending[key]
+	if !ok {
+		event = &auditEvent{key: key, node: node, stamp: stamp, serial: serial}
+		a.pending[key] = event
+		a.order = append(a.order, event)
+	}
+
+	event.lines = append(event.lines, line)
+	if recordType == "EOE" {
+		// An EOE ahead of the records it ends is out of order, so the
+		// event is left to fall behind instead
+		event.complete = len(event.records) > 0
+	} else {
+		event.records = append(event.records, auditRecord{
+			recordType: recordType,
+			body:       body,
+			fields:     decodeAuditRecord(recordType, body),
+		})
+	}
+
+	var ready []LogEntry
+	for len(a.order) > 0 && (a.order[0].complete || len(a.order) > auditPendingLimit) {
+		ready = a.pop(ready)
+	}
+	return ready
+}
+
+// flush returns every pending event, at the end of the input.
+func (a *auditAssembler) flush() []LogEntry {
+	var ready []LogEntry
+	for len(a.order) > 0 {
+		ready = a.pop(ready)
+	}
+	return ready
+}
+
+// held returns the lines of the pending events, oldest event first.
+func (a *auditAssembler) held() []string {
+	var lines []string
+	for _, event := range a.order {
+		lines = append(lines, event.lines...)
+	}
+	return lines
+}
+
+// pop removes the oldest pending event, appending it to ready unless it had
+// nothing but an EOE record.
+func (a *auditAssembler) pop(ready []LogEntry) []LogEntry {
+	event := a.order[0]
+	a.order = a.order[1:]
+	delete(a.pending, event.key)
+	if len(event.records) == 0 {
+		return ready
+	}
+	return append(ready, event.entry(a.opts))
+}
+
+// entry merges an event's records into one entry. Each record's fields are
+// kept under its type, e.g. CWD.cwd, with an index for repeated records:
+// PATH records use their item number (PATH[1].name) and others their
+// position. Fields are also merged without a prefix so rules can combine
+// them: the SYSCALL record comes first, except that EXECVE arguments replace
+// its raw a0-a3 registers, and fields repeated across records of one type,
+// like PATH names, hold every value, one per line.
+func (e *auditEvent) entry(opts Options) LogEntry {
+	entry := LogEntry{
+		Hostname: e.node,
+		Program:  "auditd",
+		Category: "audit",
+		Product:  "linux",
+		Service:  "auditd",
+		Fields:   map[string]string{"serial": e.serial},
+	}
+	if t, ok := parseAuditTime(e.stamp, opts); ok {
+		entry.setTime(t)
+	} else {
+		entry.setInvalidTime(e.stamp)
+	}
+
+	// The SYSCALL record describes the event, so it leads
+	records := make([]auditRecord, 0, len(e.records))
+	for _, record := range e.records {
+		if record.recordType == "SYSCALL" {
+			records = append(records, record)
+		}
+	}
+	for _, record := range e.records {
+		if record.recordType != "SYSCALL" {
+			records = append(records, record)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, record := range records {
+		counts[record.recordType]++
+	}
+
+	var types, messages []string
+	owners := make(map[string]string)
+	seen := make(map[string]int)
+	for _, record := range records {
+		types = append(types, record.recordType)
+		messages = append(messages, "type="+record.recordType+" "+record.body)
+
+		prefix := record.recordType
+		if item := recordField(record, "item"); record.recordType == "PATH" && item != "" {
+			prefix += "[" + item + "]"
+		} else if counts[record.recordType] > 1 {
+			prefix += "[" + strconv.Itoa(seen[record.recordType]) + "]"
+		}
+		seen[record.recordType]++
+
+		for _, field := range record.fields {
+			name, value := field.name, field.value
+			entry.Fields[prefix+"."+name] = value
+
+			owner, ok := owners[name]
+			switch {
+			case !ok, record.recordType == "EXECVE" && owner == "SYSCALL":
+				entry.Fields[name] = value
+				owners[name] = record.recordType
+			case owner == record.recordType:
+				entry.Fields[name] += "\n" + value
+			}
+		}
+	}
+
+	// EXECVE holds the full command line; PROCTITLE's is cut at 128 bytes
+	if _, ok := entry.Fields["cmdline"]; !ok && entry.Fields["proctitle"] != "" {
+		entry.Fields["cmdline"] = entry.Fields["proctitle"]
+	}
+
+	entry.Fields["type"] = strings.Join(types, "\n")
+	entry.Message = strings.Join(messages, "\n")
+	entry.PID = entry.Fields["pid"]
+
+	return entry
+}
+
+func recordField(record auditRecord, name string) string {
+	return auditFieldValue(record.fields, name)
+}
+
+// parseAuditTime parses epoch seconds with a fraction, or the local time
+// ausearch -i prints.
+func parseAuditTime(stamp string, opts Options) (time.Time, bool) {
+	if seconds, fraction, ok := strings.Cut(stamp, "."); ok {
+		secs, err := strconv.ParseInt(seconds, 10, 64)
+		if err == nil && fraction != "" && len(fraction) <= 9 {
+			if nanos, err := strconv.ParseInt((fraction + "000000000")[:9], 10, 64); err == nil {
+				return time.Unix(secs, nanos), true
+			}
+		}
+	}
+
+	for _, layout := range auditInterpretedLayouts {
+		if t, err := time.ParseInLocation(layout, stamp, opts.location()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,304 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// auditField is one name=value pair from an audit record. Quoted values are
+// never hex-encoded.
+type auditField struct {
+	name   string
+	value  string
+	quoted bool
+}
+
+// auditSyscalls names syscall numbers by the architecture in a SYSCALL
+// record's arch field: x86_64, i386 and aarch64.
+var auditSyscalls = map[string]map[int]string{
+	"c000003e": syscallsX8664,
+	"40000003": syscallsI386,
+	"c00000b7": syscallsAarch64,
+}
+
+// auditHexFields are string fields auditd hex-encodes, leaving them unquoted,
+// when their value contains a space, quote or control character.
+var auditHexFields = map[string]bool{
+	"proctitle": true, "name": true, "cwd": true, "comm": true, "exe": true,
+	"path": true, "cmd": true, "key": true, "data": true, "dir": true,
+	"ocomm": true, "watch": true, "acct": true,
+}
+
+// auditIDFields hold user or group ids. Enriched logs add their names under
+// the upper-case field name, e.g. AUID="alice".
+var auditIDFields = []string{
+	"auid", "uid", "euid", "suid", "fsuid", "ouid", "oauid", "iuid",
+	"gid", "egid", "sgid", "fsgid", "ogid",
+}
+
+var execveArgRegex = regexp.MustCompile(`^a(\d+)(?:\[(\d+)\]|(_len))?$`)
+
+// decodeAuditRecord splits a record body into fields and decodes auditd's
+// encodings: quoted strings, hex-encoded strings and command lines, nested
+// msg='...' fields, hex sockaddrs, syscall numbers and well-known ids. Fields
+// after a 0x1d separator come from enriched logs (log_format = ENRICHED) and
+// are kept as they are.
+func decodeAuditRecord(recordType, body string) []auditField {
+	raw, enriched, _ := strings.Cut(body, "\x1d")
+	fields := tokenizeAuditFields(raw)
+
+	if recordType == "EXECVE" {
+		fields = decodeExecve(fields)
+	}
+
+	var syscalls map[int]string
+	var extra []auditField
+	for i, field := range fields {
+		switch {
+		case field.name == "arch":
+			syscalls = auditSyscalls[strings.ToLower(field.value)]
+		case field.name == "syscall":
+			if number, err := strconv.Atoi(field.value); err == nil && syscalls[number] != "" {
+				fields[i].value = syscalls[number]
+			}
+		case field.name == "saddr" && !field.quoted:
+			extra = append(extra, decodeSockaddr(field.value)...)
+		case auditHexFields[field.name] && !field.quoted:
+			fields[i].value = decodeAuditHex(field.name, field.value)
+		}
+	}
+
+	fields = append(fields, extra...)
+	fields = append(fields, tokenizeAuditFields(enriched)...)
+	return addAuditIDNames(fields)
+}
+
+// tokenizeAuditFields splits name=value pairs. Values may be bare, "quoted",
+// 'quoted' (msg='...' holds further fields, which are expanded), or braced
+// as ausearch -i prints sockaddrs: saddr={ saddr_fam=inet laddr=... }.
+// ausearch -i also prints proctitle as plain text up to the end of the line.
+// When a name repeats, the first value is kept.
+func tokenizeAuditFields(body string) []auditField {
+	var fields []auditField
+	seen := make(map[string]bool)
+	add := func(field auditField) {
+		if !seen[field.name] {
+			seen[field.name] = true
+			fields = append(fields, field)
+		}
+	}
+
+	for rest := body; ; {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return fields
+		}
+
+		token := rest
+		if end := strings.IndexByte(rest, ' '); end >= 0 {
+			token = rest[:end]
+		}
+		name, value, ok := strings.Cut(token, "=")
+		if !ok || name == "" {
+			// Not a field, such as ausearch's ":" separator
+			rest = rest[len(token):]
+			continue
+		}
+
+		after := rest[len(name)+1:]
+		switch {
+		case strings.HasPrefix(after, `"`):
+			quoted, tail := cutQuoted(after[1:], '"')
+			add(auditField{name: name, value: quoted, quoted: true})
+			rest = tail
+		case strings.HasPrefix(after, "'"):
+			quoted, tail := cutQuoted(after[1:], '\'')
+			if name == "msg" {
+				for _, field := range tokenizeAuditFields(quoted) {
+					add(field)
+				}
+			} else {
+				add(auditField{name: name, value: quoted, quoted: true})
+			}
+			rest = tail
+		case strings.HasPrefix(after, "{"):
+			inner, tail, _ := strings.Cut(after[1:], "}")
+			add(auditField{name: name, value: strings.TrimSpace(inner), quoted: true})
+			for _, field := range tokenizeAuditFields(inner) {
+				add(field)
+			}
+			rest = tail
+		case name == "proctitle" && !isAuditHex(value):
+			add(auditField{name: name, value: strings.TrimRight(after, " "), quoted: true})
+			rest = ""
+		default:
+			add(auditField{name: name, value: value})
+			rest = rest[len(token):]
+		}
+	}
+}
+
+// cutQuoted returns s up to the closing quote and what follows it.
+func cutQuoted(s string, quote byte) (string, string) {
+	end := strings.IndexByte(s, quote)
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end+1:]
+}
+
+// isAuditHex reports whether value looks like auditd's upper-case hex
+// encoding.
+func isAuditHex(value string) bool {
+	if len(value) < 2 || len(value)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAuditHex decodes a hex-encoded string field. The arguments in a
+// proctitle are separated by NULs, and several keys by 0x01.
+func decodeAuditHex(name, value string) string {
+	if !isAuditHex(value) {
+		return value
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+
+	switch name {
+	case "proctitle":
+		return strings.TrimRight(strings.ReplaceAll(string(decoded), "\x00", " "), " ")
+	case "key":
+		return strings.ReplaceAll(string(decoded), "\x01", "\n")
+	default:
+		return string(decoded)
+	}
+}
+
+// decodeExecve decodes the arguments of an EXECVE record, joining long ones
+// auditd split into hex chunks (a1_len=N a1[0]=... a1[1]=...), and adds the
+// reconstructed command line as cmdline.
+func decodeExecve(fields []auditField) []auditField {
+	args := make(map[int]string)
+	var decoded []auditField
+	for _, field := range fields {
+		matches := execveArgRegex.FindStringSubmatch(field.name)
+		switch {
+		case matches == nil:
+			decoded = append(decoded, field)
+		case matches[3] != "":
+			// The total length of a chunked argument
+		case matches[2] != "":
+			index, _ := strconv.Atoi(matches[1])
+			chunk, _ := hex.DecodeString(field.value)
+			args[index] += string(chunk)
+		default:
+			index, _ := strconv.Atoi(matches[1])
+			args[index] = field.value
+			if !field.quoted {
+				args[index] = decodeAuditHex(field.name, field.value)
+			}
+		}
+	}
+
+	argc, err := strconv.Atoi(auditFieldValue(decoded, "argc"))
+	if err != nil {
+		argc = len(args)
+	}
+
+	var cmdline []string
+	for i := 0; i < argc; i++ {
+		arg, ok := args[i]
+		if !ok {
+			continue
+		}
+		decoded = append(decoded, auditField{name: "a" + strconv.Itoa(i), value: arg, quoted: true})
+		cmdline = append(cmdline, arg)
+	}
+	return append(decoded, auditField{name: "cmdline", value: strings.Join(cmdline, " "), quoted: true})
+}
+
+// decodeSockaddr decodes a hex sockaddr into its family, address and port,
+// or socket path, using the field names ausearch -i prints.
+func decodeSockaddr(value string) []auditField {
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) < 2 {
+		return nil
+	}
+
+	field := func(name, value string) auditField {
+		return auditField{name: name, value: value, quoted: true}
+	}
+	switch family := binary.LittleEndian.Uint16(data); family {
+	case 1:
+		path := strings.TrimRight(string(data[2:]), "\x00")
+		if strings.HasPrefix(path, "\x00") {
+			// Abstract socket names start with a NUL
+			path = "@" + path[1:]
+		}
+		return []auditField{field("saddr_fam", "local"), field("path", path)}
+	case 2:
+		if len(data) < 8 {
+			return nil
+		}
+		return []auditField{
+			field("saddr_fam", "inet"),
+			field("laddr", net.IP(data[4:8]).String()),
+			field("lport", strconv.Itoa(int(binary.BigEndian.Uint16(data[2:])))),
+		}
+	case 10:
+		if len(data) < 24 {
+			return nil
+		}
+		return []auditField{
+			field("saddr_fam", "inet6"),
+			field("laddr", net.IP(data[8:24]).String()),
+			field("lport", strconv.Itoa(int(binary.BigEndian.Uint16(data[2:])))),
+		}
+	case 16:
+		return []auditField{field("saddr_fam", "netlink")}
+	default:
+		return []auditField{field("saddr_fam", strconv.Itoa(int(family)))}
+	}
+}
+
+// addAuditIDNames names well-known ids that enriched logs didn't already
+// name: 0 is root, and -1 marks an unset login uid.
+func addAuditIDNames(fields []auditField) []auditField {
+	for _, name := range auditIDFields {
+		value := auditFieldValue(fields, name)
+		upper := strings.ToUpper(name)
+		if value == "" || auditFieldValue(fields, upper) != "" {
+			continue
+		}
+
+		switch value {
+		case "0":
+			fields = append(fields, auditField{name: upper, value: "root", quoted: true})
+		case "4294967295", "-1":
+			fields = append(fields, auditField{name: upper, value: "unset", quoted: true})
+		}
+	}
+	return fields
+}
+
+func auditFieldValue(fields []auditField, name string) string {
+	for _, field := range fields {
+		if field.name == name {
+			return field.value
+		}
+	}
+	return ""
+}
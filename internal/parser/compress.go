@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats recognised by their magic bytes.
+var compressionMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// compressionExts are the extensions of compressed logs, such as rotated
+// auth.log.2.gz.
+var compressionExts = []string{".gz", ".bz2", ".xz", ".zst"}
+
+// CompressionFormat names the compression of data starting with head, or
+// returns "" for uncompressed data.
+func CompressionFormat(head []byte) string {
+	for _, format := range compressionMagic {
+		if bytes.HasPrefix(head, format.magic) {
+			return format.name
+		}
+	}
+	return ""
+}
+
+// TrimCompressionExt strips a compression extension from a file name, so
+// auth.log.2.gz becomes auth.log.2.
+func TrimCompressionExt(name string) string {
+	for _, ext := range compressionExts {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// Decompress returns a reader of r's contents, decompressing gzip, bzip2, xz
+// or zstd data detected by its magic bytes, whatever the file is named.
+// Uncompressed data is passed through. Closing the reader releases the
+// decompressor but doesn't close r.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	head, _ := buffered.Peek(6)
+
+	switch CompressionFormat(head) {
+	case "gzip":
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip data: %w", err)
+		}
+		return reader, nil
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(buffered)), nil
+	case "xz":
+		reader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xz data: %w", err)
+		}
+		return io.NopCloser(reader), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd data: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+// OpenDecompressed opens a log file for reading, decompressing it if needed.
+// Closing the reader closes the file.
+func OpenDecompressed(path string) (io.ReadCloser, error) {
+	file, err := openLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return decompressedFile{ReadCloser: reader, file: file}, nil
+}
+
+type decompressedFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (d decompressedFile) Close() error {
+	d.ReadCloser.Close()
+	return d.file.Close()
+}
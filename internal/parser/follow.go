@@ -0,0 +1,317 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Follower is implemented by parsers that can parse a log as it is written.
+type Follower interface {
+	// Follow parses lines as they arrive until lines is closed. Entries
+	// that later lines could still add to, such as a syslog message that
+	// may continue or an audit event missing records, are emitted once no
+	// line has arrived for FollowFlushDelay.
+	Follow(ctx context.Context, lines <-chan string, out chan<- LogEntry) error
+}
+
+// FollowFlushDelay is how long a followed log must be quiet before entries
+// held back for continuation lines are emitted.
+const FollowFlushDelay = 500 * time.Millisecond
+
+// lineAssembler builds entries from the lines of a text log.
+type lineAssembler interface {
+	// add parses a line and returns the entries it completes.
+	add(line string) []LogEntry
+	// flush returns every entry held back for later lines.
+	flush() []LogEntry
+	// held returns the lines of the entries held back for later lines.
+	held() []string
+}
+
+// streamLines parses every line of r with an assembler.
+func streamLines(ctx context.Context, r io.Reader, assembler lineAssembler, opts Options, out chan<- LogEntry) error {
+	if err := scanLines(ctx, r, assembler, opts, out); err != nil {
+		return err
+	}
+	return sendAll(ctx, opts, out, assembler.flush())
+}
+
+// resumeLines parses held and then every line of r with an assembler. With
+// hold, the entries still held back at the end aren't sent, and their lines
+// are returned instead.
+func resumeLines(ctx context.Context, r io.Reader, held []string, hold bool, assembler lineAssembler, opts Options, out chan<- LogEntry) ([]string, error) {
+	for _, line := range held {
+		if err := sendAll(ctx, opts, out, assembler.add(line)); err != nil {
+			return nil, err
+		}
+	}
+	if !hold {
+		return nil, streamLines(ctx, r, assembler, opts, out)
+	}
+	if err := scanLines(ctx, r, assembler, opts, out); err != nil {
+		return nil, err
+	}
+	return assembler.held(), nil
+}
+
+// scanLines parses every line of r with an assembler, sending the entries
+// they complete.
+func scanLines(ctx context.Context, r io.Reader, assembler lineAssembler, opts Options, out chan<- LogEntry) error {
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if err := sendAll(ctx, opts, out, assembler.add(line)); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	return nil
+}
+
+// followLines parses lines as they arrive with an assembler, flushing it
+// whenever the log goes quiet.
+func followLines(ctx context.Context, lines <-chan string, assembler lineAssembler, opts Options, out chan<- LogEntry) error {
+	quiet := time.NewTimer(FollowFlushDelay)
+	defer quiet.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return sendAll(ctx, opts, out, assembler.flush())
+			}
+			if line == "" {
+				continue
+			}
+			if err := sendAll(ctx, opts, out, assembler.add(line)); err != nil {
+				return err
+			}
+
+			// Restart the quiet period
+			if !quiet.Stop() {
+				select {
+				case <-quiet.C:
+				default:
+				}
+			}
+			quiet.Reset(FollowFlushDelay)
+		case <-quiet.C:
+			if err := sendAll(ctx, opts, out, assembler.flush()); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func sendAll(ctx context.Context, opts Options, out chan<- LogEntry, entries []LogEntry) error {
+	for _, entry := range entries {
+		if err := opts.send(ctx, out, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tail reads the lines appended to a log file, like tail -F. It notices when
+// the file is rotated, by being renamed or removed and replaced, and finishes
+// reading the old file before moving on to the new one; and when it is
+// truncated in place, as by logrotate's copytruncate, and starts over from
+// the beginning.
+type Tail struct {
+	path     string
+	interval time.Duration
+
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+// DefaultPollInterval is how often a Tail checks its file for new lines and
+// rotation.
+const DefaultPollInterval = 250 * time.Millisecond
+
+// OpenTail opens the log file at path to follow it from its current end.
+func OpenTail(path string) (*Tail, error) {
+	return OpenTailAt(path, -1)
+}
+
+// OpenTailAt opens the log file at path to follow it from offset, such as
+// where a checkpoint left off, or from its end if offset is negative or past
+// the end.
+func OpenTailAt(path string, offset int64) (*Tail, error) {
+	file, err := openLog(path)
+	if err != nil {
+		return nil, err
+	}
+	position, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek to the end of %s: %w", path, err)
+	}
+	if offset >= 0 && offset < position {
+		if position, err = file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek in %s: %w", path, err)
+		}
+	}
+
+	t := &Tail{path: path, interval: DefaultPollInterval}
+	if err := t.open(file, position); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// File is the file being read, which changes when the log is rotated.
+func (t *Tail) File() *os.File {
+	return t.file
+}
+
+// Offset is where the line after the last one sent starts in File.
+func (t *Tail) Offset() int64 {
+	return t.offset - int64(len(t.partial))
+}
+
+// Close closes the file being read.
+func (t *Tail) Close() error {
+	return t.file.Close()
+}
+
+// SetPollInterval sets how often the file is checked once everything
+// written to it has been read.
+func (t *Tail) SetPollInterval(interval time.Duration) {
+	t.interval = interval
+}
+
+// Lines sends each complete line appended to the file to out until ctx is
+// cancelled, which is not an error. Lines written before then are still sent,
+// so out must be read until Lines returns. A file that is rotated away is
+// waited for until its replacement appears.
+func (t *Tail) Lines(ctx context.Context, out chan<- string) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	buf := make([]byte, 64*1024)
+	for {
+		// Read everything written so far
+		if err := t.readAll(buf, out); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return t.readAll(buf, out)
+		case <-ticker.C:
+		}
+
+		if err := t.checkRotation(buf, out); err != nil {
+			return err
+		}
+	}
+}
+
+// open starts reading file from offset.
+func (t *Tail) open(file *os.File, offset int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat file %s: %w", t.path, err)
+	}
+	t.file, t.info, t.offset, t.partial = file, info, offset, nil
+	return nil
+}
+
+// readAll sends the lines written to the file since it was last read.
+func (t *Tail) readAll(buf []byte, out chan<- string) error {
+	for {
+		n, err := t.file.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			t.sendLines(buf[:n], out)
+		}
+		if err == io.EOF || (err == nil && n == 0) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", t.path, err)
+		}
+	}
+}
+
+// sendLines sends the complete lines in data, keeping a trailing partial line
+// until the rest of it is written.
+func (t *Tail) sendLines(data []byte, out chan<- string) {
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		line := append(t.partial, data[:end]...)
+		t.partial = nil
+		data = data[end+1:]
+		out <- string(bytes.TrimRight(line, "\r"))
+	}
+
+	t.partial = append(t.partial, data...)
+	if len(t.partial) >= maxLineSize {
+		t.flushPartial(out)
+	}
+}
+
+// flushPartial sends a line that will never be finished, because the file
+// was rotated or the line is too long.
+func (t *Tail) flushPartial(out chan<- string) {
+	if len(t.partial) > 0 {
+		out <- string(t.partial)
+		t.partial = nil
+	}
+}
+
+// checkRotation compares the open file with what is at its path now, moving
+// to a new file or back to the start of a truncated one.
+func (t *Tail) checkRotation(buf []byte, out chan<- string) error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		// Rotated away, and not replaced yet
+		return nil
+	}
+
+	if !os.SameFile(info, t.info) {
+		// Finish the old file, which may have been written to since
+		if err := t.readAll(buf, out); err != nil {
+			return err
+		}
+		t.flushPartial(out)
+
+		file, err := os.Open(t.path)
+		if err != nil {
+			// Replaced again before it could be opened; try next time
+			return nil
+		}
+		t.file.Close()
+		return t.open(file, 0)
+	}
+
+	if info.Size() < t.offset {
+		// Truncated in place: whatever is there now is new
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to the start of %s: %w", t.path, err)
+		}
+		t.offset, t.partial = 0, nil
+		t.info = info
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The systemd journal file format is described at
+// https://systemd.io/JOURNAL_FILE_FORMAT/. Only the parts needed to read
+// entries in order are implemented: the header, entry arrays, entries and
+// data objects.
+
+// journalSignature starts every journal file.
+var journalSignature = []byte("LPKSHHRH")
+
+// Header incompatible flags
+const (
+	journalCompressedXZ   = 1 << 0
+	journalCompressedLZ4  = 1 << 1
+	journalKeyedHash      = 1 << 2
+	journalCompressedZSTD = 1 << 3
+	journalCompact        = 1 << 4
+
+	journalSupportedFlags = journalCompressedXZ | journalCompressedLZ4 | journalKeyedHash |
+		journalCompressedZSTD | journalCompact
+)
+
+// Object types
+const (
+	journalObjectData       = 1
+	journalObjectEntry      = 3
+	journalObjectEntryArray = 6
+)
+
+// Object compression flags
+const (
+	journalObjectXZ   = 1 << 0
+	journalObjectLZ4  = 1 << 1
+	journalObjectZSTD = 1 << 2
+)
+
+const (
+	journalHeaderMinSize   = 208
+	journalObjectHeaderLen = 16
+
+	// journalDataCacheSize bounds the decoded data objects kept around.
+	// Fields such as _HOSTNAME and _BOOT_ID are shared by most entries.
+	journalDataCacheSize = 4096
+
+	// journalMaxPayload bounds a decompressed field value.
+	journalMaxPayload = 64 * 1024 * 1024
+)
+
+// IsJournalFile reports whether the file at path is a binary systemd journal.
+func IsJournalFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	signature := make([]byte, len(journalSignature))
+	if _, err := io.ReadFull(file, signature); err != nil {
+		return false
+	}
+	return bytes.Equal(signature, journalSignature)
+}
+
+// journalFiles lists the journal files under dir, ordered by the time of
+// their first entry so archived files are read before the active ones.
+func journalFiles(dir string) ([]string, error) {
+	type journal struct {
+		path  string
+		first uint64
+	}
+
+	var journals []journal
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !(strings.HasSuffix(path, ".journal") || strings.HasSuffix(path, ".journal~")) {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open journal file %s: %w", path, err)
+		}
+		defer file.Close()
+
+		header := make([]byte, journalHeaderMinSize)
+		if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header[:8], journalSignature) {
+			// Not a journal file despite its name
+			return nil
+		}
+		journals = append(journals, journal{path: path, first: binary.LittleEndian.Uint64(header[184:])})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal files in %s: %w", dir, err)
+	}
+
+	sort.SliceStable(journals, func(i, j int) bool {
+		return journals[i].first < journals[j].first
+	})
+
+	paths := make([]string, len(journals))
+	for i, journal := range journals {
+		paths[i] = journal.path
+	}
+	return paths, nil
+}
+
+// journalReader reads entries from one journal file.
+type journalReader struct {
+	r       io.ReaderAt
+	size    int64
+	compact bool
+
+	entryArrayOffset uint64
+	entries          uint64
+
+	cache map[uint64]journalField
+	zstd  *zstd.Decoder
+}
+
+// journalField is a decoded data object.
+type journalField struct {
+	name  string
+	value string
+}
+
+func newJournalReader(r io.ReaderAt, size int64) (*journalReader, error) {
+	header := make([]byte, journalHeaderMinSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read journal header: %w", err)
+	}
+	if !bytes.Equal(header[:8], journalSignature) {
+		return nil, fmt.Errorf("not a journal file")
+	}
+
+	incompatible := binary.LittleEndian.Uint32(header[12:])
+	if incompatible&^journalSupportedFlags != 0 {
+		return nil, fmt.Errorf("unsupported journal features: %#x", incompatible&^journalSupportedFlags)
+	}
+
+	return &journalReader{
+		r:                r,
+		size:             size,
+		compact:          incompatible&journalCompact != 0,
+		entryArrayOffset: binary.LittleEndian.Uint64(header[176:]),
+		entries:          binary.LittleEndian.Uint64(header[152:]),
+		cache:            make(map[uint64]journalField),
+	}, nil
+}
+
+func (j *journalReader) close() {
+	if j.zstd != nil {
+		j.zstd.Close()
+	}
+}
+
+// readObject reads the object at offset, checking its type.
+func (j *journalReader) readObject(offset uint64, objectType byte) (flags byte, body []byte, err error) {
+	// Offsets and sizes come from the file, so compare without adding them
+	// where a corrupt value could overflow
+	fileSize := uint64(j.size)
+	if offset == 0 || offset%8 != 0 || offset > fileSize || fileSize-offset < journalObjectHeaderLen {
+		return 0, nil, fmt.Errorf("invalid object offset %d", offset)
+	}
+
+	header := make([]byte, journalObjectHeaderLen)
+	if _, err := j.r.ReadAt(header, int64(offset)); err != nil {
+		return 0, nil, fmt.Errorf("failed to read object at %d: %w", offset, err)
+	}
+	if header[0] != objectType {
+		return 0, nil, fmt.Errorf("object at %d has type %d, expected %d", offset, header[0], objectType)
+	}
+
+	size := binary.LittleEndian.Uint64(header[8:])
+	if size < journalObjectHeaderLen || size > fileSize-offset {
+		return 0, nil, fmt.Errorf("object at %d has invalid size %d", offset, size)
+	}
+
+	body = make([]byte, size-journalObjectHeaderLen)
+	if _, err := j.r.ReadAt(body, int64(offset)+journalObjectHeaderLen); err != nil {
+		return 0, nil, fmt.Errorf("failed to read object at %d: %w", offset, err)
+	}
+	return header[1], body, nil
+}
+
+// stream walks the entry array chain, which lists entries in the order they
+// were written, and sends each entry.
+func (j *journalReader) stream(ctx context.Context, emit func(LogEntry) error) error {
+	itemSize := uint64(8)
+	if j.compact {
+		itemSize = 4
+	}
+
+	sent := uint64(0)
+	visited := make(map[uint64]bool)
+	for offset := j.entryArrayOffset; offset != 0 && sent < j.entries; {
+		// A corrupt chain can point back at an array already read
+		if visited[offset] {
+			return fmt.Errorf("entry array chain loops back to %d", offset)
+		}
+		visited[offset] = true
+
+		_, body, err := j.readObject(offset, journalObjectEntryArray)
+		if err != nil {
+			return err
+		}
+		if len(body) < 8 {
+			return fmt.Errorf("entry array at %d is truncated", offset)
+		}
+		offset = binary.LittleEndian.Uint64(body)
+
+		for items := body[8:]; uint64(len(items)) >= itemSize && sent < j.entries; items = items[itemSize:] {
+			var entryOffset uint64
+			if j.compact {
+				entryOffset = uint64(binary.LittleEndian.Uint32(items))
+			} else {
+				entryOffset = binary.LittleEndian.Uint64(items)
+			}
+			if entryOffset == 0 {
+				// Unused tail of the last array
+				break
+			}
+
+			entry, err := j.readEntry(entryOffset)
+			if err != nil {
+				return err
+			}
+			sent++
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (j *journalReader) readEntry(offset uint64) (LogEntry, error) {
+	_, body, err := j.readObject(offset, journalObjectEntry)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	if len(body) < 48 {
+		return LogEntry{}, fmt.Errorf("entry at %d is truncated", offset)
+	}
+
+	seqnum := binary.LittleEndian.Uint64(body[0:])
+	realtime := binary.LittleEndian.Uint64(body[8:])
+	monotonic := binary.LittleEndian.Uint64(body[16:])
+
+	fields := map[string]string{
+		"__REALTIME_TIMESTAMP":  strconv.FormatUint(realtime, 10),
+		"__MONOTONIC_TIMESTAMP": strconv.FormatUint(monotonic, 10),
+		"__SEQNUM":              strconv.FormatUint(seqnum, 10),
+	}
+
+	itemSize := 16
+	if j.compact {
+		itemSize = 4
+	}
+	for items := body[48:]; len(items) >= itemSize; items = items[itemSize:] {
+		var dataOffset uint64
+		if j.compact {
+			dataOffset = uint64(binary.LittleEndian.Uint32(items))
+		} else {
+			dataOffset = binary.LittleEndian.Uint64(items)
+		}
+
+		field, err := j.readData(dataOffset)
+		if err != nil {
+			return LogEntry{}, fmt.Errorf("entry at %d: %w", offset, err)
+		}
+		addJournalField(fields, field.name, field.value)
+	}
+
+	return journalEntry(fields), nil
+}
+
+// readData returns the field stored in the data object at offset.
+func (j *journalReader) readData(offset uint64) (journalField, error) {
+	if field, ok := j.cache[offset]; ok {
+		return field, nil
+	}
+
+	flags, body, err := j.readObject(offset, journalObjectData)
+	if err != nil {
+		return journalField{}, err
+	}
+
+	// Compact files add the tail entry array offset and count
+	payloadStart := 48
+	if j.compact {
+		payloadStart = 56
+	}
+	if len(body) < payloadStart {
+		return journalField{}, fmt.Errorf("data object at %d is truncated", offset)
+	}
+
+	payload, err := j.decompress(flags, body[payloadStart:])
+	if err != nil {
+		return journalField{}, fmt.Errorf("data object at %d: %w", offset, err)
+	}
+
+	name, value, ok := strings.Cut(string(payload), "=")
+	if !ok {
+		return journalField{}, fmt.Errorf("data object at %d is not a FIELD=value pair", offset)
+	}
+	field := journalField{name: name, value: value}
+
+	if len(j.cache) >= journalDataCacheSize {
+		j.cache = make(map[uint64]journalField)
+	}
+	j.cache[offset] = field
+	return field, nil
+}
+
+func (j *journalReader) decompress(flags byte, payload []byte) ([]byte, error) {
+	switch {
+	case flags&journalObjectXZ != 0:
+		// journald writes a single stream, which ends in the footer magic
+		// "YZ", so padding after it can be trimmed
+		stream := bytes.TrimRight(payload, "\x00")
+		reader, err := xz.ReaderConfig{SingleStream: true}.NewReader(bytes.NewReader(stream))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress xz data: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(reader, journalMaxPayload))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress xz data: %w", err)
+		}
+		return data, nil
+	case flags&journalObjectLZ4 != 0:
+		// An LZ4 block prefixed with its decompressed size
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("lz4 data is truncated")
+		}
+		size := binary.LittleEndian.Uint64(payload)
+		if size > journalMaxPayload {
+			return nil, fmt.Errorf("lz4 data too large: %d bytes", size)
+		}
+		return decodeLZ4Block(payload[8:], int(size))
+	case flags&journalObjectZSTD != 0:
+		if j.zstd == nil {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(journalMaxPayload))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+			}
+			j.zstd = decoder
+		}
+		data, err := j.zstd.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress zstd data: %w", err)
+		}
+		return data, nil
+	default:
+		return payload, nil
+	}
+}
+
+// addJournalField stores a field value. Fields that appear more than once in
+// an entry keep every value, one per line.
+func addJournalField(fields map[string]string, name, value string) {
+	if existing, ok := fields[name]; ok {
+		fields[name] = existing + "\n" + value
+		return
+	}
+	fields[name] = value
+}
+
+// fillJournalEntry sets the common entry fields from the journal fields.
+func fillJournalEntry(entry *LogEntry) {
+	entry.Message = entry.Fields["MESSAGE"]
+	entry.Hostname = entry.Fields["_HOSTNAME"]
+
+	entry.Program = entry.Fields["SYSLOG_IDENTIFIER"]
+	if entry.Program == "" {
+		entry.Program = entry.Fields["_COMM"]
+	}
+
+	entry.PID = entry.Fields["_PID"]
+	if entry.PID == "" {
+		entry.PID = entry.Fields["SYSLOG_PID"]
+	}
+}
+
+// decodeLZ4Block decompresses a raw LZ4 block into exactly size bytes.
+func decodeLZ4Block(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+
+	// readLength continues a length whose 4-bit token value was 15
+	readLength := func(i *int, length int) (int, error) {
+		for {
+			if *i >= len(src) {
+				return 0, fmt.Errorf("lz4 data is truncated")
+			}
+			b := src[*i]
+			*i++
+			length += int(b)
+			if b != 255 {
+				return length, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		// Literals
+		literals := int(token >> 4)
+		if literals == 15 {
+			var err error
+			if literals, err = readLength(&i, literals); err != nil {
+				return nil, err
+			}
+		}
+		if literals > len(src)-i || len(dst)+literals > size {
+			return nil, fmt.Errorf("lz4 literals overrun")
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+
+		// The last sequence has no match
+		if i == len(src) {
+			break
+		}
+
+		// Match
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("lz4 data is truncated")
+		}
+		matchOffset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if matchOffset == 0 || matchOffset > len(dst) {
+			return nil, fmt.Errorf("lz4 match offset %d out of range", matchOffset)
+		}
+
+		matchLength := int(token & 15)
+		if matchLength == 15 {
+			var err error
+			if matchLength, err = readLength(&i, matchLength); err != nil {
+				return nil, err
+			}
+		}
+		matchLength += 4
+		if len(dst)+matchLength > size {
+			return nil, fmt.Errorf("lz4 match overrun")
+		}
+
+		// Matches may overlap the bytes they produce, so copy one at a time
+		start := len(dst) - matchOffset
+		for k := 0; k < matchLength; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+
+	if len(dst) != size {
+		return nil, fmt.Errorf("lz4 data decompressed to %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Journal data formats recognised by JournalFormat.
+const (
+	JournalBinary = "journal"
+	JournalJSON   = "json"
+	JournalExport = "export"
+)
+
+// JournalFormat identifies journald data from the first bytes of a file:
+// a binary journal, `journalctl -o json` output or `journalctl -o export`
+// output. It returns "" for anything else.
+func JournalFormat(head []byte) string {
+	if bytes.HasPrefix(head, journalSignature) {
+		return JournalBinary
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		for _, field := range []string{`"__CURSOR"`, `"__REALTIME_TIMESTAMP"`} {
+			if bytes.Contains(trimmed, []byte(field)) {
+				return JournalJSON
+			}
+		}
+	}
+
+	for _, field := range []string{"__CURSOR=", "__REALTIME_TIMESTAMP="} {
+		if bytes.HasPrefix(trimmed, []byte(field)) {
+			return JournalExport
+		}
+	}
+
+	return ""
+}
+
+// journalEntry builds an entry from journal fields, timed by the
+// microsecond __REALTIME_TIMESTAMP.
+func journalEntry(fields map[string]string) LogEntry {
+	entry := LogEntry{
+		Category: "process",
+		Product:  "linux",
+		Service:  "journald",
+		Fields:   fields,
+	}
+
+	realtime := fields["__REALTIME_TIMESTAMP"]
+	if usec, err := strconv.ParseInt(realtime, 10, 64); err == nil {
+		entry.setTime(time.UnixMicro(usec))
+	} else {
+		entry.setInvalidTime(realtime)
+	}
+
+	fillJournalEntry(&entry)
+	return entry
+}
+
+// streamJournalExport parses the journal export format: FIELD=value lines,
+// with binary-safe fields written as the name, a newline, a little-endian
+// 64-bit length and the raw value. Entries end with an empty line.
+func streamJournalExport(ctx context.Context, r *bufio.Reader, emit func(LogEntry) error) error {
+	fields := make(map[string]string)
+	flush := func() error {
+		if len(fields) == 0 {
+			return nil
+		}
+		entry := journalEntry(fields)
+		fields = make(map[string]string)
+		return emit(entry)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := readExportLine(r)
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		if line == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if name, value, ok := strings.Cut(line, "="); ok {
+			addJournalField(fields, name, value)
+			continue
+		}
+
+		// Binary-safe field
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("failed to read length of field %s: %w", line, err)
+		}
+		if size > journalMaxPayload {
+			return fmt.Errorf("field %s too large: %d bytes", line, size)
+		}
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return fmt.Errorf("failed to read field %s: %w", line, err)
+		}
+		if value[size] != '\n' {
+			return fmt.Errorf("field %s is not followed by a newline", line)
+		}
+		addJournalField(fields, line, string(value[:size]))
+	}
+}
+
+// readExportLine reads one line without its newline, bounded by maxLineSize.
+func readExportLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return "", fmt.Errorf("line longer than %d bytes", maxLineSize)
+		}
+
+		switch {
+		case err == nil:
+			return string(line[:len(line)-1]), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err == io.EOF && len(line) > 0:
+			return string(line), nil
+		default:
+			return "", err
+		}
+	}
+}
+
+// streamJournalJSON parses `journalctl -o json` output, one object per
+// entry. Values are strings, byte arrays for binary data, or arrays of
+// either for fields with several values; null marks a value journalctl
+// left out for its size.
+func streamJournalJSON(ctx context.Context, r io.Reader, emit func(LogEntry) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var object map[string]json.RawMessage
+		if err := decoder.Decode(&object); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode journal JSON: %w", err)
+		}
+
+		fields := make(map[string]string, len(object))
+		for name, raw := range object {
+			values, err := journalJSONValues(raw)
+			if err != nil {
+				return fmt.Errorf("failed to decode journal field %s: %w", name, err)
+			}
+			for _, value := range values {
+				addJournalField(fields, name, value)
+			}
+		}
+
+		if err := emit(journalEntry(fields)); err != nil {
+			return err
+		}
+	}
+}
+
+func journalJSONValues(raw json.RawMessage) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		if data, ok := journalJSONBytes(v); ok {
+			return []string{data}, nil
+		}
+
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				values = append(values, item)
+			case []interface{}:
+				data, ok := journalJSONBytes(item)
+				if !ok {
+					return nil, fmt.Errorf("unexpected nested value")
+				}
+				values = append(values, data)
+			case nil:
+			default:
+				return nil, fmt.Errorf("unexpected value %v", item)
+			}
+		}
+		return values, nil
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// journalJSONBytes decodes a binary value written as an array of byte
+// values.
+func journalJSONBytes(items []interface{}) (string, bool) {
+	if len(items) == 0 {
+		return "", false
+	}
+
+	data := make([]byte, len(items))
+	for i, item := range items {
+		number, ok := item.(float64)
+		if !ok || number < 0 || number > 255 || number != float64(byte(number)) {
+			return "", false
+		}
+		data[i] = byte(number)
+	}
+	return string(data), true
+}
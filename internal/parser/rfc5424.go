@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogFacilities and syslogSeverities name the PRI components (RFC 5424
+// section 6.2.1).
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// parsePRI splits a leading "<PRI>" off line. ok is false when there is none.
+func parsePRI(line string) (pri int, rest string, ok bool) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, line, false
+	}
+
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, line, false
+	}
+
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, line, false
+	}
+
+	return pri, line[end+1:], true
+}
+
+// setPRIFields records the priority with its facility and severity names.
+func setPRIFields(fields map[string]string, pri int) {
+	fields["priority"] = strconv.Itoa(pri)
+	fields["facility"] = syslogFacilities[pri/8]
+	fields["severity"] = syslogSeverities[pri%8]
+}
+
+// parseRFC5424 parses an RFC 5424 syslog line:
+//
+//	<PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+//
+// Structured data parameters are expanded into fields named "SD-ID.PARAM".
+func parseRFC5424(line string) (LogEntry, bool) {
+	pri, rest, ok := parsePRI(line)
+	if !ok {
+		return LogEntry{}, false
+	}
+
+	// VERSION is a non-zero number right after PRI
+	version, rest, ok := cutField(rest)
+	if !ok || version == "" || version[0] < '1' || version[0] > '9' {
+		return LogEntry{}, false
+	}
+	if _, err := strconv.Atoi(version); err != nil {
+		return LogEntry{}, false
+	}
+
+	var header [5]string
+	for i := range header {
+		header[i], rest, ok = cutField(rest)
+		if !ok {
+			return LogEntry{}, false
+		}
+	}
+	timestamp, hostname, appName, procID, msgID := header[0], header[1], header[2], header[3], header[4]
+
+	entry := LogEntry{
+		Hostname: nilValue(hostname),
+		Program:  nilValue(appName),
+		PID:      nilValue(procID),
+		Category: "process",
+		Product:  "linux",
+		Service:  "syslog",
+		Fields:   make(map[string]string),
+	}
+	setPRIFields(entry.Fields, pri)
+	entry.Fields["version"] = version
+	if msgID != "-" {
+		entry.Fields["msgid"] = msgID
+	}
+
+	if timestamp == "-" {
+		entry.setInvalidTime("")
+	} else {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return LogEntry{}, false
+		}
+		entry.setTime(t)
+	}
+
+	// STRUCTURED-DATA is "-" or one or more [SD-ID PARAM="VALUE" ...] elements
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		var err error
+		rest, err = parseStructuredData(rest, entry.Fields)
+		if err != nil {
+			return LogEntry{}, false
+		}
+	}
+
+	if rest != "" && rest[0] != ' ' {
+		return LogEntry{}, false
+	}
+	message := strings.TrimPrefix(rest, " ")
+	entry.Message = strings.TrimPrefix(message, "\ufeff")
+
+	return entry, true
+}
+
+// cutField returns the text up to the next space and the text after it.
+func cutField(s string) (string, string, bool) {
+	field, rest, found := strings.Cut(s, " ")
+	if !found || field == "" {
+		return "", s, false
+	}
+	return field, rest, true
+}
+
+func nilValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// parseStructuredData expands SD elements into fields and returns what
+// follows them.
+func parseStructuredData(s string, fields map[string]string) (string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return s, fmt.Errorf("expected structured data")
+	}
+
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return s, fmt.Errorf("invalid SD-ID")
+		}
+		sdID := s[:end]
+		s = s[end:]
+		if _, ok := fields[sdID]; !ok {
+			fields[sdID] = ""
+		}
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return s, fmt.Errorf("invalid SD-PARAM")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			// PARAM-VALUE escapes '"', '\' and ']' with a backslash
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return s, fmt.Errorf("unterminated SD-PARAM value")
+			}
+
+			fields[sdID+"."+name] = value.String()
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return s, fmt.Errorf("unterminated SD-ELEMENT")
+		}
+		s = s[1:]
+	}
+
+	return s, nil
+}
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Targets are the log types NewParser accepts, in the order ties between
+// their recognizers are broken.
+var Targets = []string{"syslog", "journald", "auditd"}
+
+// Recognizer is implemented by parsers that can tell their format from the
+// start of a log.
+type Recognizer interface {
+	// Recognize scores how well head, the first bytes of a decompressed
+	// log, fits the parser's format, from 0 (not at all) to 1 (every line).
+	Recognize(head []byte) float64
+}
+
+// SniffSize is how much of a log Sniff needs to judge it.
+const SniffSize = 8192
+
+// sniffLines is the most lines of a log recognizers look at.
+const sniffLines = 20
+
+// Sniff scores head, the first SniffSize bytes of a decompressed log, against
+// every parser's recognizer. It returns nil when head holds no line to judge,
+// such as an empty file or one long line.
+func Sniff(head []byte) map[string]float64 {
+	scores := make(map[string]float64)
+	recognized := false
+	for _, target := range Targets {
+		p, err := NewParser(target, "")
+		if err != nil {
+			continue
+		}
+		if recognizer, ok := p.(Recognizer); ok {
+			scores[target] = recognizer.Recognize(head)
+			recognized = recognized || scores[target] > 0
+		}
+	}
+
+	if !recognized && len(headLines(head)) == 0 {
+		return nil
+	}
+	return scores
+}
+
+// headLines returns the first non-empty lines of head, leaving out a last
+// line cut off by the end of head.
+func headLines(head []byte) []string {
+	if len(head) >= SniffSize {
+		if end := bytes.LastIndexByte(head, '\n'); end >= 0 {
+			head = head[:end]
+		} else {
+			return nil
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == sniffLines {
+			break
+		}
+	}
+	return lines
+}
+
+// lineScore is the share of head's lines that are in a format, as told by
+// match.
+func lineScore(head []byte, match func(line string) bool) float64 {
+	lines := headLines(head)
+	if len(lines) == 0 {
+		return 0
+	}
+
+	matched := 0
+	for _, line := range lines {
+		if match(line) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(lines))
+}
+
+// Recognize scores lines in BSD or RFC 5424 syslog format.
+func (p *SyslogParser) Recognize(head []byte) float64 {
+	sniffer := NewSyslogParser("")
+	return lineScore(head, func(line string) bool {
+		_, ok := sniffer.ParseLine(line)
+		return ok
+	})
+}
+
+// Recognize scores journal files and journalctl output as certain, and
+// otherwise lines in journalctl's short-iso format.
+func (p *JournaldParser) Recognize(head []byte) float64 {
+	if JournalFormat(head) != "" {
+		return 1
+	}
+	return lineScore(head, journaldRegex.MatchString)
+}
+
+// Recognize scores audit records, counting ausearch's ---- event separators.
+func (p *AuditdParser) Recognize(head []byte) float64 {
+	return lineScore(head, func(line string) bool {
+		return strings.HasPrefix(line, "----") || auditdRegex.MatchString(line)
+	})
+}
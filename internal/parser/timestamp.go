@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"context"
+	"time"
+)
+
+// Options controls how timestamps that lack a year or a UTC offset are read,
+// and which time window entries must fall in. Entry timestamps are always
+// normalised to UTC.
+type Options struct {
+	// Location applies to timestamps without an offset. Nil means local time.
+	Location *time.Location
+
+	// Year is the year of the first BSD syslog timestamp. Zero infers it
+	// from Reference.
+	Year int
+
+	// Reference is when the log was last written. Zero uses the file's
+	// mtime, or the current time when reading from a stream.
+	Reference time.Time
+
+	// Start and End limit entries to a time window, inclusive. A zero
+	// value leaves that side open. Entries without a valid timestamp are
+	// kept, since they can't be placed.
+	Start time.Time
+	End   time.Time
+
+	// Host is the host the log came from. It names entries whose log
+	// doesn't, such as auditd records without a node= prefix.
+	Host string
+}
+
+func (o Options) location() *time.Location {
+	if o.Location == nil {
+		return time.Local
+	}
+	return o.Location
+}
+
+// InWindow reports whether entry falls within Start and End. Entries without
+// a valid timestamp always do.
+func (o Options) InWindow(entry LogEntry) bool {
+	if entry.InvalidTimestamp {
+		return true
+	}
+	if !o.Start.IsZero() && entry.Time.Before(o.Start) {
+		return false
+	}
+	if !o.End.IsZero() && entry.Time.After(o.End) {
+		return false
+	}
+	return true
+}
+
+// send delivers entry unless it falls outside the time window, so filtered
+// entries never reach rule evaluation. Entries without a hostname get Host.
+func (o Options) send(ctx context.Context, out chan<- LogEntry, entry LogEntry) error {
+	if !o.InWindow(entry) {
+		return nil
+	}
+	if entry.Hostname == "" {
+		entry.Hostname = o.Host
+	}
+	return send(ctx, out, entry)
+}
+
+// timestampLayout is the display format of LogEntry.Timestamp, in UTC.
+const timestampLayout = "2006-01-02T15:04:05.000"
+
+// setTime records t as the entry's time and display timestamp.
+func (e *LogEntry) setTime(t time.Time) {
+	e.Time = t.UTC()
+	e.Timestamp = e.Time.Format(timestampLayout)
+}
+
+// setInvalidTime keeps an unparseable timestamp for display and flags it.
+func (e *LogEntry) setInvalidTime(raw string) {
+	e.Time = time.Time{}
+	e.Timestamp = raw
+	e.InvalidTimestamp = true
+}
+
+// yearSlack allows for clock skew between a host's log timestamps and the
+// file's mtime before a timestamp is taken to be from the previous year.
+const yearSlack = 24 * time.Hour
+
+// yearRollover is how far a timestamp must fall behind the previous one to be
+// taken as the log rolling over into a new year, as from December to January.
+// Smaller steps back are lines written out of order, e.g. by several senders.
+const yearRollover = 183 * 24 * time.Hour
+
+// yearTracker assigns years to BSD syslog timestamps, which have none. The
+// first timestamp is placed at or before the reference time, and the year
+// advances when a timestamp falls more than about six months behind the
+// previous one.
+type yearTracker struct {
+	opts Options
+	year int
+	last time.Time
+}
+
+// resolve places a timestamp parsed without a year into its most likely year
+// and the configured location.
+func (y *yearTracker) resolve(stamp time.Time) time.Time {
+	loc := y.opts.location()
+	inYear := func(year int) time.Time {
+		return time.Date(year, stamp.Month(), stamp.Day(), stamp.Hour(), stamp.Minute(), stamp.Second(), stamp.Nanosecond(), loc)
+	}
+
+	switch {
+	case y.year == 0 && y.opts.Year != 0:
+		y.year = y.opts.Year
+	case y.year == 0:
+		reference := y.opts.Reference
+		if reference.IsZero() {
+			reference = time.Now()
+		}
+
+		// Nothing is logged after the file was last written, so a later
+		// timestamp belongs to the year before
+		y.year = reference.In(loc).Year()
+		if inYear(y.year).After(reference.Add(yearSlack)) {
+			y.year--
+		}
+	case y.last.Sub(inYear(y.year)) > yearRollover:
+		// A large jump backwards means the log rolled over into a new year
+		y.year++
+	}
+	y.last = inYear(y.year)
+
+	return y.last
+}
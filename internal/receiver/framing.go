@@ -0,0 +1,108 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize is the longest message kept whole; longer ones are
+// truncated.
+const DefaultMaxMessageSize = 64 * 1024
+
+// maxLengthDigits bounds the length prefix of an octet-counted frame.
+const maxLengthDigits = 9
+
+// readFrame reads one message from a syslog TCP stream. Senders frame
+// messages either by octet counting, as "<length> <message>", or by ending
+// each with a newline (RFC 6587). A message starts with "<", so a frame that
+// starts with a length followed by one is octet counted; anything else,
+// such as a message without a priority that starts with a timestamp, is
+// read to the newline. Messages longer than max are truncated.
+func readFrame(r *bufio.Reader, max int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' && octetCounted(r) {
+		return readOctetCounted(r, max)
+	}
+	return readLine(r, max)
+}
+
+// octetCounted reports whether the stream continues with an octet count: up
+// to maxLengthDigits digits, a space and the "<" a message starts with. It
+// peeks one byte at a time, so it never waits for more than a newline-framed
+// message holds.
+func octetCounted(r *bufio.Reader) bool {
+	for n := 1; n <= maxLengthDigits; n++ {
+		peeked, err := r.Peek(n + 1)
+		if err != nil {
+			return false
+		}
+		switch c := peeked[n]; {
+		case c == ' ':
+			next, err := r.Peek(n + 2)
+			return err == nil && next[n+1] == '<'
+		case c < '0' || c > '9':
+			return false
+		}
+	}
+	return false
+}
+
+func readOctetCounted(r *bufio.Reader, max int) ([]byte, error) {
+	length := 0
+	for digits := 0; ; digits++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if c == ' ' {
+			break
+		}
+		if c < '0' || c > '9' || digits == maxLengthDigits {
+			return nil, fmt.Errorf("invalid octet count in syslog frame")
+		}
+		length = length*10 + int(c-'0')
+	}
+
+	message := make([]byte, min(length, max))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := r.Discard(length - len(message)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return message, nil
+}
+
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if keep := min(len(chunk), max-len(line)); keep > 0 {
+			line = append(line, chunk[:keep]...)
+		}
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			// Longer than the buffer: keep reading to the newline
+			continue
+		case err == io.EOF && len(line) > 0:
+			// The last message may lack its newline
+		case err != nil:
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
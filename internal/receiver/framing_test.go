@@ -0,0 +1,102 @@
+package receiver
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		max     int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "newline framed",
+			stream: "<34>Oct 11 22:14:15 host su: one\n<34>Oct 11 22:14:16 host su: two\r\n",
+			max:    1024,
+			want:   []string{"<34>Oct 11 22:14:15 host su: one", "<34>Oct 11 22:14:16 host su: two"},
+		},
+		{
+			name:   "last message without newline",
+			stream: "<34>one\n<34>two",
+			max:    1024,
+			want:   []string{"<34>one", "<34>two"},
+		},
+		{
+			name:   "octet counted",
+			stream: "11 <34>one\ntwo9 <34>three",
+			max:    1024,
+			want:   []string{"<34>one\ntwo", "<34>three"},
+		},
+		{
+			name:   "mixed framing",
+			stream: "7 <34>one<34>two\n",
+			max:    1024,
+			want:   []string{"<34>one", "<34>two"},
+		},
+		{
+			name:   "long octet counted message truncated",
+			stream: "10 <34>123456<34>next\n",
+			max:    6,
+			want:   []string{"<34>12", "<34>ne"},
+		},
+		{
+			name:   "long line truncated",
+			stream: strings.Repeat("x", 5000) + "\n<34>next\n",
+			max:    4,
+			want:   []string{"xxxx", "<34>"},
+		},
+		{
+			name:   "newline framed messages starting with digits",
+			stream: "2024-01-01T10:30:00Z host su: one\n12x <34>two\n1\n",
+			max:    1024,
+			want:   []string{"2024-01-01T10:30:00Z host su: one", "12x <34>two", "1"},
+		},
+		{
+			name:   "newline framed message starting with a number and a space",
+			stream: "200 OK\n<34>next\n",
+			max:    1024,
+			want:   []string{"200 OK", "<34>next"},
+		},
+		{
+			name:    "octet count cut short",
+			stream:  "20 <34>short",
+			max:     1024,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A small buffer exercises lines longer than it
+			reader := bufio.NewReaderSize(strings.NewReader(tt.stream), 16)
+
+			var got []string
+			for {
+				frame, err := readFrame(reader, tt.max)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if !tt.wantErr {
+						t.Fatalf("readFrame() error = %v", err)
+					}
+					return
+				}
+				got = append(got, string(frame))
+			}
+
+			if tt.wantErr {
+				t.Fatalf("readFrame() read %q, expected an error", got)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("readFrame() = %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
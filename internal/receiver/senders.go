@@ -0,0 +1,227 @@
+package receiver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+// RateLimit bounds how many messages each sender may send. Messages over the
+// limit are dropped and counted in the sender's stats.
+type RateLimit struct {
+	// PerSecond is the sustained rate allowed. Zero is unlimited.
+	PerSecond float64
+	// Burst is how many messages may arrive at once. It is at least one
+	// second's worth.
+	Burst int
+}
+
+// burst is the capacity of a sender's token bucket.
+func (l RateLimit) burst() float64 {
+	return max(float64(l.Burst), l.PerSecond, 1)
+}
+
+// tokenBucket holds a sender's allowance: a token per message, refilled at
+// the limit's rate up to its burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(limit RateLimit, now time.Time) bool {
+	if limit.PerSecond <= 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = limit.burst()
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond, limit.burst())
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SenderStats counts what was received from one sender, identified by its IP
+// address.
+type SenderStats struct {
+	Sender string
+	// Transports are those the sender used: udp, tcp or tls.
+	Transports []string
+	// Received counts every message, Bytes their size.
+	Received int64
+	Bytes    int64
+	// Dropped counts messages over the rate limit.
+	Dropped int64
+	// Unparsed counts messages that weren't syslog.
+	Unparsed int64
+	// Matches counts messages that matched a rule.
+	Matches   int64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+type senderState struct {
+	stats      SenderStats
+	transports map[string]bool
+	bucket     tokenBucket
+	parser     *parser.SyslogParser
+}
+
+// DefaultSenderExpiry is how long a sender may stay silent before it is
+// forgotten, with its stats, so senders that come and go don't accumulate.
+const DefaultSenderExpiry = time.Hour
+
+// senders keeps the rate limit and stats of every sender. It is safe for
+// concurrent use.
+type senders struct {
+	limit  RateLimit
+	expiry time.Duration
+
+	mu    sync.Mutex
+	all   map[string]*senderState
+	swept time.Time
+}
+
+func newSenders(limit RateLimit) *senders {
+	return &senders{limit: limit, expiry: DefaultSenderExpiry, all: make(map[string]*senderState)}
+}
+
+// sweep forgets senders not heard from within the expiry, checking at most
+// every tenth of it. The caller holds mu.
+func (s *senders) sweep(now time.Time) {
+	if now.Sub(s.swept) < s.expiry/10 {
+		return
+	}
+	s.swept = now
+	for addr, sender := range s.all {
+		if now.Sub(sender.stats.LastSeen) > s.expiry {
+			delete(s.all, addr)
+		}
+	}
+}
+
+// get returns the sender named addr. The caller holds mu.
+func (s *senders) get(addr string) *senderState {
+	sender, ok := s.all[addr]
+	if !ok {
+		sender = &senderState{stats: SenderStats{Sender: addr}, transports: make(map[string]bool)}
+		s.all[addr] = sender
+	}
+	return sender
+}
+
+// receive counts a message of size bytes from addr over transport and
+// reports whether it is within the sender's rate limit.
+func (s *senders) receive(addr, transport string, size int) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	sender := s.get(addr)
+	sender.transports[transport] = true
+	sender.stats.Received++
+	sender.stats.Bytes += int64(size)
+	if sender.stats.FirstSeen.IsZero() {
+		sender.stats.FirstSeen = now
+	}
+	sender.stats.LastSeen = now
+
+	if !sender.bucket.allow(s.limit, now) {
+		sender.stats.Dropped++
+		return false
+	}
+	return true
+}
+
+// parser returns the sender's syslog parser, which tracks the year of its
+// BSD timestamps, creating it with opts. Only one goroutine may parse.
+func (s *senders) parser(addr string, opts parser.Options) *parser.SyslogParser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sender, ok := s.all[addr]
+	if ok && sender.parser != nil {
+		return sender.parser
+	}
+	syslogParser := parser.NewSyslogParser("")
+	syslogParser.SetOptions(opts)
+	if ok {
+		sender.parser = syslogParser
+	}
+	return syslogParser
+}
+
+// unparsed and matched count for a sender that hasn't since been forgotten.
+func (s *senders) unparsed(addr string) {
+	s.mu.Lock()
+	if sender, ok := s.all[addr]; ok {
+		sender.stats.Unparsed++
+	}
+	s.mu.Unlock()
+}
+
+func (s *senders) matched(addr string) {
+	s.mu.Lock()
+	if sender, ok := s.all[addr]; ok {
+		sender.stats.Matches++
+	}
+	s.mu.Unlock()
+}
+
+// stats returns a copy of every sender's stats, ordered by sender.
+func (s *senders) stats() []SenderStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]SenderStats, 0, len(s.all))
+	for _, sender := range s.all {
+		entry := sender.stats
+		for transport := range sender.transports {
+			entry.Transports = append(entry.Transports, transport)
+		}
+		sort.Strings(entry.Transports)
+		stats = append(stats, entry)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Sender < stats[j].Sender })
+	return stats
+}
+
+// WriteStats writes the per-sender stats to w.
+func WriteStats(w io.Writer, stats []SenderStats, uptime time.Duration) {
+	var received, dropped, matches int64
+	for _, sender := range stats {
+		received += sender.Received
+		dropped += sender.Dropped
+		matches += sender.Matches
+	}
+
+	fmt.Fprintln(w, "📡 Syslog Receiver Stats")
+	fmt.Fprintln(w, "========================")
+	fmt.Fprintf(w, "Uptime: %v\n", uptime.Round(time.Second))
+	fmt.Fprintf(w, "Senders: %d\n", len(stats))
+	fmt.Fprintf(w, "Messages Received: %d\n", received)
+	fmt.Fprintf(w, "Messages Dropped: %d\n", dropped)
+	fmt.Fprintf(w, "Total Matches: %d\n", matches)
+
+	if len(stats) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "📋 Messages by Sender:")
+		for _, sender := range stats {
+			fmt.Fprintf(w, "  %s (%s): %d received (%d bytes), %d dropped, %d unparsed, %d matches, last seen %s\n",
+				sender.Sender, strings.Join(sender.Transports, ", "), sender.Received, sender.Bytes,
+				sender.Dropped, sender.Unparsed, sender.Matches, sender.LastSeen.UTC().Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package receiver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/output"
+	"github.com/wellknittech/hayanix/internal/parser"
+	"github.com/wellknittech/hayanix/internal/rules"
+)
+
+// Server receives syslog messages from the network over UDP, TCP and TLS,
+// evaluates rules against them as they arrive and writes detections as they
+// are found.
+type Server struct {
+	rulesDir    string
+	ruleEngine  *rules.Engine
+	outputter   *output.Outputter
+	verbose     bool
+	strict      bool
+	parse       parser.Options
+	version     string
+	maxMessage  int
+	maxConns    int
+	idle        time.Duration
+	handshake   time.Duration
+	senders     *senders
+	stats       io.Writer
+	statsPeriod time.Duration
+
+	packetConns []net.PacketConn
+	listeners   []listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// listener accepts connections carrying syslog over a stream transport.
+type listener struct {
+	net.Listener
+	transport string
+}
+
+// DefaultMaxConnections is how many TCP and TLS connections are served at
+// once; more are refused until some close.
+const DefaultMaxConnections = 1024
+
+// DefaultIdleTimeout is how long a connection may take to send a message
+// before it is closed.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// DefaultHandshakeTimeout is how long a TLS handshake may take.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// errClosing is returned by track once the server is closing.
+var errClosing = errors.New("server is closing")
+
+// message is a syslog message as received from sender.
+type message struct {
+	sender    string
+	transport string
+	data      []byte
+}
+
+func NewServer(rulesDir string, outputFormat string, verbose bool) (*Server, error) {
+	// Load rules
+	ruleEngine, err := rules.NewEngine(rulesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	return &Server{
+		rulesDir:   rulesDir,
+		ruleEngine: ruleEngine,
+		outputter:  output.NewOutputter(outputFormat),
+		verbose:    verbose,
+		maxMessage: DefaultMaxMessageSize,
+		maxConns:   DefaultMaxConnections,
+		idle:       DefaultIdleTimeout,
+		handshake:  DefaultHandshakeTimeout,
+		senders:    newSenders(RateLimit{}),
+		conns:      make(map[net.Conn]bool),
+	}, nil
+}
+
+// SetToolVersion records the version of hayanix in the output metadata.
+func (s *Server) SetToolVersion(version string) {
+	s.version = version
+}
+
+// SetStrictVerification refuses to serve with rules from unverified sources.
+func (s *Server) SetStrictVerification(strict bool) {
+	s.strict = strict
+}
+
+// SetParserOptions sets the timezone and year used for timestamps that lack
+// them.
+func (s *Server) SetParserOptions(opts parser.Options) {
+	s.parse = opts
+}
+
+// SetRateLimit limits how many messages each sender may send.
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.senders = newSenders(limit)
+}
+
+// SetMaxMessageSize truncates messages longer than size bytes.
+func (s *Server) SetMaxMessageSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxMessageSize
+	}
+	s.maxMessage = size
+}
+
+// SetMaxConnections limits how many TCP and TLS connections are served at
+// once.
+func (s *Server) SetMaxConnections(n int) {
+	if n <= 0 {
+		n = DefaultMaxConnections
+	}
+	s.maxConns = n
+}
+
+// SetTimeouts closes connections that take longer than idle to send each
+// message, or longer than handshake to complete a TLS handshake.
+func (s *Server) SetTimeouts(idle, handshake time.Duration) {
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	if handshake <= 0 {
+		handshake = DefaultHandshakeTimeout
+	}
+	s.idle = idle
+	s.handshake = handshake
+}
+
+// SetStats writes the per-sender stats to w every period, if it is
+// positive, and when the server stops.
+func (s *Server) SetStats(w io.Writer, period time.Duration) {
+	s.stats = w
+	s.statsPeriod = period
+}
+
+// ListenUDP receives syslog datagrams on addr, one message each (RFC 5426),
+// and returns the address bound.
+func (s *Server) ListenUDP(addr string) (net.Addr, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on UDP %s: %w", addr, err)
+	}
+	s.packetConns = append(s.packetConns, conn)
+	return conn.LocalAddr(), nil
+}
+
+// ListenTCP accepts syslog connections on addr (RFC 6587) and returns the
+// address bound.
+func (s *Server) ListenTCP(addr string) (net.Addr, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on TCP %s: %w", addr, err)
+	}
+	s.listeners = append(s.listeners, listener{Listener: l, transport: "tcp"})
+	return l.Addr(), nil
+}
+
+// ListenTLS accepts syslog connections over TLS on addr (RFC 5425) and
+// returns the address bound.
+func (s *Server) ListenTLS(addr string, config *tls.Config) (net.Addr, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on TLS %s: %w", addr, err)
+	}
+	s.listeners = append(s.listeners, listener{Listener: tls.NewListener(l, config), transport: "tls"})
+	return l.Addr(), nil
+}
+
+// Stats returns what has been received from each sender so far.
+func (s *Server) Stats() []SenderStats {
+	return s.senders.stats()
+}
+
+// Serve receives messages until ctx is cancelled, then stops listening and
+// evaluates and writes what was already received before it returns.
+func (s *Server) Serve(ctx context.Context) error {
+	if len(s.packetConns) == 0 && len(s.listeners) == 0 {
+		return fmt.Errorf("no listeners: listen on UDP, TCP or TLS first")
+	}
+	started := time.Now()
+
+	// Record how the installed rule sources were verified
+	verifications, err := rules.CheckSources(s.rulesDir, s.strict)
+	if err != nil {
+		s.close()
+		return fmt.Errorf("failed to check rule sources: %w", err)
+	}
+	s.outputter.SetMetadata(&output.Metadata{
+		RuleSources: verifications,
+		ToolVersion: s.version,
+		RuleSetHash: s.ruleEngine.Hash(),
+	})
+	if err := s.outputter.Begin(); err != nil {
+		s.close()
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	// Receive on every listener until ctx is cancelled
+	messages := make(chan message, 1024)
+	var receivers sync.WaitGroup
+	for _, conn := range s.packetConns {
+		receivers.Add(1)
+		go func(conn net.PacketConn) {
+			defer receivers.Done()
+			s.receivePackets(conn, messages)
+		}(conn)
+	}
+	for _, l := range s.listeners {
+		receivers.Add(1)
+		go func(l listener) {
+			defer receivers.Done()
+			s.accept(l, messages, &receivers)
+		}(l)
+	}
+	go func() {
+		<-ctx.Done()
+		s.close()
+	}()
+	go func() {
+		receivers.Wait()
+		close(messages)
+	}()
+
+	if s.stats != nil && s.statsPeriod > 0 {
+		ticker := time.NewTicker(s.statsPeriod)
+		defer ticker.Stop()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					WriteStats(s.stats, s.Stats(), time.Since(started))
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	if s.verbose {
+		log.Printf("Receiving syslog with %d rules", len(s.ruleEngine.Rules()))
+	}
+
+	// Parse the messages until they run out, so nothing received is lost
+	entries := make(chan parser.LogEntry, 256)
+	go func() {
+		defer close(entries)
+		s.parseMessages(messages, entries)
+	}()
+
+	var writeErr error
+	processed := s.ruleEngine.EvaluateEach(entries, func(entry parser.LogEntry) {
+		s.senders.matched(entry.Fields["sender"])
+		if writeErr == nil {
+			writeErr = s.outputter.WriteEntry(entry)
+		}
+	})
+
+	if s.verbose {
+		log.Printf("Stopped receiving after %d messages", processed)
+	}
+	if s.stats != nil {
+		WriteStats(s.stats, s.Stats(), time.Since(started))
+	}
+
+	if writeErr != nil {
+		return fmt.Errorf("failed to write output: %w", writeErr)
+	}
+	return s.outputter.End()
+}
+
+// close stops every listener and closes open connections, which ends their
+// receivers.
+func (s *Server) close() {
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
+	for _, l := range s.listeners {
+		l.Close()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// track records an open connection so close can end it. It fails if the
+// server is already closing or serving as many connections as it may.
+func (s *Server) track(conn net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		return errClosing
+	}
+	if len(s.conns) >= s.maxConns {
+		return fmt.Errorf("too many connections: %d open", len(s.conns))
+	}
+	s.conns[conn] = true
+	return nil
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// receivePackets reads datagrams until conn is closed.
+func (s *Server) receivePackets(conn net.PacketConn, messages chan<- message) {
+	buf := make([]byte, max(s.maxMessage, 64*1024))
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if s.verbose {
+				log.Printf("Failed to receive UDP datagram: %v", err)
+			}
+			continue
+		}
+
+		data := make([]byte, min(n, s.maxMessage))
+		copy(data, buf)
+		s.deliver(senderAddr(addr), "udp", data, messages)
+	}
+}
+
+// accept handles connections on l until it is closed, adding a receiver to
+// receivers for each.
+func (s *Server) accept(l listener, messages chan<- message, receivers *sync.WaitGroup) {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if s.verbose {
+				log.Printf("Failed to accept %s connection: %v", l.transport, err)
+			}
+			continue
+		}
+		if err := s.track(conn); err != nil {
+			conn.Close()
+			if err == errClosing {
+				return
+			}
+			if s.verbose {
+				log.Printf("Refusing %s connection from %s: %v", l.transport, conn.RemoteAddr(), err)
+			}
+			continue
+		}
+
+		receivers.Add(1)
+		go func() {
+			defer receivers.Done()
+			defer s.untrack(conn)
+			defer conn.Close()
+			s.receiveStream(conn, l.transport, messages)
+		}()
+	}
+}
+
+// receiveStream reads framed messages from a connection until it closes, or
+// until it fails to send a message within the idle timeout.
+func (s *Server) receiveStream(conn net.Conn, transport string, messages chan<- message) {
+	sender := senderAddr(conn.RemoteAddr())
+	if s.verbose {
+		log.Printf("Accepted %s connection from %s", transport, conn.RemoteAddr())
+	}
+
+	// Complete the TLS handshake up front, so it has its own deadline
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.handshake))
+		if err := tlsConn.Handshake(); err != nil {
+			if s.verbose {
+				log.Printf("Closing %s connection from %s: %v", transport, conn.RemoteAddr(), err)
+			}
+			return
+		}
+		conn.SetDeadline(time.Time{})
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.idle))
+		data, err := readFrame(reader, s.maxMessage)
+		if err != nil {
+			if s.verbose && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Closing %s connection from %s: %v", transport, conn.RemoteAddr(), err)
+			}
+			return
+		}
+		s.deliver(sender, transport, data, messages)
+	}
+}
+
+// deliver passes a message on for parsing if it is within its sender's rate
+// limit.
+func (s *Server) deliver(sender, transport string, data []byte, messages chan<- message) {
+	if !s.senders.receive(sender, transport, len(data)) {
+		return
+	}
+	messages <- message{sender: sender, transport: transport, data: data}
+}
+
+// parseMessages parses each message as syslog, with a parser per sender so
+// the year of BSD timestamps is tracked for each separately. Entries are
+// named after the sender if they don't name their host, and record the
+// sender and transport in their fields.
+func (s *Server) parseMessages(messages <-chan message, entries chan<- parser.LogEntry) {
+	for msg := range messages {
+		line := strings.TrimRight(string(msg.data), "\r\n\x00")
+		if line == "" {
+			continue
+		}
+
+		entry, ok := s.senders.parser(msg.sender, s.parse).ParseLine(line)
+		if !ok {
+			s.senders.unparsed(msg.sender)
+			if s.verbose {
+				log.Printf("Ignoring message from %s that isn't syslog: %.80q", msg.sender, line)
+			}
+			continue
+		}
+
+		if entry.Hostname == "" {
+			entry.Hostname = msg.sender
+		}
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]string)
+		}
+		entry.Fields["sender"] = msg.sender
+		entry.Fields["transport"] = msg.transport
+		entries <- entry
+	}
+}
+
+// senderAddr identifies a sender by its IP address, since its port changes
+// from connection to connection.
+func senderAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
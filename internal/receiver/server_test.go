@@ -0,0 +1,405 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+const testRule = `title: Failed Login
+id: failed-login
+logsource:
+    product: linux
+    service: syslog
+detection:
+    keywords:
+        - 'failed password'
+    condition: keywords`
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	rulesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rulesDir, "failed.yml"), []byte(testRule), 0644); err != nil {
+		t.Fatalf("Failed to write rule: %v", err)
+	}
+	server, err := NewServer(rulesDir, "json", false)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	return server
+}
+
+// serve runs the server until received messages have arrived, then stops it
+// and returns the entries it wrote.
+func serve(t *testing.T, server *Server, received int64, send func()) []parser.LogEntry {
+	t.Helper()
+
+	// Capture stdout
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	captured := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		captured <- data
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(ctx)
+	}()
+
+	send()
+
+	// Wait for everything sent to arrive
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var total int64
+		for _, sender := range server.Stats() {
+			total += sender.Received
+		}
+		if total >= received {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	err := <-done
+	w.Close()
+	os.Stdout = oldStdout
+	output := <-captured
+	if err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	var entries []parser.LogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" || strings.HasPrefix(line, `{"metadata"`) {
+			continue
+		}
+		var entry parser.LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode output line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// selfSignedCert makes a certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestServer_Serve(t *testing.T) {
+	server := newTestServer(t)
+	var stats bytes.Buffer
+	server.SetStats(&stats, 0)
+
+	udpAddr, err := server.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	tcpAddr, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+	cert, pool := selfSignedCert(t)
+	tlsAddr, err := server.ListenTLS("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("ListenTLS() error = %v", err)
+	}
+
+	entries := serve(t, server, 6, func() {
+		udp, err := net.Dial("udp", udpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial UDP: %v", err)
+		}
+		defer udp.Close()
+		fmt.Fprint(udp, "<38>Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n")
+		fmt.Fprint(udp, "not syslog")
+
+		tcp, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer tcp.Close()
+		rfc5424 := "<38>1 2024-01-01T10:30:01Z - sshd 2 - - Failed password for admin"
+		fmt.Fprintf(tcp, "%d %s", len(rfc5424), rfc5424)
+		fmt.Fprint(tcp, "<38>Jan  1 10:30:02 web02 sshd[3]: Accepted password for alice\n")
+
+		conn, err := tls.Dial("tcp", tlsAddr.String(), &tls.Config{RootCAs: pool})
+		if err != nil {
+			t.Fatalf("Failed to dial TLS: %v", err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "<38>Jan  1 10:30:03 web03 sshd[4]: Failed password for guest\n")
+		fmt.Fprint(conn, "<38>Jan  1 10:30:04 web03 sshd[4]: Accepted password for bob\n")
+	})
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%s %s %s", entry.Hostname, entry.Fields["transport"], strings.Join(entry.MatchedRules, ",")))
+	}
+	sort.Strings(got)
+	want := []string{"127.0.0.1 tcp failed-login", "web01 udp failed-login", "web03 tls failed-login"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Detections = %q, expected %q", got, want)
+	}
+
+	senders := server.Stats()
+	if len(senders) != 1 {
+		t.Fatalf("Expected 1 sender, got %+v", senders)
+	}
+	sender := senders[0]
+	if sender.Sender != "127.0.0.1" || strings.Join(sender.Transports, ",") != "tcp,tls,udp" {
+		t.Errorf("Unexpected sender %s over %v", sender.Sender, sender.Transports)
+	}
+	if sender.Received != 6 || sender.Dropped != 0 || sender.Unparsed != 1 || sender.Matches != 3 {
+		t.Errorf("Stats = %+v; expected 6 received, 1 unparsed and 3 matches", sender)
+	}
+	if !strings.Contains(stats.String(), "127.0.0.1 (tcp, tls, udp): 6 received") {
+		t.Errorf("Expected per-sender stats, got:\n%s", stats.String())
+	}
+}
+
+func TestServer_NoHostname(t *testing.T) {
+	server := newTestServer(t)
+	udpAddr, err := server.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+
+	// BSD messages without a hostname are named after the sender
+	entries := serve(t, server, 2, func() {
+		udp, err := net.Dial("udp", udpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial UDP: %v", err)
+		}
+		defer udp.Close()
+		fmt.Fprint(udp, "<38>Jan  1 10:30:00 sshd[1]: Failed password for root\n")
+		fmt.Fprint(udp, "<38>Jan  1 10:30:01 web01 sshd[2]: Failed password for admin: invalid user\n")
+	})
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%s %s %s", entry.Hostname, entry.Program, entry.PID))
+	}
+	sort.Strings(got)
+	want := []string{"127.0.0.1 sshd 1", "web01 sshd 2"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Entries = %q, expected %q", got, want)
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	server := newTestServer(t)
+	server.SetRateLimit(RateLimit{PerSecond: 0.001, Burst: 2})
+	tcpAddr, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+
+	entries := serve(t, server, 5, func() {
+		conn, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer conn.Close()
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(conn, "<38>Jan  1 10:30:0%d web01 sshd[1]: Failed password for user%d\n", i, i)
+		}
+	})
+
+	if len(entries) != 2 {
+		t.Errorf("Expected the first 2 messages to be evaluated, got %d", len(entries))
+	}
+	if stats := server.Stats(); len(stats) != 1 || stats[0].Received != 5 || stats[0].Dropped != 3 {
+		t.Errorf("Stats = %+v; expected 5 received and 3 dropped", stats)
+	}
+}
+
+func TestServer_NoListeners(t *testing.T) {
+	if err := newTestServer(t).Serve(context.Background()); err == nil {
+		t.Error("Serve() without listeners should fail")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{PerSecond: 2, Burst: 3}
+	var bucket tokenBucket
+	now := time.Now()
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if bucket.allow(limit, now) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Allowed %d messages at once, expected the burst of 3", allowed)
+	}
+
+	// Refilled at 2 per second
+	if !bucket.allow(limit, now.Add(500*time.Millisecond)) {
+		t.Error("Expected a message to be allowed after half a second")
+	}
+	if bucket.allow(limit, now.Add(500*time.Millisecond)) {
+		t.Error("Expected a second message within half a second to be dropped")
+	}
+
+	var unlimited tokenBucket
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow(RateLimit{}, now) {
+			t.Fatal("Expected no limit without a rate")
+		}
+	}
+}
+
+// expectClosed fails unless the server closes conn within a few seconds.
+func expectClosed(t *testing.T, conn net.Conn, what string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the server to close %s, got %v", what, err)
+	}
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	server := newTestServer(t)
+	server.SetTimeouts(100*time.Millisecond, 100*time.Millisecond)
+	tcpAddr, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+	cert, _ := selfSignedCert(t)
+	tlsAddr, err := server.ListenTLS("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("ListenTLS() error = %v", err)
+	}
+
+	serve(t, server, 0, func() {
+		idle, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer idle.Close()
+
+		// A message trickled in too slowly is cut off too
+		slow, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer slow.Close()
+		fmt.Fprint(slow, "<38>Jan  1 10:30:00 web01 sshd[1]: ")
+
+		// A client that never starts the TLS handshake
+		handshake, err := net.Dial("tcp", tlsAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TLS: %v", err)
+		}
+		defer handshake.Close()
+
+		expectClosed(t, idle, "an idle connection")
+		expectClosed(t, slow, "a connection sending too slowly")
+		expectClosed(t, handshake, "a connection without a TLS handshake")
+	})
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	server := newTestServer(t)
+	server.SetMaxConnections(1)
+	tcpAddr, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+
+	entries := serve(t, server, 2, func() {
+		first, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer first.Close()
+		fmt.Fprint(first, "<38>Jan  1 10:30:00 web01 sshd[1]: Failed password for root\n")
+
+		refused, err := net.Dial("tcp", tcpAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial TCP: %v", err)
+		}
+		defer refused.Close()
+		expectClosed(t, refused, "a connection over the limit")
+
+		fmt.Fprint(first, "<38>Jan  1 10:30:01 web01 sshd[1]: Failed password for admin\n")
+	})
+
+	if len(entries) != 2 {
+		t.Errorf("Expected both messages on the first connection to be evaluated, got %d", len(entries))
+	}
+}
+
+func TestSenders_Expiry(t *testing.T) {
+	senders := newSenders(RateLimit{})
+	senders.receive("10.0.0.1", "udp", 10)
+	first := senders.parser("10.0.0.1", parser.Options{})
+	if senders.parser("10.0.0.1", parser.Options{}) != first {
+		t.Error("Expected a sender to keep its parser")
+	}
+
+	// Silent for longer than the expiry, the sender is forgotten when
+	// another is heard from
+	senders.all["10.0.0.1"].stats.LastSeen = time.Now().Add(-2 * DefaultSenderExpiry)
+	senders.swept = time.Time{}
+	senders.receive("10.0.0.2", "udp", 10)
+	senders.matched("10.0.0.1")
+
+	stats := senders.stats()
+	if len(stats) != 1 || stats[0].Sender != "10.0.0.2" {
+		t.Errorf("Stats = %+v; expected only the sender still heard from", stats)
+	}
+	if senders.parser("10.0.0.1", parser.Options{}) == first {
+		t.Error("Expected a forgotten sender's parser to be dropped")
+	}
+}
@@ -0,0 +1,376 @@
+package rules
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// conditionNode is a compiled Sigma detection condition.
+type conditionNode interface {
+	// evaluate reports whether the condition holds, given a function that
+	// evaluates a single search identifier.
+	evaluate(search func(name string) bool) bool
+	// outcomes reports whether the condition can come out true and whether
+	// it can come out false, given which searches are able to match at all.
+	outcomes(canMatch func(name string) bool) (canBeTrue, canBeFalse bool)
+	String() string
+}
+
+type identifierNode struct {
+	name string
+}
+
+func (n identifierNode) evaluate(search func(string) bool) bool {
+	return search(n.name)
+}
+
+func (n identifierNode) outcomes(canMatch func(string) bool) (bool, bool) {
+	return canMatch(n.name), true
+}
+
+func (n identifierNode) String() string {
+	return n.name
+}
+
+type notNode struct {
+	operand conditionNode
+}
+
+func (n notNode) evaluate(search func(string) bool) bool {
+	return !n.operand.evaluate(search)
+}
+
+func (n notNode) outcomes(canMatch func(string) bool) (bool, bool) {
+	canBeTrue, canBeFalse := n.operand.outcomes(canMatch)
+	return canBeFalse, canBeTrue
+}
+
+func (n notNode) String() string {
+	return "not " + n.operand.String()
+}
+
+type andNode struct {
+	operands []conditionNode
+}
+
+func (n andNode) evaluate(search func(string) bool) bool {
+	for _, operand := range n.operands {
+		if !operand.evaluate(search) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n andNode) outcomes(canMatch func(string) bool) (bool, bool) {
+	canBeTrue, canBeFalse := true, false
+	for _, operand := range n.operands {
+		operandTrue, operandFalse := operand.outcomes(canMatch)
+		canBeTrue = canBeTrue && operandTrue
+		canBeFalse = canBeFalse || operandFalse
+	}
+	return canBeTrue, canBeFalse
+}
+
+func (n andNode) String() string {
+	return joinNodes(n.operands, " and ")
+}
+
+type orNode struct {
+	operands []conditionNode
+}
+
+func (n orNode) evaluate(search func(string) bool) bool {
+	for _, operand := range n.operands {
+		if operand.evaluate(search) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n orNode) outcomes(canMatch func(string) bool) (bool, bool) {
+	canBeTrue, canBeFalse := false, true
+	for _, operand := range n.operands {
+		operandTrue, operandFalse := operand.outcomes(canMatch)
+		canBeTrue = canBeTrue || operandTrue
+		canBeFalse = canBeFalse && operandFalse
+	}
+	return canBeTrue, canBeFalse
+}
+
+func (n orNode) String() string {
+	return joinNodes(n.operands, " or ")
+}
+
+func joinNodes(operands []conditionNode, separator string) string {
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = operand.String()
+	}
+	return "(" + strings.Join(parts, separator) + ")"
+}
+
+// compileCondition parses a Sigma condition against the search identifiers
+// defined in the rule's detection section. "1 of" and "all of" are expanded
+// to the identifiers they match, so the compiled form shows exactly which
+// searches take part.
+func compileCondition(condition string, identifiers []string) (conditionNode, error) {
+	if strings.Contains(condition, "|") {
+		return nil, fmt.Errorf("aggregation conditions are not supported: %q", condition)
+	}
+
+	p := &conditionParser{
+		tokens:      tokenizeCondition(condition),
+		identifiers: identifiers,
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %w", condition, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid condition %q: unexpected %q", condition, p.tokens[p.pos])
+	}
+
+	return node, nil
+}
+
+func tokenizeCondition(condition string) []string {
+	var tokens []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range condition {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type conditionParser struct {
+	tokens      []string
+	pos         int
+	identifiers []string
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	operands, err := p.parseOperands("or", p.parseAnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return orNode{operands: operands}, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	operands, err := p.parseOperands("and", p.parseNot)
+	if err != nil {
+		return nil, err
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return andNode{operands: operands}, nil
+}
+
+func (p *conditionParser) parseOperands(operator string, parse func() (conditionNode, error)) ([]conditionNode, error) {
+	first, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []conditionNode{first}
+	for strings.EqualFold(p.peek(), operator) {
+		p.next()
+		operand, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	return operands, nil
+}
+
+func (p *conditionParser) parseNot() (conditionNode, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	token := p.next()
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of condition")
+
+	case token == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+
+	case token == ")":
+		return nil, fmt.Errorf("unexpected closing parenthesis")
+
+	case (token == "1" || strings.EqualFold(token, "all")) && strings.EqualFold(p.peek(), "of"):
+		p.next()
+		return p.parseQuantifier(token == "1", p.next())
+
+	case strings.EqualFold(token, "and") || strings.EqualFold(token, "or"):
+		return nil, fmt.Errorf("unexpected %q", token)
+
+	default:
+		if !p.isIdentifier(token) {
+			return nil, fmt.Errorf("unknown search identifier %q", token)
+		}
+		return identifierNode{name: token}, nil
+	}
+}
+
+// parseQuantifier expands "1 of pattern" and "all of pattern" ("them" matches
+// every identifier) into an or/and over the matching identifiers.
+func (p *conditionParser) parseQuantifier(anyOf bool, pattern string) (conditionNode, error) {
+	if pattern == "" || pattern == "(" || pattern == ")" {
+		return nil, fmt.Errorf("missing pattern after 'of'")
+	}
+
+	var operands []conditionNode
+	for _, identifier := range p.identifiers {
+		matched := strings.EqualFold(pattern, "them") && !strings.HasPrefix(identifier, "_")
+		if !matched {
+			matched, _ = path.Match(pattern, identifier)
+		}
+		if matched {
+			operands = append(operands, identifierNode{name: identifier})
+		}
+	}
+
+	if len(operands) == 0 {
+		return nil, fmt.Errorf("no search identifiers match %q", pattern)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	if anyOf {
+		return orNode{operands: operands}, nil
+	}
+	return andNode{operands: operands}, nil
+}
+
+func (p *conditionParser) isIdentifier(name string) bool {
+	for _, identifier := range p.identifiers {
+		if identifier == name {
+			return true
+		}
+	}
+	return false
+}
+
+// detectionIdentifiers returns the search identifiers of a detection section
+// in sorted order.
+func detectionIdentifiers(detection map[string]interface{}) []string {
+	var identifiers []string
+	for key := range detection {
+		if key != "condition" && key != "timeframe" {
+			identifiers = append(identifiers, key)
+		}
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+// conditionString returns a detection's condition as a single expression.
+// The old list form is treated as any of its conditions.
+func conditionString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			condition, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("condition list must contain strings")
+			}
+			parts = append(parts, "("+condition+")")
+		}
+		return strings.Join(parts, " or "), nil
+	default:
+		return "", fmt.Errorf("condition must be a string")
+	}
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{} so detections can be walked
+// (and encoded as JSON) uniformly.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[fmt.Sprintf("%v", key)] = normalizeYAML(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeYAML(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
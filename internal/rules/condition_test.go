@@ -0,0 +1,98 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+func TestCompileCondition(t *testing.T) {
+	identifiers := []string{"filter", "selection_curl", "selection_wget", "keywords"}
+
+	tests := []struct {
+		condition string
+		want      string
+		wantErr   bool
+	}{
+		{condition: "keywords", want: "keywords"},
+		{condition: "selection_curl and not filter", want: "(selection_curl and not filter)"},
+		{condition: "1 of selection_* and not filter", want: "((selection_curl or selection_wget) and not filter)"},
+		{condition: "all of selection_*", want: "(selection_curl and selection_wget)"},
+		{condition: "1 of them", want: "(filter or selection_curl or selection_wget or keywords)"},
+		{condition: "(keywords or filter) and selection_wget", want: "((keywords or filter) and selection_wget)"},
+		{condition: "selection", wantErr: true},
+		{condition: "keywords and", wantErr: true},
+		{condition: "(keywords", wantErr: true},
+		{condition: "1 of other_*", wantErr: true},
+		{condition: "keywords | count() > 5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			node, err := compileCondition(tt.condition, identifiers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && node.String() != tt.want {
+				t.Errorf("compileCondition() = %s, want %s", node.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateDetection(t *testing.T) {
+	rule := `title: Download Tool
+id: download-tool
+logsource:
+    product: linux
+    service: auditd
+detection:
+    selection_curl:
+        Image|endswith: '/curl'
+    selection_wget:
+        - comm: wget
+        - exe|contains|all:
+            - '/usr/'
+            - 'wget'
+    filter:
+        auid|re: '^0$'
+    condition: 1 of selection_* and not filter`
+
+	engine := &Engine{rules: make([]Rule, 0)}
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "download.yml", rule)
+	if err := engine.loadRulesFromDir(dir); err != nil {
+		t.Fatalf("loadRulesFromDir() error = %v", err)
+	}
+	if len(engine.Rules()) != 1 {
+		t.Fatalf("Expected 1 rule to load, got %d", len(engine.Rules()))
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   bool
+	}{
+		{name: "mapped Image field", fields: map[string]string{"exe": "/usr/bin/curl", "auid": "1000"}, want: true},
+		{name: "any of a list of maps", fields: map[string]string{"comm": "wget", "auid": "1000"}, want: true},
+		{name: "all modifier", fields: map[string]string{"exe": "/usr/local/bin/wget", "auid": "1000"}, want: true},
+		{name: "all modifier partial", fields: map[string]string{"exe": "/opt/wget", "auid": "1000"}, want: false},
+		{name: "filtered", fields: map[string]string{"exe": "/usr/bin/curl", "auid": "0"}, want: false},
+		{name: "no selection", fields: map[string]string{"exe": "/usr/bin/ls", "auid": "1000"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := parser.LogEntry{
+				Category: "audit",
+				Product:  "linux",
+				Service:  "auditd",
+				Fields:   tt.fields,
+			}
+			matched := len(engine.Evaluate(entry)) > 0
+			if matched != tt.want {
+				t.Errorf("Evaluate() matched = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
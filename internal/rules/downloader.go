@@ -4,12 +4,17 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type GitHubDownloader struct {
@@ -146,58 +151,355 @@ func (gd *GitHubDownloader) extractFile(reader io.Reader, header *tar.Header, ta
 	return nil
 }
 
-// Alternative method using GitHub API for individual file downloads
+// GitHubAPIDownloader fetches selected rule files through the GitHub REST
+// API instead of downloading the whole repository archive. It authenticates
+// with a token when one is set, waits out rate limits, and uses ETags and blob
+// SHAs to skip files that haven't changed since the last download.
 type GitHubAPIDownloader struct {
-	client *http.Client
-	token  string
+	client  *http.Client
+	token   string
+	baseURL string
+
+	// maxWait caps how long a rate limit may pause a download
+	maxWait time.Duration
+	sleep   func(time.Duration)
+
+	rateResetAt time.Time
+}
+
+// ErrSourceUnchanged is returned when the API reports that a source hasn't
+// changed since it was last downloaded.
+var ErrSourceUnchanged = errors.New("source is unchanged since the last download")
+
+// apiStateFile keeps the ETags and blob SHAs of the installed copy of an
+// API-downloaded source, inside the source directory.
+const apiStateFile = ".github-state.json"
+
+type apiState struct {
+	TreeETag string                  `json:"tree_etag"`
+	Files    map[string]apiFileState `json:"files"`
+}
+
+type apiFileState struct {
+	SHA  string `json:"sha"`
+	ETag string `json:"etag"`
+}
+
+type gitTree struct {
+	SHA       string `json:"sha"`
+	Truncated bool   `json:"truncated"`
+	Tree      []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+	} `json:"tree"`
 }
 
 func NewGitHubAPIDownloader(token string) *GitHubAPIDownloader {
 	return &GitHubAPIDownloader{
-		client: &http.Client{},
-		token:  token,
+		client:  &http.Client{},
+		token:   token,
+		baseURL: "https://api.github.com",
+		maxWait: 15 * time.Minute,
+		sleep:   time.Sleep,
 	}
 }
 
-func (gad *GitHubAPIDownloader) DownloadFile(repoOwner, repoName, filePath, targetPath string) error {
-	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/master/%s", repoOwner, repoName, filePath)
+// DownloadRepositoryRules writes the YAML files under rulePaths to targetDir.
+// Files whose blob SHA matches the copy in previousDir are copied from there
+// instead of being downloaded again.
+func (gad *GitHubAPIDownloader) DownloadRepositoryRules(repoURL, branch, targetDir string, rulePaths []string, previousDir string) error {
+	owner, repo, err := parseRepoURL(repoURL)
+	if err != nil {
+		return err
+	}
+
+	previous := loadAPIState(previousDir)
 
-	req, err := http.NewRequest("GET", url, nil)
+	treeURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", gad.baseURL, owner, repo, url.PathEscape(branch))
+	resp, err := gad.get(treeURL, "application/vnd.github+json", previous.TreeETag)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to fetch repository tree: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return ErrSourceUnchanged
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch repository tree: status %d", resp.StatusCode)
+	}
+
+	var tree gitTree
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return fmt.Errorf("failed to decode repository tree: %w", err)
+	}
+	if tree.Truncated {
+		return fmt.Errorf("repository tree for %s/%s is too large for the API; use the archive strategy", owner, repo)
+	}
+
+	state := apiState{
+		TreeETag: resp.Header.Get("ETag"),
+		Files:    make(map[string]apiFileState),
+	}
+
+	wantedPaths := make(map[string]bool)
+	for _, path := range rulePaths {
+		wantedPaths[path] = true
+	}
+
+	for _, item := range tree.Tree {
+		if item.Type != "blob" || !isWantedRule(item.Path, wantedPaths) {
+			continue
+		}
+
+		targetPath := filepath.Join(targetDir, filepath.FromSlash(item.Path))
+		previousPath := filepath.Join(previousDir, filepath.FromSlash(item.Path))
+		known, haveKnown := previous.Files[item.Path]
+
+		// Same blob as the installed copy
+		if haveKnown && known.SHA == item.SHA && copyFile(previousPath, targetPath) == nil {
+			state.Files[item.Path] = known
+			continue
+		}
+
+		etag := ""
+		if haveKnown && fileExists(previousPath) {
+			etag = known.ETag
+		}
+
+		newETag, notModified, err := gad.downloadFile(owner, repo, branch, item.Path, targetPath, etag)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", item.Path, err)
+		}
+		if notModified {
+			if err := copyFile(previousPath, targetPath); err != nil {
+				return fmt.Errorf("failed to reuse %s: %w", item.Path, err)
+			}
+			newETag = known.ETag
+		}
+
+		state.Files[item.Path] = apiFileState{SHA: item.SHA, ETag: newETag}
 	}
 
-	if gad.token != "" {
-		req.Header.Set("Authorization", "token "+gad.token)
+	return saveAPIState(targetDir, state)
+}
+
+// DownloadFile downloads a single file from a repository at the given ref.
+func (gad *GitHubAPIDownloader) DownloadFile(repoOwner, repoName, ref, filePath, targetPath string) error {
+	_, _, err := gad.downloadFile(repoOwner, repoName, ref, filePath, targetPath, "")
+	return err
+}
+
+func (gad *GitHubAPIDownloader) downloadFile(repoOwner, repoName, ref, filePath, targetPath, etag string) (string, bool, error) {
+	escaped := make([]string, 0)
+	for _, part := range strings.Split(filePath, "/") {
+		escaped = append(escaped, url.PathEscape(part))
 	}
+	contentsURL := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", gad.baseURL, repoOwner, repoName, strings.Join(escaped, "/"), url.QueryEscape(ref))
 
-	resp, err := gad.client.Do(req)
+	resp, err := gad.get(contentsURL, "application/vnd.github.raw", etag)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return "", false, fmt.Errorf("failed to download file: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return etag, true, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+		return "", false, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
 	}
 
 	// Create target directory
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+		return "", false, fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Create the file
 	file, err := os.Create(targetPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return "", false, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 
 	// Copy the content
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
+		return "", false, fmt.Errorf("failed to copy file content: %w", err)
 	}
 
-	return nil
+	return resp.Header.Get("ETag"), false, nil
+}
+
+// get issues an API request, waiting out primary and secondary rate limits.
+func (gad *GitHubAPIDownloader) get(requestURL, accept, etag string) (*http.Response, error) {
+	const maxAttempts = 3
+
+	for attempt := 1; ; attempt++ {
+		// The previous response said the quota is used up
+		if wait := time.Until(gad.rateResetAt); wait > 0 {
+			if wait > gad.maxWait {
+				return nil, fmt.Errorf("GitHub API rate limit exhausted until %s", gad.rateResetAt.Format(time.RFC3339))
+			}
+			gad.sleep(wait)
+		}
+
+		req, err := http.NewRequest("GET", requestURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if gad.token != "" {
+			req.Header.Set("Authorization", "token "+gad.token)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := gad.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		wait, limited := gad.rateLimitWait(resp)
+		if !limited {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if attempt == maxAttempts {
+			return nil, fmt.Errorf("GitHub API rate limit still exceeded after %d attempts", attempt)
+		}
+		if wait > gad.maxWait {
+			return nil, fmt.Errorf("GitHub API rate limited for %s, longer than the %s limit", wait.Round(time.Second), gad.maxWait)
+		}
+		gad.sleep(wait)
+	}
+}
+
+// rateLimitWait records the quota reported by a response and, if the request
+// was rejected for rate limiting, how long to wait before retrying.
+func (gad *GitHubAPIDownloader) rateLimitWait(resp *http.Response) (time.Duration, bool) {
+	var resetAt time.Time
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		resetAt = time.Unix(reset, 0)
+	}
+
+	exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+	if exhausted && !resetAt.IsZero() {
+		gad.rateResetAt = resetAt
+	}
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	// Secondary rate limits say how long to back off
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if when, err := http.ParseTime(retryAfter); err == nil {
+			return time.Until(when), true
+		}
+	}
+
+	if exhausted {
+		gad.rateResetAt = time.Time{}
+		return time.Until(resetAt), true
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return time.Minute, true
+	}
+
+	// A plain 403 is a permissions problem, not a rate limit
+	return 0, false
+}
+
+func parseRepoURL(repoURL string) (string, string, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(repoURL, ".git"))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid repository URL %s: %w", repoURL, err)
+	}
+
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("repository URL %s is not of the form https://github.com/owner/repo", repoURL)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func isWantedRule(filePath string, wantedPaths map[string]bool) bool {
+	if !strings.HasSuffix(filePath, ".yml") && !strings.HasSuffix(filePath, ".yaml") {
+		return false
+	}
+
+	for wantedPath := range wantedPaths {
+		if strings.HasPrefix(filePath, wantedPath+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadAPIState(dir string) apiState {
+	state := apiState{Files: make(map[string]apiFileState)}
+
+	data, err := os.ReadFile(filepath.Join(dir, apiStateFile))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil || state.Files == nil {
+		return apiState{Files: make(map[string]apiFileState)}
+	}
+
+	return state
+}
+
+func saveAPIState(dir string, state apiState) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal download state: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(dir, apiStateFile), data, 0644)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
 }
@@ -0,0 +1,209 @@
+package rules
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHubAPI serves a repository tree and file contents the way the
+// GitHub REST API does, including ETags and rate limiting.
+type fakeGitHubAPI struct {
+	mu            sync.Mutex
+	files         map[string]string
+	treeRequests  int
+	fileRequests  map[string]int
+	rateLimitNext int
+	tokens        []string
+}
+
+func blobSHA(content string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+}
+
+func (f *fakeGitHubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokens = append(f.tokens, r.Header.Get("Authorization"))
+
+	if f.rateLimitNext > 0 {
+		f.rateLimitNext--
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(30*time.Second).Unix()))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/repos/owner/repo/git/trees/main":
+		f.treeRequests++
+
+		type treeItem struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			SHA  string `json:"sha"`
+		}
+		var tree struct {
+			Tree []treeItem `json:"tree"`
+		}
+		var shas []string
+		for path, content := range f.files {
+			sha := blobSHA(content)
+			tree.Tree = append(tree.Tree, treeItem{Path: path, Type: "blob", SHA: sha})
+			shas = append(shas, path+sha)
+		}
+		tree.Tree = append(tree.Tree, treeItem{Path: "rules/linux", Type: "tree", SHA: "dir"})
+
+		sort.Strings(shas)
+		etag := `"` + blobSHA(strings.Join(shas, ",")) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(tree)
+
+	case strings.HasPrefix(r.URL.Path, "/repos/owner/repo/contents/"):
+		path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/contents/")
+		content, ok := f.files[path]
+		if !ok || r.URL.Query().Get("ref") != "main" {
+			http.NotFound(w, r)
+			return
+		}
+		f.fileRequests[path]++
+		w.Header().Set("ETag", `"`+blobSHA(content)+`"`)
+		w.Write([]byte(content))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestGitHubAPIDownloader_DownloadRepositoryRules(t *testing.T) {
+	api := &fakeGitHubAPI{
+		files: map[string]string{
+			"rules/linux/auditd/one.yml": ruleContent("one"),
+			"rules/linux/auditd/two.yml": ruleContent("two"),
+			"rules/windows/skip.yml":     ruleContent("skip"),
+			"rules/linux/auditd/README":  "not a rule",
+		},
+		fileRequests: make(map[string]int),
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	var slept []time.Duration
+	newDownloader := func() *GitHubAPIDownloader {
+		downloader := NewGitHubAPIDownloader("secret")
+		downloader.baseURL = server.URL
+		downloader.sleep = func(d time.Duration) { slept = append(slept, d) }
+		return downloader
+	}
+
+	tmpDir := t.TempDir()
+	firstDir := filepath.Join(tmpDir, "first")
+	paths := []string{"rules/linux"}
+
+	// The first request is rate limited and retried after the reset
+	api.rateLimitNext = 1
+	if err := newDownloader().DownloadRepositoryRules("https://github.com/owner/repo", "main", firstDir, paths, filepath.Join(tmpDir, "none")); err != nil {
+		t.Fatalf("DownloadRepositoryRules() error = %v", err)
+	}
+
+	if len(slept) != 1 || slept[0] <= 0 || slept[0] > 30*time.Second {
+		t.Errorf("Expected one wait for the rate limit reset, got %v", slept)
+	}
+	for _, token := range api.tokens {
+		if token != "token secret" {
+			t.Errorf("Expected requests to be authenticated, got Authorization %q", token)
+		}
+	}
+
+	for _, name := range []string{"one.yml", "two.yml"} {
+		if _, err := os.Stat(filepath.Join(firstDir, "rules", "linux", "auditd", name)); err != nil {
+			t.Errorf("Expected %s to be downloaded: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(firstDir, "rules", "windows", "skip.yml")); !os.IsNotExist(err) {
+		t.Error("Expected files outside the selected paths to be skipped")
+	}
+
+	// Nothing changed upstream
+	err := newDownloader().DownloadRepositoryRules("https://github.com/owner/repo", "main", filepath.Join(tmpDir, "second"), paths, firstDir)
+	if !errors.Is(err, ErrSourceUnchanged) {
+		t.Fatalf("Expected ErrSourceUnchanged, got %v", err)
+	}
+
+	// One file changes; the other is reused from the previous download
+	api.files["rules/linux/auditd/two.yml"] = ruleContent("two-updated")
+	thirdDir := filepath.Join(tmpDir, "third")
+	if err := newDownloader().DownloadRepositoryRules("https://github.com/owner/repo", "main", thirdDir, paths, firstDir); err != nil {
+		t.Fatalf("DownloadRepositoryRules() error = %v", err)
+	}
+
+	if api.fileRequests["rules/linux/auditd/one.yml"] != 1 {
+		t.Errorf("Expected unchanged one.yml to be fetched once, got %d", api.fileRequests["rules/linux/auditd/one.yml"])
+	}
+	if api.fileRequests["rules/linux/auditd/two.yml"] != 2 {
+		t.Errorf("Expected changed two.yml to be fetched twice, got %d", api.fileRequests["rules/linux/auditd/two.yml"])
+	}
+
+	data, err := os.ReadFile(filepath.Join(thirdDir, "rules", "linux", "auditd", "one.yml"))
+	if err != nil || !strings.Contains(string(data), "id: one") {
+		t.Errorf("Expected one.yml to be copied from the previous download, got %q (%v)", data, err)
+	}
+}
+
+func TestGitHubAPIDownloader_RateLimitTooLong(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	downloader := NewGitHubAPIDownloader("")
+	downloader.baseURL = server.URL
+	downloader.maxWait = time.Minute
+	downloader.sleep = func(time.Duration) { t.Error("Expected no wait beyond maxWait") }
+
+	err := downloader.DownloadRepositoryRules("https://github.com/owner/repo", "main", t.TempDir(), []string{"rules"}, "")
+	if err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("Expected a rate limit error, got %v", err)
+	}
+}
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		wantOwner string
+		wantRepo  string
+		wantErr   bool
+	}{
+		{url: "https://github.com/SigmaHQ/sigma", wantOwner: "SigmaHQ", wantRepo: "sigma"},
+		{url: "https://github.com/M00NLIG7/ChopChopGo.git", wantOwner: "M00NLIG7", wantRepo: "ChopChopGo"},
+		{url: "https://github.com/SigmaHQ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			owner, repo, err := parseRepoURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRepoURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if owner != tt.wantOwner || repo != tt.wantRepo {
+				t.Errorf("parseRepoURL() = %s/%s, want %s/%s", owner, repo, tt.wantOwner, tt.wantRepo)
+			}
+		})
+	}
+}
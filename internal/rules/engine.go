@@ -1,12 +1,15 @@
 package rules
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/go-yaml/yaml"
@@ -14,34 +17,61 @@ import (
 )
 
 type Engine struct {
-	rules []Rule
+	rulesDir string
+	rules    []Rule
+	ruleIDs  map[string]bool
+	// regexes holds the rules' regular expressions, compiled once at load
+	regexes map[string]*regexp.Regexp
 }
 
 type Rule struct {
-	Title          string                 `yaml:"title"`
-	ID             string                 `yaml:"id"`
-	Status         string                 `yaml:"status"`
-	Description    string                 `yaml:"description"`
-	Author         string                 `yaml:"author"`
-	Date           string                 `yaml:"date"`
-	Modified       string                 `yaml:"modified"`
-	Tags           []string               `yaml:"tags"`
-	Level          string                 `yaml:"level"`
-	Logsource      LogSource              `yaml:"logsource"`
-	Detection      map[string]interface{} `yaml:"detection"`
-	Falsepositives []string               `yaml:"falsepositives"`
-	Fields         []string               `yaml:"fields"`
+	Title          string                 `yaml:"title" json:"title"`
+	ID             string                 `yaml:"id" json:"id"`
+	Status         string                 `yaml:"status" json:"status,omitempty"`
+	Description    string                 `yaml:"description" json:"description,omitempty"`
+	Author         string                 `yaml:"author" json:"author,omitempty"`
+	Date           string                 `yaml:"date" json:"date,omitempty"`
+	Modified       string                 `yaml:"modified" json:"modified,omitempty"`
+	Tags           []string               `yaml:"tags" json:"tags,omitempty"`
+	Level          string                 `yaml:"level" json:"level,omitempty"`
+	Logsource      LogSource              `yaml:"logsource" json:"logsource"`
+	Detection      map[string]interface{} `yaml:"detection" json:"detection"`
+	Falsepositives []string               `yaml:"falsepositives" json:"falsepositives,omitempty"`
+	Fields         []string               `yaml:"fields" json:"fields,omitempty"`
+
+	// Where the rule was loaded from
+	Path   string `yaml:"-" json:"path"`
+	Source string `yaml:"-" json:"source"`
+
+	condition conditionNode
+	// digest is the SHA-256 of the rule file.
+	digest string
 }
 
 type LogSource struct {
-	Category string `yaml:"category"`
-	Product  string `yaml:"product"`
-	Service  string `yaml:"service"`
+	Category string `yaml:"category" json:"category,omitempty"`
+	Product  string `yaml:"product" json:"product,omitempty"`
+	Service  string `yaml:"service" json:"service,omitempty"`
+}
+
+// String formats the logsource as product/category/service.
+func (l LogSource) String() string {
+	var parts []string
+	for _, part := range []string{l.Product, l.Category, l.Service} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "any"
+	}
+	return strings.Join(parts, "/")
 }
 
 func NewEngine(rulesDir string) (*Engine, error) {
 	engine := &Engine{
-		rules: make([]Rule, 0),
+		rulesDir: rulesDir,
+		rules:    make([]Rule, 0),
 	}
 
 	if err := engine.loadRules(rulesDir); err != nil {
@@ -80,6 +110,11 @@ func (e *Engine) loadRulesFromDir(dir string) error {
 			return err
 		}
 
+		// Skip staged downloads and kept versions of rule sources
+		if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
 		if !strings.HasSuffix(path, ".yml") && !strings.HasSuffix(path, ".yaml") {
 			return nil
 		}
@@ -91,11 +126,23 @@ func (e *Engine) loadRulesFromDir(dir string) error {
 		}
 
 		// Additional validation for rule structure
-		if err := e.validateRule(rule); err != nil {
+		if err := e.validateRule(&rule); err != nil {
 			log.Printf("Warning: rule %s failed validation: %v", path, err)
 			return nil // Continue loading other rules
 		}
 
+		// Nested rule directories are walked more than once, and sources
+		// can ship copies of the same rule; keep the first one loaded
+		if e.ruleIDs == nil {
+			e.ruleIDs = make(map[string]bool)
+		}
+		if e.ruleIDs[rule.ID] {
+			return nil
+		}
+		e.ruleIDs[rule.ID] = true
+
+		rule.Path = path
+		rule.Source = e.ruleSource(path)
 		e.rules = append(e.rules, rule)
 		return nil
 	})
@@ -123,45 +170,198 @@ func (e *Engine) loadRule(filePath string) (Rule, error) {
 	if rule.Detection == nil {
 		return rule, fmt.Errorf("rule in %s is missing required field 'detection'", filePath)
 	}
+	normalizeYAML(rule.Detection)
+
+	digest := sha256.Sum256(data)
+	rule.digest = hex.EncodeToString(digest[:])
 
 	return rule, nil
 }
 
-func (e *Engine) validateRule(rule Rule) error {
+// Digest is the SHA-256 of the file the rule was loaded from.
+func (r Rule) Digest() string {
+	return r.digest
+}
+
+// Hash identifies the loaded rule set: the SHA-256 of a sha256sum-style
+// listing of every rule file's digest and rule ID, sorted by ID, so the same
+// rules hash the same wherever they are installed.
+func (e *Engine) Hash() string {
+	sorted := append([]Rule(nil), e.rules...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	hash := sha256.New()
+	for _, rule := range sorted {
+		fmt.Fprintf(hash, "%s  %s\n", rule.digest, rule.ID)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// validateRule checks the detection section and compiles its condition.
+func (e *Engine) validateRule(rule *Rule) error {
 	// Check if detection section has valid structure
 	if rule.Detection == nil {
 		return fmt.Errorf("detection section is nil")
 	}
 
-	// Check for common detection patterns
-	hasSelection := false
-	hasCondition := false
+	value, ok := rule.Detection["condition"]
+	if !ok || value == nil {
+		return fmt.Errorf("detection section missing 'condition'")
+	}
 
-	for key, value := range rule.Detection {
-		if key == "selection" {
-			hasSelection = true
-			if value == nil {
-				return fmt.Errorf("selection section is nil")
+	identifiers := detectionIdentifiers(rule.Detection)
+	if len(identifiers) == 0 {
+		return fmt.Errorf("detection section has no search identifiers")
+	}
+	for _, identifier := range identifiers {
+		if rule.Detection[identifier] == nil {
+			return fmt.Errorf("%s section is nil", identifier)
+		}
+	}
+
+	condition, err := conditionString(value)
+	if err != nil {
+		return err
+	}
+
+	rule.condition, err = compileCondition(condition, identifiers)
+	if err != nil {
+		return err
+	}
+
+	// Reject searches that can't be evaluated as written
+	for _, identifier := range identifiers {
+		if err := e.compileSearch(rule.Detection[identifier]); err != nil {
+			return fmt.Errorf("%s: %w", identifier, err)
+		}
+	}
+	return nil
+}
+
+// compileSearch checks the modifiers used by a search and compiles its
+// regular expressions.
+func (e *Engine) compileSearch(search interface{}) error {
+	switch v := search.(type) {
+	case map[string]interface{}:
+		for field, criteria := range v {
+			if err := e.compileField(field, criteria); err != nil {
+				return err
 			}
 		}
-		if key == "condition" {
-			hasCondition = true
-			if value == nil {
-				return fmt.Errorf("condition section is nil")
+	case []interface{}:
+		for _, item := range v {
+			if err := e.compileSearch(item); err != nil {
+				return err
 			}
 		}
+	case nil:
+	default:
+		return e.compileValue(criteriaString(v), "")
 	}
+	return nil
+}
 
-	if !hasSelection {
-		return fmt.Errorf("detection section missing 'selection'")
+func (e *Engine) compileField(field string, criteria interface{}) error {
+	name, modifiers := splitFieldModifiers(field)
+	modifier, err := valueModifier(modifiers)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", name, err)
 	}
-	if !hasCondition {
-		return fmt.Errorf("detection section missing 'condition'")
+
+	switch v := criteria.(type) {
+	case map[string]interface{}:
+		// Modifiers written as keys under the field
+		for key, value := range v {
+			if key == "all" || !valueModifiers[key] {
+				return fmt.Errorf("field %s: unsupported modifier %q", name, key)
+			}
+			if pattern, ok := value.(string); ok {
+				if err := e.compileValue(pattern, key); err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := e.compileValue(criteriaString(item), modifier); err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+		}
+	case nil:
+	default:
+		if err := e.compileValue(criteriaString(v), modifier); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
 	}
+	return nil
+}
 
+// compileValue compiles pattern if it is a regular expression, either
+// through the re modifier or a |re| prefix.
+func (e *Engine) compileValue(pattern, modifier string) error {
+	if modifier == "" && strings.HasPrefix(pattern, "|re|") {
+		pattern, modifier = strings.TrimPrefix(pattern, "|re|"), "re"
+	}
+	if modifier != "re" {
+		return nil
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	if e.regexes == nil {
+		e.regexes = make(map[string]*regexp.Regexp)
+	}
+	e.regexes[pattern] = regex
 	return nil
 }
 
+// valueModifiers are the Sigma modifiers that change how values are
+// compared; a field takes at most one of them, along with all.
+var valueModifiers = map[string]bool{
+	"contains":   true,
+	"startswith": true,
+	"endswith":   true,
+	"re":         true,
+	"all":        true,
+}
+
+// valueModifier returns the modifier that says how a field's values are
+// compared, or "" for an exact match, rejecting any the engine doesn't
+// support.
+func valueModifier(modifiers []string) (string, error) {
+	var modifier string
+	for _, m := range modifiers {
+		if !valueModifiers[m] {
+			return "", fmt.Errorf("unsupported modifier %q", m)
+		}
+		if m == "all" {
+			continue
+		}
+		if modifier != "" {
+			return "", fmt.Errorf("modifiers %s and %s can't be combined", modifier, m)
+		}
+		modifier = m
+	}
+	return modifier, nil
+}
+
+// ruleSource names the source a rule file belongs to: the directory under
+// external/ for downloaded sources, or "local".
+func (e *Engine) ruleSource(path string) string {
+	rel, err := filepath.Rel(e.rulesDir, path)
+	if err != nil {
+		return "local"
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) > 2 && parts[0] == "external" {
+		return parts[1]
+	}
+	return "local"
+}
+
 func (e *Engine) Evaluate(entry parser.LogEntry) []string {
 	var matchedRules []string
 
@@ -181,7 +381,7 @@ func (e *Engine) matchesRule(entry parser.LogEntry, rule Rule) bool {
 	}
 
 	// Evaluate detection logic
-	return e.evaluateDetection(entry, rule.Detection)
+	return e.evaluateDetection(entry, rule)
 }
 
 func (e *Engine) matchesLogSource(entry parser.LogEntry, logSource LogSource) bool {
@@ -198,61 +398,221 @@ func (e *Engine) matchesLogSource(entry parser.LogEntry, logSource LogSource) bo
 	return true
 }
 
-func (e *Engine) evaluateDetection(entry parser.LogEntry, detection map[string]interface{}) bool {
-	// Evaluate selection criteria
-	matches := make(map[string]bool)
-
-	// Get selection criteria
-	selection, ok := detection["selection"].(map[string]interface{})
-	if !ok {
+func (e *Engine) evaluateDetection(entry parser.LogEntry, rule Rule) bool {
+	if rule.condition == nil {
 		return false
 	}
 
-	for field, criteria := range selection {
-		matches[field] = e.evaluateField(entry, field, criteria)
-	}
+	// Each search identifier is evaluated at most once per entry
+	results := make(map[string]bool)
+	return rule.condition.evaluate(func(name string) bool {
+		matched, ok := results[name]
+		if !ok {
+			matched = e.evaluateSearch(entry, rule.Detection[name])
+			results[name] = matched
+		}
+		return matched
+	})
+}
 
-	// Evaluate condition
-	condition, ok := detection["condition"].(string)
-	if !ok {
+// evaluateSearch evaluates a search identifier: a map of fields that must all
+// match, a list of such maps where any may match, or keywords searched for in
+// the message.
+func (e *Engine) evaluateSearch(entry parser.LogEntry, search interface{}) bool {
+	switch v := search.(type) {
+	case map[string]interface{}:
+		for field, criteria := range v {
+			if !e.evaluateField(entry, field, criteria) {
+				return false
+			}
+		}
+		return len(v) > 0
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); ok {
+				if e.evaluateSearch(entry, item) {
+					return true
+				}
+			} else if e.matchString(entry.Message, criteriaString(item)) {
+				return true
+			}
+		}
 		return false
+	case nil:
+		return false
+	default:
+		return e.matchString(entry.Message, criteriaString(v))
 	}
-
-	return e.evaluateCondition(matches, condition)
 }
 
 func (e *Engine) evaluateField(entry parser.LogEntry, field string, criteria interface{}) bool {
-	fieldValue := e.getFieldValue(entry, field)
+	// Sigma style modifiers: field|contains, field|endswith|all
+	name, modifiers := splitFieldModifiers(field)
+	fieldValue := e.getFieldValue(entry, name)
 
 	switch v := criteria.(type) {
-	case string:
-		return e.matchString(fieldValue, v)
+	case nil:
+		return fieldValue == ""
 	case []interface{}:
+		all := hasModifier(modifiers, "all")
 		for _, item := range v {
-			var itemStr string
-			switch val := item.(type) {
-			case string:
-				itemStr = val
-			case int:
-				itemStr = fmt.Sprintf("%d", val)
-			case float64:
-				itemStr = fmt.Sprintf("%.0f", val)
-			default:
-				itemStr = fmt.Sprintf("%v", val)
-			}
-			if e.matchString(fieldValue, itemStr) {
+			matched := e.matchModified(fieldValue, criteriaString(item), modifiers)
+			if matched && !all {
 				return true
 			}
+			if !matched && all {
+				return false
+			}
 		}
-		return false
+		return all && len(v) > 0
 	case map[string]interface{}:
 		return e.evaluateFieldModifiers(fieldValue, v)
 	default:
-		return false
+		return e.matchModified(fieldValue, criteriaString(v), modifiers)
+	}
+}
+
+// matchModified compares a field value with a pattern. Without a modifier
+// the whole value must match.
+func (e *Engine) matchModified(value, pattern string, modifiers []string) bool {
+	for _, modifier := range modifiers {
+		switch modifier {
+		case "contains", "startswith", "endswith":
+			return matchWildcard(value, pattern, modifier)
+		case "re":
+			return e.matchRegex(value, pattern)
+		}
+	}
+
+	// Patterns can also carry their modifier as a |re| style prefix
+	if strings.HasPrefix(pattern, "|") {
+		return e.matchString(value, pattern)
+	}
+	return matchWildcard(value, pattern, "")
+}
+
+// matchRegex matches value against a regular expression compiled when the
+// rule was loaded.
+func (e *Engine) matchRegex(value, pattern string) bool {
+	regex, ok := e.regexes[pattern]
+	return ok && regex.MatchString(value)
+}
+
+// Wildcards in a pattern split into tokens; other tokens are literal runes.
+const (
+	anyRun  rune = -1 // *
+	anyRune rune = -2 // ?
+)
+
+// matchWildcard compares value with a Sigma pattern case-insensitively. The
+// whole value must match, unless modifier is contains, startswith or
+// endswith. In the pattern * matches any run of characters and ? any one,
+// and a backslash escapes either.
+func matchWildcard(value, pattern, modifier string) bool {
+	value, pattern = strings.ToLower(value), strings.ToLower(pattern)
+
+	if !strings.ContainsAny(pattern, `*?\`) {
+		switch modifier {
+		case "contains":
+			return strings.Contains(value, pattern)
+		case "startswith":
+			return strings.HasPrefix(value, pattern)
+		case "endswith":
+			return strings.HasSuffix(value, pattern)
+		default:
+			return value == pattern
+		}
+	}
+
+	tokens := wildcardTokens(pattern)
+	if modifier == "contains" || modifier == "endswith" {
+		tokens = append([]rune{anyRun}, tokens...)
+	}
+	if modifier == "contains" || modifier == "startswith" {
+		tokens = append(tokens, anyRun)
+	}
+	return matchTokens([]rune(value), tokens)
+}
+
+func wildcardTokens(pattern string) []rune {
+	runes := []rune(pattern)
+	tokens := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '*':
+			tokens = append(tokens, anyRun)
+		case runes[i] == '?':
+			tokens = append(tokens, anyRune)
+		case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`*?\`, runes[i+1]):
+			i++
+			tokens = append(tokens, runes[i])
+		default:
+			tokens = append(tokens, runes[i])
+		}
+	}
+	return tokens
+}
+
+// matchTokens matches value against wildcard tokens, backtracking to the
+// last * on a mismatch.
+func matchTokens(value, tokens []rune) bool {
+	v, t := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+		switch {
+		case t < len(tokens) && (tokens[t] == anyRune || tokens[t] == value[v]):
+			v++
+			t++
+		case t < len(tokens) && tokens[t] == anyRun:
+			star, mark = t, v
+			t++
+		case star >= 0:
+			mark++
+			t, v = star+1, mark
+		default:
+			return false
+		}
+	}
+
+	for t < len(tokens) && tokens[t] == anyRun {
+		t++
+	}
+	return t == len(tokens)
+}
+
+func splitFieldModifiers(field string) (string, []string) {
+	parts := strings.Split(field, "|")
+	return parts[0], parts[1:]
+}
+
+func hasModifier(modifiers []string, modifier string) bool {
+	for _, m := range modifiers {
+		if m == modifier {
+			return true
+		}
+	}
+	return false
+}
+
+func criteriaString(value interface{}) string {
+	switch val := value.(type) {
+	case string:
+		return val
+	case int:
+		return fmt.Sprintf("%d", val)
+	case float64:
+		return fmt.Sprintf("%.0f", val)
+	default:
+		return fmt.Sprintf("%v", val)
 	}
 }
 
 func (e *Engine) getFieldValue(entry parser.LogEntry, field string) string {
+	// Translate Sigma field names for the target that produced the entry
+	if target, ok := LookupTarget(entry.Service); ok {
+		field = target.MapField(field)
+	}
+
 	switch field {
 	case "message":
 		return entry.Message
@@ -273,101 +633,45 @@ func (e *Engine) getFieldValue(entry parser.LogEntry, field string) string {
 	}
 }
 
+// matchString searches value for a keyword, or for a pattern marked with a
+// |re|, |contains|, |startswith| or |endswith| prefix.
 func (e *Engine) matchString(value, pattern string) bool {
 	// Handle regex patterns
 	if strings.HasPrefix(pattern, "|re|") {
-		regex := strings.TrimPrefix(pattern, "|re|")
-		matched, _ := regexp.MatchString(regex, value)
-		return matched
+		return e.matchRegex(value, strings.TrimPrefix(pattern, "|re|"))
 	}
 
 	// Handle contains patterns
 	if strings.HasPrefix(pattern, "|contains|") {
-		substring := strings.TrimPrefix(pattern, "|contains|")
-		return strings.Contains(strings.ToLower(value), strings.ToLower(substring))
+		return matchWildcard(value, strings.TrimPrefix(pattern, "|contains|"), "contains")
 	}
 
 	// Handle startswith patterns
 	if strings.HasPrefix(pattern, "|startswith|") {
-		prefix := strings.TrimPrefix(pattern, "|startswith|")
-		return strings.HasPrefix(strings.ToLower(value), strings.ToLower(prefix))
+		return matchWildcard(value, strings.TrimPrefix(pattern, "|startswith|"), "startswith")
 	}
 
 	// Handle endswith patterns
 	if strings.HasPrefix(pattern, "|endswith|") {
-		suffix := strings.TrimPrefix(pattern, "|endswith|")
-		return strings.HasSuffix(strings.ToLower(value), strings.ToLower(suffix))
+		return matchWildcard(value, strings.TrimPrefix(pattern, "|endswith|"), "endswith")
 	}
 
 	// Default: case-insensitive contains
-	return strings.Contains(strings.ToLower(value), strings.ToLower(pattern))
+	return matchWildcard(value, pattern, "contains")
 }
 
 func (e *Engine) evaluateFieldModifiers(value string, modifiers map[string]interface{}) bool {
 	for modifier, criteria := range modifiers {
+		criteriaStr, ok := criteria.(string)
+		if !ok {
+			continue
+		}
 		switch modifier {
-		case "contains":
-			if criteriaStr, ok := criteria.(string); ok {
-				return strings.Contains(strings.ToLower(value), strings.ToLower(criteriaStr))
-			}
-		case "startswith":
-			if criteriaStr, ok := criteria.(string); ok {
-				return strings.HasPrefix(strings.ToLower(value), strings.ToLower(criteriaStr))
-			}
-		case "endswith":
-			if criteriaStr, ok := criteria.(string); ok {
-				return strings.HasSuffix(strings.ToLower(value), strings.ToLower(criteriaStr))
-			}
+		case "contains", "startswith", "endswith":
+			return matchWildcard(value, criteriaStr, modifier)
 		case "re":
-			if criteriaStr, ok := criteria.(string); ok {
-				matched, _ := regexp.MatchString(criteriaStr, value)
-				return matched
-			}
+			return e.matchRegex(value, criteriaStr)
 		}
 	}
 	return false
 }
-
-func (e *Engine) evaluateCondition(matches map[string]bool, condition string) bool {
-	if condition == "" {
-		condition = "selection"
-	}
-
-	// Simple condition evaluation - can be enhanced for complex logic
-	condition = strings.ToLower(condition)
-
-	if condition == "selection" {
-		// All selection criteria must match
-		for _, match := range matches {
-			if !match {
-				return false
-			}
-		}
-		return len(matches) > 0
-	}
-
-	if strings.Contains(condition, " and ") {
-		parts := strings.Split(condition, " and ")
-		for _, part := range parts {
-			part = strings.TrimSpace(part)
-			if !matches[part] {
-				return false
-			}
-		}
-		return true
-	}
-
-	if strings.Contains(condition, " or ") {
-		parts := strings.Split(condition, " or ")
-		for _, part := range parts {
-			part = strings.TrimSpace(part)
-			if matches[part] {
-				return true
-			}
-		}
-		return false
-	}
-
-	// Single field condition
-	return matches[condition]
-}
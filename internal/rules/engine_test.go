@@ -8,6 +8,17 @@ import (
 	"github.com/wellknittech/hayanix/internal/parser"
 )
 
+func writeRuleFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create rule directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rule file: %v", err)
+	}
+}
+
 func TestNewEngine(t *testing.T) {
 	// Create a temporary rules directory with a test rule
 	tmpDir := t.TempDir()
@@ -243,3 +254,277 @@ func TestEngine_NonExistentRulesDirectory(t *testing.T) {
 		t.Error("Expected engine to be created, got nil")
 	}
 }
+
+func TestEngine_LoadRules(t *testing.T) {
+	rulesDir := t.TempDir()
+
+	// Walked from both rules/linux and rules/linux/syslog
+	writeRuleFile(t, filepath.Join(rulesDir, "linux", "syslog"), "sudo.yml", `title: Sudo Failure
+id: sudo-failure
+logsource:
+    product: linux
+    service: syslog
+detection:
+    selection:
+        program: sudo
+        message|contains: 'authentication failure'
+    condition: selection`)
+
+	// A copy of the same rule shipped by a downloaded source
+	writeRuleFile(t, filepath.Join(rulesDir, "external", "custom"), "sudo.yml", `title: Sudo Failure Copy
+id: sudo-failure
+logsource:
+    product: linux
+detection:
+    selection:
+        program: sudo
+    condition: selection`)
+
+	writeRuleFile(t, filepath.Join(rulesDir, "linux"), "count.yml", `title: SSH Brute Force Count
+id: ssh-count
+logsource:
+    product: linux
+detection:
+    selection:
+        program: sshd
+    condition: selection | count() by hostname > 10`)
+
+	writeRuleFile(t, filepath.Join(rulesDir, "linux"), "unknown.yml", `title: Unknown Identifier
+id: unknown-identifier
+logsource:
+    product: linux
+detection:
+    selection:
+        program: sshd
+    condition: selection and filter`)
+
+	engine, err := NewEngine(rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	rules := engine.Rules()
+	if len(rules) != 1 {
+		t.Fatalf("Expected 1 rule to load, got %d", len(rules))
+	}
+	if rules[0].ID != "sudo-failure" {
+		t.Errorf("Expected rule sudo-failure, got %s", rules[0].ID)
+	}
+
+	entry := parser.LogEntry{
+		Program: "sudo",
+		Message: "pam_unix(sudo:auth): authentication failure; user=bob",
+		Product: "linux",
+		Service: "syslog",
+	}
+	if matched := engine.Evaluate(entry); len(matched) != 1 {
+		t.Errorf("Expected the rule to be evaluated once, got %v", matched)
+	}
+}
+
+func TestEngine_EvaluateKeywords(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "keywords.yml", `title: Suspicious Keywords
+id: suspicious-keywords
+logsource:
+    product: linux
+detection:
+    keywords:
+        - 'Accepted publickey'
+        - 'session opened'
+    selection:
+        message:
+            contains: 'root'
+    condition: keywords and selection`)
+
+	engine := &Engine{rules: make([]Rule, 0)}
+	if err := engine.loadRulesFromDir(dir); err != nil {
+		t.Fatalf("loadRulesFromDir() error = %v", err)
+	}
+	if len(engine.Rules()) != 1 {
+		t.Fatalf("Expected 1 rule to load, got %d", len(engine.Rules()))
+	}
+
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{message: "Accepted publickey for root from 10.0.0.1", want: true},
+		{message: "pam_unix(su:session): session opened for user root", want: true},
+		{message: "Accepted publickey for alice from 10.0.0.1", want: false},
+		{message: "Failed password for root from 10.0.0.1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			entry := parser.LogEntry{Message: tt.message, Product: "linux"}
+			if got := len(engine.Evaluate(entry)) == 1; got != tt.want {
+				t.Errorf("Evaluate() matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_Hash(t *testing.T) {
+	rule := func(id, keyword string) string {
+		return "title: " + id + "\nid: " + id + "\nlogsource:\n    product: linux\ndetection:\n    keywords:\n        - '" + keyword + "'\n    condition: keywords\n"
+	}
+	write := func(files map[string]string) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write rule: %v", err)
+			}
+		}
+		return dir
+	}
+	hash := func(dir string) string {
+		engine, err := NewEngine(dir)
+		if err != nil {
+			t.Fatalf("NewEngine() error = %v", err)
+		}
+		return engine.Hash()
+	}
+
+	original := hash(write(map[string]string{"a.yml": rule("rule-a", "one"), "b.yml": rule("rule-b", "two")}))
+	moved := hash(write(map[string]string{"linux/z.yml": rule("rule-a", "one"), "b.yml": rule("rule-b", "two")}))
+	changed := hash(write(map[string]string{"a.yml": rule("rule-a", "three"), "b.yml": rule("rule-b", "two")}))
+
+	if len(original) != 64 {
+		t.Errorf("Expected a hex SHA-256, got %q", original)
+	}
+	if moved != original {
+		t.Error("Expected the same rules to hash the same wherever they are installed")
+	}
+	if changed == original {
+		t.Error("Expected a changed rule to change the hash")
+	}
+}
+
+func TestEngine_RejectsUnsupportedSearches(t *testing.T) {
+	rule := func(selection string) string {
+		return "title: Test\nid: test\nlogsource:\n    product: linux\ndetection:\n    selection:\n        " + selection + "\n    condition: selection\n"
+	}
+
+	tests := []struct {
+		name      string
+		selection string
+		wantLoad  bool
+	}{
+		{name: "supported modifiers", selection: "CommandLine|contains|all: ['curl', 'http']", wantLoad: true},
+		{name: "regex", selection: "message|re: 'Failed .* for (root|admin)'", wantLoad: true},
+		{name: "prefixed regex", selection: "message: '|re|^sshd'", wantLoad: true},
+		{name: "unknown modifier", selection: "DestinationIp|cidr: '10.0.0.0/8'", wantLoad: false},
+		{name: "unknown chained modifier", selection: "CommandLine|base64offset|contains: 'bash'", wantLoad: false},
+		{name: "conflicting modifiers", selection: "Image|startswith|endswith: '/tmp/'", wantLoad: false},
+		{name: "invalid regex", selection: "message|re: 'Failed (password'", wantLoad: false},
+		{name: "invalid prefixed regex", selection: "message: '|re|Failed (password'", wantLoad: false},
+		{name: "unknown map modifier", selection: "message: {cidr: '10.0.0.0/8'}", wantLoad: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeRuleFile(t, dir, "rule.yml", rule(tt.selection))
+
+			engine := &Engine{rules: make([]Rule, 0)}
+			if err := engine.loadRulesFromDir(dir); err != nil {
+				t.Fatalf("loadRulesFromDir() error = %v", err)
+			}
+			if loaded := len(engine.Rules()) == 1; loaded != tt.wantLoad {
+				t.Errorf("Rule loaded = %v, want %v", loaded, tt.wantLoad)
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateFieldValues(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "fields.yml", `title: Field Values
+id: field-values
+logsource:
+    product: linux
+detection:
+    selection:
+        program:
+            - 'sh'
+            - 'python?'
+        message|re: '^Running (/tmp|/dev/shm)/'
+        exe|endswith: '/sh'
+        cwd: '/home/*/build'
+    condition: selection`)
+
+	engine := &Engine{rules: make([]Rule, 0)}
+	if err := engine.loadRulesFromDir(dir); err != nil {
+		t.Fatalf("loadRulesFromDir() error = %v", err)
+	}
+	if len(engine.Rules()) != 1 {
+		t.Fatalf("Expected 1 rule to load, got %d", len(engine.Rules()))
+	}
+
+	match := parser.LogEntry{
+		Program: "SH",
+		Message: "Running /tmp/x",
+		Product: "linux",
+		Fields:  map[string]string{"exe": "/bin/sh", "cwd": "/home/alice/build"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(entry *parser.LogEntry)
+		want   bool
+	}{
+		{name: "all fields match", modify: func(entry *parser.LogEntry) {}, want: true},
+		{name: "single character wildcard", modify: func(entry *parser.LogEntry) { entry.Program = "python3" }, want: true},
+		{name: "value only contains the pattern", modify: func(entry *parser.LogEntry) { entry.Program = "bash" }, want: false},
+		{name: "regex doesn't match", modify: func(entry *parser.LogEntry) { entry.Message = "Running /usr/bin/x" }, want: false},
+		{name: "suffix doesn't match", modify: func(entry *parser.LogEntry) { entry.Fields["exe"] = "/bin/ssh" }, want: false},
+		{name: "wildcard doesn't match", modify: func(entry *parser.LogEntry) { entry.Fields["cwd"] = "/home/alice/src" }, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := match
+			entry.Fields = map[string]string{"exe": match.Fields["exe"], "cwd": match.Fields["cwd"]}
+			tt.modify(&entry)
+			if got := len(engine.Evaluate(entry)) == 1; got != tt.want {
+				t.Errorf("Evaluate() matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		value    string
+		pattern  string
+		modifier string
+		want     bool
+	}{
+		{value: "/usr/bin/curl", pattern: "/USR/BIN/CURL", want: true},
+		{value: "/usr/bin/curl", pattern: "curl", want: false},
+		{value: "/usr/bin/curl", pattern: "*/curl", want: true},
+		{value: "/usr/bin/curl", pattern: "/usr/*/c?rl", want: true},
+		{value: "/usr/bin/curl", pattern: "/usr/*/c?l", want: false},
+		{value: "wget -q http://x", pattern: "wget*http://", modifier: "contains", want: true},
+		{value: "http://x wget", pattern: "wget*http://", modifier: "contains", want: false},
+		{value: "a*b", pattern: `a\*b`, want: true},
+		{value: "axb", pattern: `a\*b`, want: false},
+		{value: `C:\temp`, pattern: `c:\temp`, want: true},
+		{value: "/tmp/x", pattern: "/tmp/", modifier: "startswith", want: true},
+		{value: "/bin/sh", pattern: "/sh", modifier: "endswith", want: true},
+		{value: "/bin/sh -c", pattern: "/sh", modifier: "endswith", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"|"+tt.modifier, func(t *testing.T) {
+			if got := matchWildcard(tt.value, tt.pattern, tt.modifier); got != tt.want {
+				t.Errorf("matchWildcard(%q, %q, %q) = %v, want %v", tt.value, tt.pattern, tt.modifier, got, tt.want)
+			}
+		})
+	}
+}
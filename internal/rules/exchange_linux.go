@@ -0,0 +1,20 @@
+//go:build linux
+
+package rules
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchangeDirs atomically swaps two paths with renameat2(RENAME_EXCHANGE),
+// so neither path is ever missing.
+func exchangeDirs(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		// Older kernels and some filesystems don't support the flag
+		return errors.ErrUnsupported
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package rules
+
+import "errors"
+
+// exchangeDirs is only available on Linux; elsewhere swaps fall back to two
+// renames.
+func exchangeDirs(a, b string) error {
+	return errors.ErrUnsupported
+}
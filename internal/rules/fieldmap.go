@@ -0,0 +1,127 @@
+package rules
+
+import "strings"
+
+// Target describes the log entries a parser target produces, so Sigma field
+// names can be mapped onto them.
+type Target struct {
+	Name      string
+	Logsource LogSource
+
+	// Mappings translates Sigma field names to the entry field that holds
+	// the value
+	Mappings map[string]string
+
+	// Fields are the entry fields the parser fills in; RecordFields is set
+	// when it also copies arbitrary fields from each record, e.g. auditd
+	// key=value pairs or journal fields
+	Fields       []string
+	RecordFields bool
+}
+
+// entryFields are available on every target.
+var entryFields = []string{"message", "hostname", "program", "pid", "timestamp"}
+
+// Targets lists the parser targets in the order they are reported.
+var Targets = []Target{
+	{
+		Name:      "syslog",
+		Logsource: LogSource{Category: "process", Product: "linux", Service: "syslog"},
+		Mappings: map[string]string{
+			"Message":     "message",
+			"Hostname":    "hostname",
+			"Computer":    "hostname",
+			"ProcessName": "program",
+			"ProcessId":   "pid",
+		},
+		Fields: entryFields,
+	},
+	{
+		Name:      "journald",
+		Logsource: LogSource{Category: "process", Product: "linux", Service: "journald"},
+		Mappings: map[string]string{
+			"Message":           "message",
+			"MESSAGE":           "message",
+			"Hostname":          "hostname",
+			"Computer":          "hostname",
+			"_HOSTNAME":         "hostname",
+			"ProcessName":       "program",
+			"SYSLOG_IDENTIFIER": "program",
+			"_COMM":             "program",
+			"ProcessId":         "pid",
+			"_PID":              "pid",
+			"Image":             "_EXE",
+			"CommandLine":       "_CMDLINE",
+		},
+		Fields:       entryFields,
+		RecordFields: true,
+	},
+	{
+		Name:      "auditd",
+		Logsource: LogSource{Category: "audit", Product: "linux", Service: "auditd"},
+		Mappings: map[string]string{
+			"Image":            "exe",
+			"CommandLine":      "cmdline",
+			"ProcessId":        "pid",
+			"ParentProcessId":  "ppid",
+			"User":             "auid",
+			"CurrentDirectory": "cwd",
+			"TargetFilename":   "name",
+		},
+		Fields:       entryFields,
+		RecordFields: true,
+	},
+}
+
+// LookupTarget returns the target with the given name.
+func LookupTarget(name string) (Target, bool) {
+	for _, target := range Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// MapField returns the entry field holding a Sigma field on this target.
+func (t Target) MapField(field string) string {
+	if mapped, ok := t.Mappings[field]; ok {
+		return mapped
+	}
+	return field
+}
+
+// Supports reports whether entries from this target can carry a value for
+// the Sigma field. Targets with record fields may carry any field their
+// records contain, such as auditd's lowercase keys or journal's uppercase
+// ones, so only fields that look like Sigma's CamelCase names without a
+// mapping are reported as unsupported there.
+func (t Target) Supports(field string) bool {
+	mapped := t.MapField(field)
+	for _, name := range t.Fields {
+		if name == mapped {
+			return true
+		}
+	}
+
+	if t.RecordFields {
+		_, ok := t.Mappings[field]
+		return ok || strings.ToLower(field) == field || strings.ToUpper(field) == field
+	}
+
+	return false
+}
+
+// Accepts reports whether a rule's logsource selects entries from this target.
+func (t Target) Accepts(logSource LogSource) bool {
+	if logSource.Category != "" && logSource.Category != t.Logsource.Category {
+		return false
+	}
+	if logSource.Product != "" && logSource.Product != t.Logsource.Product {
+		return false
+	}
+	if logSource.Service != "" && logSource.Service != t.Logsource.Service {
+		return false
+	}
+	return true
+}
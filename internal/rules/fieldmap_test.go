@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+func TestTarget_JournaldMappings(t *testing.T) {
+	target, ok := LookupTarget("journald")
+	if !ok {
+		t.Fatal("Expected a journald target")
+	}
+
+	mappings := map[string]string{
+		"SYSLOG_IDENTIFIER": "program",
+		"_COMM":             "program",
+		"_PID":              "pid",
+		"Image":             "_EXE",
+		"CommandLine":       "_CMDLINE",
+	}
+	for field, want := range mappings {
+		if got := target.MapField(field); got != want {
+			t.Errorf("MapField(%s) = %s, want %s", field, got, want)
+		}
+		if !target.Supports(field) {
+			t.Errorf("Expected journald to support %s", field)
+		}
+	}
+}
+
+func TestEngine_EvaluateJournaldFields(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "journald.yml", `title: Journald Process
+id: journald-process
+logsource:
+    product: linux
+    service: journald
+detection:
+    selection:
+        SYSLOG_IDENTIFIER: 'sshd'
+        _PID: '4242'
+        Image|endswith: '/sshd'
+        CommandLine|contains: '-D'
+    condition: selection`)
+
+	engine := &Engine{rules: make([]Rule, 0)}
+	if err := engine.loadRulesFromDir(dir); err != nil {
+		t.Fatalf("loadRulesFromDir() error = %v", err)
+	}
+
+	// The journal parser fills in program and pid from SYSLOG_IDENTIFIER,
+	// falling back to _COMM, and _PID
+	match := parser.LogEntry{
+		Program: "sshd",
+		PID:     "4242",
+		Product: "linux",
+		Service: "journald",
+		Fields:  map[string]string{"_EXE": "/usr/sbin/sshd", "_CMDLINE": "sshd: /usr/sbin/sshd -D"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(entry *parser.LogEntry)
+		want   bool
+	}{
+		{name: "all fields match", modify: func(entry *parser.LogEntry) {}, want: true},
+		{name: "other program", modify: func(entry *parser.LogEntry) { entry.Program = "cron" }, want: false},
+		{name: "other pid", modify: func(entry *parser.LogEntry) { entry.PID = "1" }, want: false},
+		{name: "other executable", modify: func(entry *parser.LogEntry) { entry.Fields["_EXE"] = "/usr/bin/python3" }, want: false},
+		{name: "other command line", modify: func(entry *parser.LogEntry) { entry.Fields["_CMDLINE"] = "sshd: admin" }, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := match
+			entry.Fields = map[string]string{"_EXE": match.Fields["_EXE"], "_CMDLINE": match.Fields["_CMDLINE"]}
+			tt.modify(&entry)
+			if got := len(engine.Evaluate(entry)) == 1; got != tt.want {
+				t.Errorf("Evaluate() matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,20 @@ package rules
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-yaml/yaml"
 )
 
 type RuleManager struct {
 	rulesDir string
+	strict   bool
+
+	githubToken  string
+	githubAPIURL string
 }
 
 type RuleSource struct {
@@ -19,10 +25,22 @@ type RuleSource struct {
 	Branch      string `yaml:"branch"`
 	Description string `yaml:"description"`
 	Enabled     bool   `yaml:"enabled"`
+
+	// Strategy is "archive" (default) to download the repository archive,
+	// or "api" to fetch only the rule paths through the GitHub API
+	Strategy string   `yaml:"strategy,omitempty"`
+	Paths    []string `yaml:"paths,omitempty"`
+
+	// Optional verification of downloaded archives
+	SHA256       string `yaml:"sha256,omitempty"`
+	ChecksumURL  string `yaml:"checksum_url,omitempty"`
+	PublicKey    string `yaml:"public_key,omitempty"`
+	SignatureURL string `yaml:"signature_url,omitempty"`
 }
 
 type RuleConfig struct {
-	Sources []RuleSource `yaml:"sources"`
+	Sources      []RuleSource `yaml:"sources"`
+	KeepVersions int          `yaml:"keep_versions,omitempty"`
 }
 
 func NewRuleManager(rulesDir string) *RuleManager {
@@ -31,6 +49,17 @@ func NewRuleManager(rulesDir string) *RuleManager {
 	}
 }
 
+// SetStrict makes downloads fail for sources that can't be verified against a
+// checksum or signature.
+func (rm *RuleManager) SetStrict(strict bool) {
+	rm.strict = strict
+}
+
+// SetGitHubToken sets the token used to authenticate GitHub API downloads.
+func (rm *RuleManager) SetGitHubToken(token string) {
+	rm.githubToken = token
+}
+
 func (rm *RuleManager) Initialize() error {
 	// Create rules directory structure
 	dirs := []string{
@@ -138,46 +167,144 @@ func (rm *RuleManager) DownloadRules(sourceName string) error {
 }
 
 func (rm *RuleManager) downloadSource(source *RuleSource) error {
-	// Create target directory
-	targetDir := filepath.Join(rm.rulesDir, "external", strings.ToLower(source.Name))
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create target directory: %w", err)
-	}
-
-	// Download rules based on source type
-	switch strings.ToLower(source.Name) {
-	case "chopchopgo":
-		return rm.downloadChopChopGoRules(targetDir, source)
-	case "sigmahq":
-		return rm.downloadSigmaHQRules(targetDir, source)
+	config, err := rm.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Stage the download next to the live rules so the final swap is a rename
+	stagingDir, err := rm.newStagingDir(source.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	// Rule paths configured for the source, or known ones for built-in sources
+	rulePaths := source.Paths
+	if len(rulePaths) == 0 {
+		switch strings.ToLower(source.Name) {
+		case "chopchopgo":
+			rulePaths = chopChopGoRulePaths
+		case "sigmahq":
+			rulePaths = sigmaHQRulePaths
+		default:
+			return fmt.Errorf("unsupported source: %s (set paths in sources.yml)", source.Name)
+		}
+	}
+
+	var verification Verification
+	switch source.Strategy {
+	case "", "archive":
+		verification, err = rm.downloadArchive(source, stagingDir, rulePaths)
+	case "api":
+		verification, err = rm.downloadWithAPI(source, stagingDir, rulePaths)
 	default:
-		return fmt.Errorf("unsupported source: %s", source.Name)
+		err = fmt.Errorf("unknown download strategy %q for %s", source.Strategy, source.Name)
+	}
+	if err != nil {
+		return err
 	}
-}
 
-func (rm *RuleManager) downloadChopChopGoRules(targetDir string, source *RuleSource) error {
-	// ChopChopGo specific rule paths
-	rulePaths := []string{
-		"rules/linux/builtin/syslog",
-		"rules/linux/builtin/journald",
-		"rules/linux/builtin/auditd",
+	// Refuse to replace working rules with a download the loader can't use
+	if _, err := validateStagedRules(stagingDir); err != nil {
+		return fmt.Errorf("downloaded rules for %s failed validation: %w", source.Name, err)
 	}
 
+	if err := writeVerification(stagingDir, verification); err != nil {
+		return err
+	}
+
+	return rm.installStaged(source.Name, stagingDir, config.keepVersions())
+}
+
+func (rm *RuleManager) downloadArchive(source *RuleSource, stagingDir string, rulePaths []string) (Verification, error) {
 	downloader := NewGitHubDownloader()
-	return downloader.DownloadRepositoryRules(source.URL, source.Branch, targetDir, rulePaths)
+	archiveURL := downloader.getArchiveURL(source.URL, source.Branch)
+	archiveData, err := downloader.downloadArchive(archiveURL)
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	verification, err := rm.verifySource(downloader, source, archiveURL, archiveData)
+	if err != nil {
+		return verification, fmt.Errorf("failed to verify %s: %w", source.Name, err)
+	}
+	if rm.strict && !verification.Verified {
+		return verification, fmt.Errorf("refusing unverified source %s: configure sha256 or public_key (a checksum_url only shows the download is intact), or disable strict rule verification", source.Name)
+	}
+
+	return verification, downloader.extractRules(archiveData, stagingDir, rulePaths)
 }
 
-func (rm *RuleManager) downloadSigmaHQRules(targetDir string, source *RuleSource) error {
-	// SigmaHQ specific rule paths for Linux
-	rulePaths := []string{
-		"rules/linux",
-		"rules/linux/auditd",
-		"rules/linux/systemd",
-		"rules/linux/rsyslog",
+// downloadWithAPI fetches only the selected rule paths through the GitHub API.
+// Files are fetched one by one, so there is no archive to check against a
+// checksum or signature.
+func (rm *RuleManager) downloadWithAPI(source *RuleSource, stagingDir string, rulePaths []string) (Verification, error) {
+	verification := Verification{
+		Source:     source.Name,
+		Checksum:   "none",
+		Signature:  "none",
+		VerifiedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if rm.strict {
+		return verification, fmt.Errorf("refusing source %s: the api strategy can't be verified while strict rule verification is enabled", source.Name)
 	}
 
-	downloader := NewGitHubDownloader()
-	return downloader.DownloadRepositoryRules(source.URL, source.Branch, targetDir, rulePaths)
+	downloader := NewGitHubAPIDownloader(rm.githubToken)
+	if rm.githubAPIURL != "" {
+		downloader.baseURL = rm.githubAPIURL
+	}
+
+	err := downloader.DownloadRepositoryRules(source.URL, source.Branch, stagingDir, rulePaths, rm.sourceDir(source.Name))
+	return verification, err
+}
+
+// ChopChopGo specific rule paths
+var chopChopGoRulePaths = []string{
+	"rules/linux/builtin/syslog",
+	"rules/linux/builtin/journald",
+	"rules/linux/builtin/auditd",
+}
+
+// SigmaHQ specific rule paths for Linux
+var sigmaHQRulePaths = []string{
+	"rules/linux",
+	"rules/linux/auditd",
+	"rules/linux/systemd",
+	"rules/linux/rsyslog",
+}
+
+func (rm *RuleManager) verifySource(downloader *GitHubDownloader, source *RuleSource, archiveURL string, archiveData []byte) (Verification, error) {
+	var checksumFile string
+	if source.ChecksumURL != "" {
+		data, err := downloader.downloadArchive(source.ChecksumURL)
+		if err != nil {
+			return Verification{}, fmt.Errorf("failed to download checksum file: %w", err)
+		}
+		checksumFile = string(data)
+	}
+
+	var signature []byte
+	if source.PublicKey != "" {
+		signatureURL := source.SignatureURL
+		if signatureURL == "" {
+			signatureURL = archiveURL + ".minisig"
+		}
+
+		data, err := downloader.downloadArchive(signatureURL)
+		if err != nil {
+			return Verification{}, fmt.Errorf("failed to download signature: %w", err)
+		}
+		signature = data
+	}
+
+	verification, err := VerifyArchive(*source, path.Base(archiveURL), archiveData, checksumFile, signature)
+	if err != nil {
+		return verification, err
+	}
+	verification.ArchiveURL = archiveURL
+
+	return verification, nil
 }
 
 func (rm *RuleManager) ListSources() ([]RuleSource, error) {
@@ -0,0 +1,330 @@
+package rules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testManagerRule = `title: Test Rule
+id: %s
+status: experimental
+level: low
+logsource:
+    product: linux
+    service: syslog
+detection:
+    selection:
+        message:
+            - 'test message'
+    condition: selection`
+
+func buildRuleArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	for name, content := range files {
+		header := &tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(content)),
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write tar content: %v", err)
+		}
+	}
+
+	tarWriter.Close()
+	gzWriter.Close()
+	return buf.Bytes()
+}
+
+func ruleContent(id string) string {
+	return fmt.Sprintf(testManagerRule, id)
+}
+
+// newTestManager returns a manager whose ChopChopGo source points at a fake
+// server serving whatever archive is currently in *archive.
+func newTestManager(t *testing.T, archive *[]byte) *RuleManager {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *archive == nil {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Write(*archive)
+	}))
+	t.Cleanup(server.Close)
+
+	rm := NewRuleManager(filepath.Join(t.TempDir(), "rules"))
+	if err := rm.Initialize(); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	config, err := rm.loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	config.Sources[0].URL = server.URL
+	if err := rm.saveConfig(config); err != nil {
+		t.Fatalf("saveConfig() error = %v", err)
+	}
+
+	return rm
+}
+
+func TestRuleManager_DownloadReplacesRules(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/keep.yml": ruleContent("keep-rule"),
+		"repo-master/rules/linux/builtin/syslog/gone.yml": ruleContent("gone-rule"),
+	})
+	rm := newTestManager(t, &archive)
+
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	ruleDir := filepath.Join(rm.sourceDir("ChopChopGo"), "repo-master", "rules", "linux", "builtin", "syslog")
+	if _, err := os.Stat(filepath.Join(ruleDir, "gone.yml")); err != nil {
+		t.Fatalf("Expected gone.yml after first download: %v", err)
+	}
+
+	// Upstream deletes a rule
+	archive = buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/keep.yml": ruleContent("keep-rule"),
+	})
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(ruleDir, "gone.yml")); !os.IsNotExist(err) {
+		t.Error("Expected gone.yml to be removed after upstream deletion")
+	}
+
+	versions, err := rm.ListVersions("ChopChopGo")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 kept version, got %d", len(versions))
+	}
+
+	// Kept versions must not be loaded as live rules
+	engine, err := NewEngine(rm.rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	for _, rule := range engine.rules {
+		if rule.ID == "gone-rule" {
+			t.Error("Expected rules from kept versions to be ignored by the engine")
+		}
+	}
+}
+
+func TestRuleManager_FailedDownloadKeepsRules(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/keep.yml": ruleContent("keep-rule"),
+	})
+	rm := newTestManager(t, &archive)
+
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{name: "server error", archive: nil},
+		{name: "no valid rules", archive: buildRuleArchive(t, map[string]string{
+			"repo-master/rules/linux/builtin/syslog/broken.yml": "title: [unclosed",
+		})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive = tt.archive
+			if err := rm.DownloadRules("ChopChopGo"); err == nil {
+				t.Fatal("Expected DownloadRules() to fail")
+			}
+
+			keep := filepath.Join(rm.sourceDir("ChopChopGo"), "repo-master", "rules", "linux", "builtin", "syslog", "keep.yml")
+			if _, err := os.Stat(keep); err != nil {
+				t.Errorf("Expected live rules to be untouched: %v", err)
+			}
+
+			staged, _ := os.ReadDir(filepath.Join(rm.rulesDir, ".staging"))
+			if len(staged) != 0 {
+				t.Errorf("Expected staging directory to be cleaned up, found %d entries", len(staged))
+			}
+		})
+	}
+}
+
+func TestRuleManager_Rollback(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/v1.yml": ruleContent("v1-rule"),
+	})
+	rm := newTestManager(t, &archive)
+
+	if _, err := rm.Rollback("ChopChopGo", ""); err == nil {
+		t.Error("Expected Rollback() to fail with no kept versions")
+	}
+
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	archive = buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/v2.yml": ruleContent("v2-rule"),
+	})
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	if _, err := rm.Rollback("ChopChopGo", ""); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	ruleDir := filepath.Join(rm.sourceDir("ChopChopGo"), "repo-master", "rules", "linux", "builtin", "syslog")
+	if _, err := os.Stat(filepath.Join(ruleDir, "v1.yml")); err != nil {
+		t.Errorf("Expected v1.yml to be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ruleDir, "v2.yml")); !os.IsNotExist(err) {
+		t.Error("Expected v2.yml to be replaced by the rollback")
+	}
+
+	// The replaced rules are kept, so rolling back again restores them
+	versions, err := rm.ListVersions("ChopChopGo")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 kept version after rollback, got %d", len(versions))
+	}
+}
+
+func TestRuleManager_SwapKeepsRulesPresent(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/v1.yml": ruleContent("v1-rule"),
+	})
+	rm := newTestManager(t, &archive)
+
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	probe := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(probe, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(probe, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchangeDirs(filepath.Join(probe, "a"), filepath.Join(probe, "b")); err != nil {
+		t.Skipf("Directory exchange not available here: %v", err)
+	}
+
+	// Check the live rules after every filesystem step of each swap
+	steps := 0
+	checkLive := func(op string) {
+		steps++
+		if _, err := validateStagedRules(rm.sourceDir("ChopChopGo")); err != nil {
+			t.Errorf("Live rules missing after %s (step %d): %v", op, steps, err)
+		}
+	}
+
+	origRename, origExchange := renameDir, exchangeDir
+	t.Cleanup(func() { renameDir, exchangeDir = origRename, origExchange })
+	renameDir = func(oldpath, newpath string) error {
+		err := origRename(oldpath, newpath)
+		checkLive("rename")
+		return err
+	}
+	exchangeDir = func(a, b string) error {
+		err := origExchange(a, b)
+		checkLive("exchange")
+		return err
+	}
+
+	archive = buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/v2.yml": ruleContent("v2-rule"),
+	})
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	if _, err := rm.Rollback("ChopChopGo", ""); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	if steps == 0 {
+		t.Fatal("Expected swaps to go through the checked operations")
+	}
+
+	ruleDir := filepath.Join(rm.sourceDir("ChopChopGo"), "repo-master", "rules", "linux", "builtin", "syslog")
+	if _, err := os.Stat(filepath.Join(ruleDir, "v1.yml")); err != nil {
+		t.Errorf("Expected v1.yml to be restored: %v", err)
+	}
+}
+
+func TestRestoreRetired(t *testing.T) {
+	tmpDir := t.TempDir()
+	swapErr := fmt.Errorf("failed to install rules for test")
+
+	backupDir := filepath.Join(tmpDir, "backup")
+	targetDir := filepath.Join(tmpDir, "target")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		t.Fatalf("Failed to create backup directory: %v", err)
+	}
+
+	if err := restoreRetired(backupDir, targetDir, swapErr); !errors.Is(err, swapErr) {
+		t.Errorf("restoreRetired() error = %v, want %v", err, swapErr)
+	}
+	if _, err := os.Stat(targetDir); err != nil {
+		t.Errorf("Expected the backup to be moved back: %v", err)
+	}
+
+	// A failed restore reports where the previous rules were left
+	missing := filepath.Join(tmpDir, "missing")
+	err := restoreRetired(missing, targetDir, swapErr)
+	if !errors.Is(err, swapErr) || !strings.Contains(err.Error(), missing) {
+		t.Errorf("restoreRetired() error = %v, want it to wrap the swap error and name %s", err, missing)
+	}
+}
+
+func TestRuleManager_PruneVersions(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/rule.yml": ruleContent("rule"),
+	})
+	rm := newTestManager(t, &archive)
+
+	for i := 0; i < defaultKeepVersions+3; i++ {
+		if err := rm.DownloadRules("ChopChopGo"); err != nil {
+			t.Fatalf("DownloadRules() error = %v", err)
+		}
+	}
+
+	versions, err := rm.ListVersions("ChopChopGo")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions) != defaultKeepVersions {
+		t.Errorf("Expected %d kept versions, got %d", defaultKeepVersions, len(versions))
+	}
+}
@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"sync"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+// evaluateBatchSize is how many entries a worker evaluates per hand-off.
+const evaluateBatchSize = 64
+
+type evaluateBatch struct {
+	seq     int
+	entries []parser.LogEntry
+	// indices are the input positions of entries, once evaluated.
+	indices []int
+}
+
+// EvaluateStream evaluates entries from in across threads workers and calls
+// emit on the calling goroutine for every matching entry, with MatchedRules
+// set, in input order. It returns the number of entries evaluated once in is
+// closed. Rules are read-only after loading, so workers share the engine.
+func (e *Engine) EvaluateStream(in <-chan parser.LogEntry, threads int, emit func(parser.LogEntry)) int {
+	return e.EvaluateStreamIndexed(in, threads, func(_ int, entry parser.LogEntry) {
+		emit(entry)
+	})
+}
+
+// EvaluateEach evaluates entries from in one at a time as they arrive, on the
+// calling goroutine, calling emit for every matching entry straight away. It
+// suits live sources, where no detection should wait for a batch to fill, and
+// returns the number of entries evaluated once in is closed.
+func (e *Engine) EvaluateEach(in <-chan parser.LogEntry, emit func(parser.LogEntry)) int {
+	return e.evaluateEach(in, func(_ int, entry parser.LogEntry) {
+		emit(entry)
+	})
+}
+
+func (e *Engine) evaluateEach(in <-chan parser.LogEntry, emit func(index int, entry parser.LogEntry)) int {
+	processed := 0
+	for entry := range in {
+		processed++
+		if matches := e.Evaluate(entry); len(matches) > 0 {
+			entry.MatchedRules = matches
+			emit(processed-1, entry)
+		}
+	}
+	return processed
+}
+
+// EvaluateStreamIndexed is EvaluateStream, also passing emit the position of
+// each matching entry in the input, counting from 0.
+func (e *Engine) EvaluateStreamIndexed(in <-chan parser.LogEntry, threads int, emit func(index int, entry parser.LogEntry)) int {
+	if threads <= 1 {
+		return e.evaluateEach(in, emit)
+	}
+
+	jobs := make(chan evaluateBatch, threads)
+	results := make(chan evaluateBatch, threads)
+
+	// Limits batches in flight, so a slow batch can't let finished ones
+	// pile up waiting to be emitted in order
+	inFlight := make(chan struct{}, threads*4)
+
+	// Batch entries in input order
+	processed := 0
+	go func() {
+		defer close(jobs)
+
+		seq := 0
+		batch := make([]parser.LogEntry, 0, evaluateBatchSize)
+		flush := func() {
+			inFlight <- struct{}{}
+			jobs <- evaluateBatch{seq: seq, entries: batch}
+			seq++
+			batch = make([]parser.LogEntry, 0, evaluateBatchSize)
+		}
+
+		for entry := range in {
+			processed++
+			batch = append(batch, entry)
+			if len(batch) == evaluateBatchSize {
+				flush()
+			}
+		}
+		if len(batch) > 0 {
+			flush()
+		}
+	}()
+
+	// Evaluate batches, keeping only matching entries and where they were
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				matched := batch.entries[:0]
+				var indices []int
+				for i, entry := range batch.entries {
+					if matches := e.Evaluate(entry); len(matches) > 0 {
+						entry.MatchedRules = matches
+						matched = append(matched, entry)
+						indices = append(indices, batch.seq*evaluateBatchSize+i)
+					}
+				}
+				results <- evaluateBatch{seq: batch.seq, entries: matched, indices: indices}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Reassemble batches in input order
+	pending := make(map[int]evaluateBatch)
+	next := 0
+	for batch := range results {
+		pending[batch.seq] = batch
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-inFlight
+
+			for i, entry := range ready.entries {
+				emit(ready.indices[i], entry)
+			}
+		}
+	}
+
+	return processed
+}
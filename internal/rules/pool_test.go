@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wellknittech/hayanix/internal/parser"
+)
+
+func TestEngine_EvaluateStream(t *testing.T) {
+	rulesDir := t.TempDir()
+	writeRuleFile(t, rulesDir, "failed.yml", `title: Failed Login
+id: failed-login
+logsource:
+    product: linux
+    service: syslog
+detection:
+    keywords:
+        - 'failed password'
+    condition: keywords`)
+
+	engine, err := NewEngine(rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	const total = 1000
+	for _, threads := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("%d threads", threads), func(t *testing.T) {
+			in := make(chan parser.LogEntry)
+			go func() {
+				defer close(in)
+				for i := 0; i < total; i++ {
+					message := fmt.Sprintf("accepted password %d", i)
+					if i%3 == 0 {
+						message = fmt.Sprintf("failed password %d", i)
+					}
+					in <- parser.LogEntry{Message: message, Product: "linux", Service: "syslog"}
+				}
+			}()
+
+			var matched []parser.LogEntry
+			var indices []int
+			processed := engine.EvaluateStreamIndexed(in, threads, func(index int, entry parser.LogEntry) {
+				matched = append(matched, entry)
+				indices = append(indices, index)
+			})
+
+			if processed != total {
+				t.Errorf("Expected %d entries processed, got %d", total, processed)
+			}
+			if len(matched) != (total+2)/3 {
+				t.Fatalf("Expected %d matches, got %d", (total+2)/3, len(matched))
+			}
+			for i, entry := range matched {
+				want := fmt.Sprintf("failed password %d", i*3)
+				if entry.Message != want {
+					t.Fatalf("Match %d out of order: got %q, want %q", i, entry.Message, want)
+				}
+				if indices[i] != i*3 {
+					t.Errorf("Match %d reported at index %d, expected %d", i, indices[i], i*3)
+				}
+				if len(entry.MatchedRules) != 1 || entry.MatchedRules[0] != "failed-login" {
+					t.Errorf("Expected MatchedRules [failed-login], got %v", entry.MatchedRules)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateEach(t *testing.T) {
+	rulesDir := t.TempDir()
+	writeRuleFile(t, rulesDir, "failed.yml", `title: Failed Login
+id: failed-login
+logsource:
+    product: linux
+detection:
+    keywords:
+        - 'failed password'
+    condition: keywords`)
+
+	engine, err := NewEngine(rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// Each detection is emitted before the next entry arrives
+	in := make(chan parser.LogEntry)
+	emitted := make(chan parser.LogEntry)
+	done := make(chan int)
+	go func() {
+		done <- engine.EvaluateEach(in, func(entry parser.LogEntry) {
+			emitted <- entry
+		})
+	}()
+
+	for i := 0; i < 3; i++ {
+		in <- parser.LogEntry{Message: "accepted password", Product: "linux"}
+		in <- parser.LogEntry{Message: fmt.Sprintf("failed password %d", i), Product: "linux"}
+		select {
+		case entry := <-emitted:
+			if want := fmt.Sprintf("failed password %d", i); entry.Message != want {
+				t.Errorf("Expected %q, got %q", want, entry.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Detection %d wasn't emitted until more entries arrived", i)
+		}
+	}
+
+	close(in)
+	if processed := <-done; processed != 6 {
+		t.Errorf("Expected 6 entries processed, got %d", processed)
+	}
+}
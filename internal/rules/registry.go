@@ -0,0 +1,156 @@
+package rules
+
+import (
+	"sort"
+	"strings"
+)
+
+// Filter selects rules from the loaded registry. Empty fields match any rule.
+type Filter struct {
+	Text      string // title or description contains
+	Tag       string // a tag contains, e.g. "t1059" or "attack.execution"
+	Level     string
+	Status    string
+	Logsource string // product, category or service
+	Author    string // author contains
+	Source    string
+}
+
+// Rules returns the loaded rules, one per rule ID.
+func (e *Engine) Rules() []Rule {
+	return e.rules
+}
+
+// Subset returns an engine evaluating only the loaded rules with the given
+// IDs.
+func (e *Engine) Subset(ids []string) *Engine {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	subset := &Engine{rulesDir: e.rulesDir, ruleIDs: make(map[string]bool), regexes: e.regexes}
+	for _, rule := range e.rules {
+		if wanted[rule.ID] {
+			subset.rules = append(subset.rules, rule)
+			subset.ruleIDs[rule.ID] = true
+		}
+	}
+	return subset
+}
+
+// FindRule returns the loaded rule with the given ID.
+func (e *Engine) FindRule(id string) (Rule, bool) {
+	for _, rule := range e.rules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Search returns the loaded rules matching the filter, sorted by title.
+func (e *Engine) Search(filter Filter) []Rule {
+	var found []Rule
+	for _, rule := range e.rules {
+		if filter.Matches(rule) {
+			found = append(found, rule)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return strings.ToLower(found[i].Title) < strings.ToLower(found[j].Title)
+	})
+
+	return found
+}
+
+// Matches reports whether a rule passes the filter. Comparisons ignore case.
+func (f Filter) Matches(rule Rule) bool {
+	if f.Text != "" && !containsFold(rule.Title, f.Text) && !containsFold(rule.Description, f.Text) {
+		return false
+	}
+
+	if f.Tag != "" {
+		found := false
+		for _, tag := range rule.Tags {
+			if containsFold(tag, f.Tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Level != "" && !strings.EqualFold(rule.Level, f.Level) {
+		return false
+	}
+	if f.Status != "" && !strings.EqualFold(rule.Status, f.Status) {
+		return false
+	}
+
+	if f.Logsource != "" &&
+		!strings.EqualFold(rule.Logsource.Product, f.Logsource) &&
+		!strings.EqualFold(rule.Logsource.Category, f.Logsource) &&
+		!strings.EqualFold(rule.Logsource.Service, f.Logsource) {
+		return false
+	}
+
+	if f.Author != "" && !containsFold(rule.Author, f.Author) {
+		return false
+	}
+	if f.Source != "" && !strings.EqualFold(rule.Source, f.Source) {
+		return false
+	}
+
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// Condition returns the rule's compiled detection condition.
+func (r Rule) Condition() string {
+	if r.condition == nil {
+		return ""
+	}
+	return r.condition.String()
+}
+
+// DetectionFields returns the fields the rule's detection reads, without
+// modifiers, in sorted order. Keyword searches read the message and are not
+// listed.
+func (r Rule) DetectionFields() []string {
+	seen := make(map[string]bool)
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key := range v {
+				name, _ := splitFieldModifiers(key)
+				seen[name] = true
+			}
+		case []interface{}:
+			for _, item := range v {
+				if _, ok := item.(map[string]interface{}); ok {
+					collect(item)
+				}
+			}
+		}
+	}
+
+	for _, identifier := range detectionIdentifiers(r.Detection) {
+		collect(r.Detection[identifier])
+	}
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
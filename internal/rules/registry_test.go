@@ -0,0 +1,103 @@
+package rules
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEngine_Search(t *testing.T) {
+	rulesDir := t.TempDir()
+
+	writeRuleFile(t, filepath.Join(rulesDir, "linux", "syslog"), "local.yml", `title: Local SSH Brute Force
+id: local-ssh
+status: stable
+description: Repeated failed SSH logins
+author: Blue Team
+tags:
+    - attack.credential_access
+    - attack.t1110
+level: high
+logsource:
+    product: linux
+    service: syslog
+detection:
+    selection:
+        message: 'Failed password'
+    condition: selection`)
+
+	sigmaDir := filepath.Join(rulesDir, "external", "sigmahq", "sigma-master", "rules", "linux")
+	writeRuleFile(t, sigmaDir, "download.yml", `title: Curl Download
+id: sigma-curl
+status: test
+description: Downloads with curl
+author: Sigma Author
+tags:
+    - attack.command_and_control
+level: medium
+logsource:
+    product: linux
+    category: process_creation
+detection:
+    selection:
+        Image|endswith: '/curl'
+    condition: selection`)
+
+	// A second copy of the same rule is only loaded once
+	writeRuleFile(t, filepath.Join(rulesDir, "linux", "auditd"), "download.yml", `title: Curl Download
+id: sigma-curl
+detection:
+    selection:
+        Image|endswith: '/curl'
+    condition: selection`)
+
+	engine, err := NewEngine(rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if len(engine.Rules()) != 2 {
+		t.Fatalf("Expected 2 deduplicated rules, got %d", len(engine.Rules()))
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "everything", filter: Filter{}, want: []string{"sigma-curl", "local-ssh"}},
+		{name: "text in description", filter: Filter{Text: "failed ssh"}, want: []string{"local-ssh"}},
+		{name: "tag", filter: Filter{Tag: "T1110"}, want: []string{"local-ssh"}},
+		{name: "level", filter: Filter{Level: "medium"}, want: []string{"sigma-curl"}},
+		{name: "status", filter: Filter{Status: "stable"}, want: []string{"local-ssh"}},
+		{name: "logsource category", filter: Filter{Logsource: "process_creation"}, want: []string{"sigma-curl"}},
+		{name: "logsource product", filter: Filter{Logsource: "linux"}, want: []string{"sigma-curl", "local-ssh"}},
+		{name: "author", filter: Filter{Author: "blue"}, want: []string{"local-ssh"}},
+		{name: "source", filter: Filter{Source: "sigmahq"}, want: []string{"sigma-curl"}},
+		{name: "local source", filter: Filter{Source: "local"}, want: []string{"local-ssh"}},
+		{name: "no match", filter: Filter{Text: "kerberos"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := engine.Search(tt.filter)
+			if len(found) != len(tt.want) {
+				t.Fatalf("Search() returned %d rules, want %d", len(found), len(tt.want))
+			}
+			for i, rule := range found {
+				if rule.ID != tt.want[i] {
+					t.Errorf("Search()[%d] = %s, want %s", i, rule.ID, tt.want[i])
+				}
+			}
+		})
+	}
+
+	rule, ok := engine.FindRule("sigma-curl")
+	if !ok {
+		t.Fatal("Expected FindRule to find sigma-curl")
+	}
+	if rule.Path != filepath.Join(sigmaDir, "download.yml") {
+		t.Errorf("Expected path %s, got %s", filepath.Join(sigmaDir, "download.yml"), rule.Path)
+	}
+	if fields := rule.DetectionFields(); len(fields) != 1 || fields[0] != "Image" {
+		t.Errorf("Expected detection fields [Image], got %v", fields)
+	}
+}
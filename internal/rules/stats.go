@@ -0,0 +1,180 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// attackTactics are the ATT&CK tactic names used in Sigma "attack.*" tags.
+var attackTactics = map[string]bool{
+	"reconnaissance":       true,
+	"resource_development": true,
+	"initial_access":       true,
+	"execution":            true,
+	"persistence":          true,
+	"privilege_escalation": true,
+	"defense_evasion":      true,
+	"credential_access":    true,
+	"discovery":            true,
+	"lateral_movement":     true,
+	"collection":           true,
+	"command_and_control":  true,
+	"exfiltration":         true,
+	"impact":               true,
+}
+
+// Stats summarises the loaded rules.
+type Stats struct {
+	Total      int            `json:"total"`
+	ByLevel    map[string]int `json:"by_level"`
+	ByStatus   map[string]int `json:"by_status"`
+	ByProduct  map[string]int `json:"by_product"`
+	ByCategory map[string]int `json:"by_category"`
+	ByService  map[string]int `json:"by_service"`
+	BySource   map[string]int `json:"by_source"`
+	ByTactic   map[string]int `json:"by_tactic"`
+
+	// Rules that load but can't match entries from any parser target
+	Unreachable []UnreachableRule `json:"unreachable"`
+}
+
+// UnreachableRule is a loaded rule that can never fire, and why.
+type UnreachableRule struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Stats counts the loaded rules by level, status, logsource, source and
+// ATT&CK tactic, and lists rules that no parser target can trigger. Rules
+// without a value are counted under "none".
+func (e *Engine) Stats() Stats {
+	stats := Stats{
+		Total:       len(e.rules),
+		ByLevel:     make(map[string]int),
+		ByStatus:    make(map[string]int),
+		ByProduct:   make(map[string]int),
+		ByCategory:  make(map[string]int),
+		ByService:   make(map[string]int),
+		BySource:    make(map[string]int),
+		ByTactic:    make(map[string]int),
+		Unreachable: make([]UnreachableRule, 0),
+	}
+
+	for _, rule := range e.rules {
+		stats.ByLevel[valueOrNone(rule.Level)]++
+		stats.ByStatus[valueOrNone(rule.Status)]++
+		stats.ByProduct[valueOrNone(rule.Logsource.Product)]++
+		stats.ByCategory[valueOrNone(rule.Logsource.Category)]++
+		stats.ByService[valueOrNone(rule.Logsource.Service)]++
+		stats.BySource[valueOrNone(rule.Source)]++
+
+		tactics := ruleTactics(rule)
+		if len(tactics) == 0 {
+			stats.ByTactic["none"]++
+		}
+		for _, tactic := range tactics {
+			stats.ByTactic[tactic]++
+		}
+
+		if reason := unreachableReason(rule); reason != "" {
+			stats.Unreachable = append(stats.Unreachable, UnreachableRule{
+				ID:     rule.ID,
+				Title:  rule.Title,
+				Path:   rule.Path,
+				Reason: reason,
+			})
+		}
+	}
+
+	sort.Slice(stats.Unreachable, func(i, j int) bool {
+		return stats.Unreachable[i].ID < stats.Unreachable[j].ID
+	})
+
+	return stats
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return strings.ToLower(value)
+}
+
+// ruleTactics returns the ATT&CK tactics a rule is tagged with.
+func ruleTactics(rule Rule) []string {
+	seen := make(map[string]bool)
+	var tactics []string
+	for _, tag := range rule.Tags {
+		tactic := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(tag, "attack.")), "-", "_")
+		if strings.HasPrefix(tag, "attack.") && attackTactics[tactic] && !seen[tactic] {
+			seen[tactic] = true
+			tactics = append(tactics, tactic)
+		}
+	}
+	return tactics
+}
+
+// unreachableReason explains why no parser target can trigger the rule, or
+// returns "" when at least one can.
+func unreachableReason(rule Rule) string {
+	if rule.condition == nil {
+		return "condition is not compiled"
+	}
+
+	var accepted []string
+	var gaps []string
+	for _, target := range Targets {
+		if !target.Accepts(rule.Logsource) {
+			continue
+		}
+		accepted = append(accepted, target.Name)
+
+		canBeTrue, _ := rule.condition.outcomes(func(name string) bool {
+			return searchSupported(target, rule.Detection[name])
+		})
+		if canBeTrue {
+			return ""
+		}
+
+		var missing []string
+		for _, field := range rule.DetectionFields() {
+			if !target.Supports(field) {
+				missing = append(missing, field)
+			}
+		}
+		gaps = append(gaps, fmt.Sprintf("%s lacks %s", target.Name, strings.Join(missing, ", ")))
+	}
+
+	if len(accepted) == 0 {
+		return fmt.Sprintf("no parser target produces logsource %s", rule.Logsource.String())
+	}
+	return "field mapping gaps: " + strings.Join(gaps, "; ")
+}
+
+// searchSupported reports whether a search identifier can match entries from
+// the target, i.e. every field it needs is available there.
+func searchSupported(target Target, search interface{}) bool {
+	switch v := search.(type) {
+	case map[string]interface{}:
+		for field, criteria := range v {
+			name, _ := splitFieldModifiers(field)
+			if criteria != nil && !target.Supports(name) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); !ok || searchSupported(target, item) {
+				return true
+			}
+		}
+		return false
+	default:
+		// Keywords are searched for in the message
+		return true
+	}
+}
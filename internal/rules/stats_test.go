@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEngine_Stats(t *testing.T) {
+	rulesDir := t.TempDir()
+
+	writeRuleFile(t, rulesDir, "ssh.yml", `title: SSH Brute Force
+id: ssh
+status: stable
+level: high
+tags:
+    - attack.credential_access
+    - attack.t1110
+logsource:
+    product: linux
+    service: syslog
+detection:
+    selection:
+        message: 'Failed password'
+    filter:
+        CommandLine: 'ignored'
+    condition: selection and not filter`)
+
+	writeRuleFile(t, rulesDir, "curl.yml", `title: Curl Download
+id: curl
+status: test
+level: medium
+tags:
+    - attack.command-and-control
+logsource:
+    product: linux
+    category: process_creation
+detection:
+    selection:
+        Image|endswith: '/curl'
+    condition: selection`)
+
+	writeRuleFile(t, rulesDir, "unit.yml", `title: Unit Changed
+id: unit
+level: low
+logsource:
+    product: linux
+    service: journald
+detection:
+    selection:
+        ParentImage|endswith: '/systemctl'
+    condition: selection`)
+
+	engine, err := NewEngine(rulesDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	stats := engine.Stats()
+	if stats.Total != 3 {
+		t.Errorf("Expected 3 rules, got %d", stats.Total)
+	}
+	if stats.ByLevel["high"] != 1 || stats.ByLevel["medium"] != 1 || stats.ByLevel["low"] != 1 {
+		t.Errorf("Unexpected level counts: %v", stats.ByLevel)
+	}
+	if stats.ByStatus["none"] != 1 {
+		t.Errorf("Expected one rule without a status, got %v", stats.ByStatus)
+	}
+	if stats.ByService["syslog"] != 1 || stats.ByCategory["process_creation"] != 1 {
+		t.Errorf("Unexpected logsource counts: %v %v", stats.ByService, stats.ByCategory)
+	}
+	if stats.BySource["local"] != 3 {
+		t.Errorf("Expected 3 local rules, got %v", stats.BySource)
+	}
+	if stats.ByTactic["credential_access"] != 1 || stats.ByTactic["command_and_control"] != 1 || stats.ByTactic["none"] != 1 {
+		t.Errorf("Unexpected tactic counts: %v", stats.ByTactic)
+	}
+
+	// The ssh rule's unmapped field is only used in a filter, so it can fire
+	if len(stats.Unreachable) != 2 {
+		t.Fatalf("Expected 2 unreachable rules, got %+v", stats.Unreachable)
+	}
+	if stats.Unreachable[0].ID != "curl" || !strings.Contains(stats.Unreachable[0].Reason, "linux/process_creation") {
+		t.Errorf("Expected curl to be unreachable by logsource, got %+v", stats.Unreachable[0])
+	}
+	if stats.Unreachable[1].ID != "unit" || !strings.Contains(stats.Unreachable[1].Reason, "journald lacks ParentImage") {
+		t.Errorf("Expected unit to be unreachable by field mapping, got %+v", stats.Unreachable[1])
+	}
+}
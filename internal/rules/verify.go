@@ -0,0 +1,658 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// verificationFile records how the installed copy of a source was verified.
+// It lives inside the source directory so it is swapped and rolled back
+// together with the rules it describes.
+const verificationFile = ".verification.json"
+
+// digestsFile lists the SHA-256 of every file installed for a source, in
+// sha256sum format, so the installed copy can be checked against what was
+// verified. In rulesDir itself it pins the local rules.
+const digestsFile = ".sha256sums"
+
+// localSource names rules loaded from outside rulesDir/external, which no
+// download verified. They are trusted only once pinned.
+const localSource = "local"
+
+// Verification is the outcome of checking a downloaded rule archive.
+type Verification struct {
+	Source     string `json:"source"`
+	ArchiveURL string `json:"archive_url,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Checksum   string `json:"checksum"`
+	Signature  string `json:"signature"`
+	KeyID      string `json:"key_id,omitempty"`
+	Verified   bool   `json:"verified"`
+	VerifiedAt string `json:"verified_at,omitempty"`
+	// Modified lists installed files that no longer match their digests.
+	Modified []string `json:"modified,omitempty"`
+}
+
+// Status summarises a verification for display.
+func (v Verification) Status() string {
+	if len(v.Modified) > 0 {
+		return "modified"
+	}
+	if v.Verified {
+		return "verified"
+	}
+	if v.Checksum == "published" {
+		return "checksum-only"
+	}
+	return "unverified"
+}
+
+// VerifyArchive checks archive data against the source's pinned SHA-256, a
+// published checksum listing and a detached signature. Only a pinned SHA-256
+// or a signature verifies the archive: a published checksum comes from the
+// same place as the archive, so it shows the download is intact but not that
+// it is genuine. Sources that configure none of these come back unverified
+// rather than failing; a configured check that doesn't pass is an error.
+func VerifyArchive(source RuleSource, archiveName string, data []byte, checksumFile string, signature []byte) (Verification, error) {
+	expectedSHA256 := source.SHA256
+	digest := sha256.Sum256(data)
+	verification := Verification{
+		Source:     source.Name,
+		SHA256:     hex.EncodeToString(digest[:]),
+		Checksum:   "none",
+		Signature:  "none",
+		VerifiedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// Checksum published alongside the archive (sha256sum format)
+	if checksumFile != "" {
+		published, err := findChecksum(checksumFile, archiveName)
+		if err != nil {
+			return verification, err
+		}
+		if expectedSHA256 != "" && !strings.EqualFold(expectedSHA256, published) {
+			return verification, fmt.Errorf("pinned sha256 %s does not match published checksum %s", expectedSHA256, published)
+		}
+		if !strings.EqualFold(published, verification.SHA256) {
+			return verification, fmt.Errorf("sha256 mismatch: published %s, got %s", published, verification.SHA256)
+		}
+		verification.Checksum = "published"
+	}
+
+	// SHA-256 pinned in the source's configuration
+	if expectedSHA256 != "" {
+		if !strings.EqualFold(strings.TrimSpace(expectedSHA256), verification.SHA256) {
+			return verification, fmt.Errorf("sha256 mismatch: expected %s, got %s", expectedSHA256, verification.SHA256)
+		}
+		verification.Checksum = "matched"
+	}
+
+	if source.PublicKey != "" {
+		if signature == nil {
+			return verification, fmt.Errorf("a public key is configured but no signature was found")
+		}
+
+		keyID, err := verifySignature(source.PublicKey, data, signature)
+		if err != nil {
+			return verification, fmt.Errorf("signature verification failed: %w", err)
+		}
+		verification.Signature = "valid"
+		verification.KeyID = keyID
+	}
+
+	verification.Verified = verification.Checksum == "matched" || verification.Signature == "valid"
+	return verification, nil
+}
+
+// findChecksum returns the digest for name from a sha256sum-style listing. A
+// listing with a single digest applies to whatever file it accompanies.
+func findChecksum(listing, name string) (string, error) {
+	var digests []string
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		digest := fields[0]
+		if len(digest) != sha256.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			continue
+		}
+
+		if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[1], "*")) == name {
+			return digest, nil
+		}
+		digests = append(digests, digest)
+	}
+
+	if len(digests) == 1 {
+		return digests[0], nil
+	}
+
+	return "", fmt.Errorf("no sha256 checksum for %s in checksum file", name)
+}
+
+// verifySignature checks a detached signature over data. Keys and signatures
+// may be in minisign format, or a bare base64 ed25519 public key with a raw or
+// base64 64-byte signature. It returns the key ID when one is known.
+func verifySignature(publicKey string, data, signature []byte) (string, error) {
+	keyID, pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Bare ed25519 signature
+	if sig, ok := rawSignature(signature); ok {
+		if !ed25519.Verify(pub, data, sig) {
+			return "", fmt.Errorf("invalid ed25519 signature")
+		}
+		return keyID, nil
+	}
+
+	sig, err := parseMinisignSignature(signature)
+	if err != nil {
+		return "", err
+	}
+
+	if keyID != "" && sig.keyID != keyID {
+		return "", fmt.Errorf("signature was made with key %s, expected %s", sig.keyID, keyID)
+	}
+
+	message := data
+	if sig.prehashed {
+		digest := blake2b.Sum512(data)
+		message = digest[:]
+	}
+	if !ed25519.Verify(pub, message, sig.signature) {
+		return "", fmt.Errorf("invalid minisign signature")
+	}
+
+	// The trusted comment is signed together with the signature itself
+	if sig.globalSignature != nil {
+		global := append(append([]byte{}, sig.signature...), []byte(sig.trustedComment)...)
+		if !ed25519.Verify(pub, global, sig.globalSignature) {
+			return "", fmt.Errorf("invalid minisign trusted comment signature")
+		}
+	}
+
+	return sig.keyID, nil
+}
+
+// parsePublicKey accepts a minisign public key (with or without its untrusted
+// comment line) or a bare base64 ed25519 key.
+func parsePublicKey(publicKey string) (string, ed25519.PublicKey, error) {
+	var encoded string
+	for _, line := range strings.Split(publicKey, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		encoded = line
+		break
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+
+	switch len(raw) {
+	case ed25519.PublicKeySize:
+		return "", ed25519.PublicKey(raw), nil
+	case 2 + 8 + ed25519.PublicKeySize:
+		if string(raw[:2]) != "Ed" {
+			return "", nil, fmt.Errorf("unsupported public key algorithm %q", raw[:2])
+		}
+		return minisignKeyID(raw[2:10]), ed25519.PublicKey(raw[10:]), nil
+	default:
+		return "", nil, fmt.Errorf("invalid public key length %d", len(raw))
+	}
+}
+
+type minisignSignature struct {
+	keyID           string
+	prehashed       bool
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+func parseMinisignSignature(data []byte) (minisignSignature, error) {
+	var sig minisignSignature
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return sig, fmt.Errorf("empty signature")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return sig, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize {
+		return sig, fmt.Errorf("invalid signature length %d", len(raw))
+	}
+
+	switch string(raw[:2]) {
+	case "Ed":
+	case "ED":
+		sig.prehashed = true
+	default:
+		return sig, fmt.Errorf("unsupported signature algorithm %q", raw[:2])
+	}
+	sig.keyID = minisignKeyID(raw[2:10])
+	sig.signature = raw[10:]
+
+	if len(lines) >= 3 && strings.HasPrefix(lines[1], "trusted comment: ") {
+		sig.trustedComment = strings.TrimPrefix(lines[1], "trusted comment: ")
+		global, err := base64.StdEncoding.DecodeString(lines[2])
+		if err != nil || len(global) != ed25519.SignatureSize {
+			return sig, fmt.Errorf("invalid trusted comment signature")
+		}
+		sig.globalSignature = global
+	}
+
+	return sig, nil
+}
+
+func rawSignature(data []byte) ([]byte, bool) {
+	if len(data) == ed25519.SignatureSize {
+		return data, true
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err == nil && len(decoded) == ed25519.SignatureSize {
+		return decoded, true
+	}
+
+	return nil, false
+}
+
+// minisignKeyID formats a key ID the way minisign prints it.
+func minisignKeyID(id []byte) string {
+	reversed := make([]byte, len(id))
+	for i := range id {
+		reversed[i] = id[len(id)-1-i]
+	}
+	return strings.ToUpper(hex.EncodeToString(reversed))
+}
+
+func writeVerification(dir string, verification Verification) error {
+	if err := writeDigests(dir); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(verification, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal verification: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(dir, verificationFile), data, 0644)
+}
+
+// writeDigests records the SHA-256 of every file in a source directory.
+func writeDigests(dir string) error {
+	digests, err := hashSourceFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, digestsFile), formatDigests(digests), 0644); err != nil {
+		return fmt.Errorf("failed to write rule digests: %w", err)
+	}
+	return nil
+}
+
+// formatDigests lists digests in sha256sum format, sorted by path.
+func formatDigests(digests map[string]string) []byte {
+	paths := make([]string, 0, len(digests))
+	for path := range digests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var listing strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&listing, "%s  %s\n", digests[path], path)
+	}
+	return []byte(listing.String())
+}
+
+// hashSourceFiles returns the SHA-256 of every file in a source directory,
+// keyed by slash-separated path, leaving out the verification records.
+func hashSourceFiles(dir string) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == verificationFile || rel == digestsFile {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		digests[rel] = hex.EncodeToString(digest[:])
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash rules in %s: %w", dir, err)
+	}
+
+	return digests, nil
+}
+
+// modifiedFiles re-hashes a source directory against its recorded digests
+// and returns the files that were changed, removed or added since.
+func modifiedFiles(dir string) ([]string, error) {
+	listing, err := os.ReadFile(filepath.Join(dir, digestsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := hashSourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareDigests(listing, current), nil
+}
+
+// compareDigests returns the files whose digests differ from a sha256sum
+// listing, including files only one of them has.
+func compareDigests(listing []byte, current map[string]string) []string {
+	var modified []string
+	recorded := make(map[string]bool)
+	for _, line := range strings.Split(string(listing), "\n") {
+		digest, path, ok := strings.Cut(line, "  ")
+		if !ok {
+			continue
+		}
+		recorded[path] = true
+		if current[path] != digest {
+			modified = append(modified, path)
+		}
+	}
+	for path := range current {
+		if !recorded[path] {
+			modified = append(modified, path)
+		}
+	}
+
+	sort.Strings(modified)
+	return modified
+}
+
+// PinLocalRules records the SHA-256 of every local rule file in rulesDir, so
+// strict rule verification trusts the local rules as they are now. Rules
+// changed, added or removed since are reported as modified.
+func PinLocalRules(rulesDir string) error {
+	digests, err := hashLocalRules(rulesDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(rulesDir, digestsFile), formatDigests(digests), 0644); err != nil {
+		return fmt.Errorf("failed to write pinned rule digests: %w", err)
+	}
+	return nil
+}
+
+// PinSource records the SHA-256 of every file installed for a source, so
+// strict rule verification trusts it as it is now, as for rules installed
+// without a download to verify. Its files are re-hashed against the pinned
+// digests like those of a verified download.
+func (rm *RuleManager) PinSource(sourceName string) error {
+	sourceDir := rm.sourceDir(sourceName)
+	if !hasRuleFiles(sourceDir) {
+		return fmt.Errorf("source %s is not installed", sourceName)
+	}
+
+	return writeVerification(sourceDir, Verification{
+		Source:     strings.ToLower(sourceName),
+		Checksum:   "pinned",
+		Signature:  "none",
+		Verified:   true,
+		VerifiedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// localVerification checks the local rules in rulesDir against the digests
+// pinned for them, if any.
+func localVerification(rulesDir string) (Verification, error) {
+	verification := Verification{
+		Source:    localSource,
+		Checksum:  "none",
+		Signature: "none",
+	}
+
+	listing, err := os.ReadFile(filepath.Join(rulesDir, digestsFile))
+	if os.IsNotExist(err) {
+		return verification, nil
+	}
+	if err != nil {
+		return verification, fmt.Errorf("failed to read pinned rule digests: %w", err)
+	}
+
+	current, err := hashLocalRules(rulesDir)
+	if err != nil {
+		return verification, err
+	}
+	verification.Checksum = "pinned"
+	verification.Modified = compareDigests(listing, current)
+	verification.Verified = len(verification.Modified) == 0
+	return verification, nil
+}
+
+// hashLocalRules returns the SHA-256 of every local rule file in rulesDir,
+// keyed by slash-separated path.
+func hashLocalRules(rulesDir string) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := walkLocalRules(rulesDir, func(path string) error {
+		rel, err := filepath.Rel(rulesDir, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		digests[filepath.ToSlash(rel)] = hex.EncodeToString(digest[:])
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash local rules in %s: %w", rulesDir, err)
+	}
+
+	return digests, nil
+}
+
+// Verifications reports how each installed external source was verified.
+// Sources installed without a record are reported as unverified.
+func (rm *RuleManager) Verifications() ([]Verification, error) {
+	externalDir := filepath.Join(rm.rulesDir, "external")
+
+	dirEntries, err := os.ReadDir(externalDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read external rules directory: %w", err)
+	}
+
+	var verifications []Verification
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+
+		sourceDir := filepath.Join(externalDir, dirEntry.Name())
+		if !hasRuleFiles(sourceDir) {
+			continue
+		}
+
+		verification := Verification{
+			Source:    dirEntry.Name(),
+			Checksum:  "none",
+			Signature: "none",
+		}
+		data, err := os.ReadFile(filepath.Join(sourceDir, verificationFile))
+		if err == nil {
+			if err := json.Unmarshal(data, &verification); err != nil {
+				return nil, fmt.Errorf("failed to parse verification record for %s: %w", dirEntry.Name(), err)
+			}
+		}
+
+		// The record only holds while the installed files are the ones verified
+		if verification.Verified {
+			modified, err := modifiedFiles(sourceDir)
+			if os.IsNotExist(err) {
+				verification.Verified = false
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to check installed rules for %s: %w", dirEntry.Name(), err)
+			} else if len(modified) > 0 {
+				verification.Verified = false
+				verification.Modified = modified
+			}
+		}
+
+		verifications = append(verifications, verification)
+	}
+
+	sort.Slice(verifications, func(i, j int) bool {
+		return verifications[i].Source < verifications[j].Source
+	})
+
+	return verifications, nil
+}
+
+// CheckSources returns the verification status of every installed source in
+// rulesDir, refusing unverified ones when strict is set. Rules outside
+// rulesDir/external are reported as the "local" source, verified only while
+// they match the digests pinned for them by PinLocalRules.
+func CheckSources(rulesDir string, strict bool) ([]Verification, error) {
+	verifications, err := NewRuleManager(rulesDir).Verifications()
+	if err != nil {
+		return nil, err
+	}
+
+	if hasLocalRules(rulesDir) {
+		verification, err := localVerification(rulesDir)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, verification)
+	}
+
+	if strict {
+		if err := RequireVerified(verifications); err != nil {
+			return nil, err
+		}
+	}
+
+	return verifications, nil
+}
+
+// RequireVerified returns an error naming the first unverified source.
+func RequireVerified(verifications []Verification) error {
+	for _, verification := range verifications {
+		if len(verification.Modified) > 0 {
+			return fmt.Errorf("rule source %s was modified after it was verified (%s) and strict rule verification is enabled",
+				verification.Source, strings.Join(verification.Modified, ", "))
+		}
+		if !verification.Verified && verification.Source == localSource {
+			return fmt.Errorf("local rules are unverified and strict rule verification is enabled. Run 'hayanix rules pin' to trust them as they are")
+		}
+		if !verification.Verified {
+			return fmt.Errorf("rule source %s is %s and strict rule verification is enabled", verification.Source, verification.Status())
+		}
+	}
+	return nil
+}
+
+var errRuleFileFound = errors.New("rule file found")
+
+func hasRuleFiles(dir string) bool {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml") {
+			return errRuleFileFound
+		}
+		return nil
+	})
+	return err == errRuleFileFound
+}
+
+// hasLocalRules reports whether rulesDir holds rule files outside external/,
+// staged downloads and kept versions.
+func hasLocalRules(rulesDir string) bool {
+	err := walkLocalRules(rulesDir, func(path string) error {
+		return errRuleFileFound
+	})
+	return err == errRuleFileFound
+}
+
+// walkLocalRules calls fn for every rule file in rulesDir outside external/,
+// staged downloads and kept versions.
+func walkLocalRules(rulesDir string, fn func(path string) error) error {
+	externalDir := filepath.Join(rulesDir, "external")
+	configPath := filepath.Join(rulesDir, "sources.yml")
+
+	return filepath.Walk(rulesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == externalDir || (path != rulesDir && strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path != configPath && (strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml")) {
+			return fn(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,355 @@
+package rules
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKeyPair returns a minisign-format public key and a signer producing
+// minisign signatures for it.
+func minisignKeyPair(t *testing.T) (string, func(data []byte, prehashed bool) []byte) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	publicKey := "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...))
+
+	sign := func(data []byte, prehashed bool) []byte {
+		algorithm := []byte("Ed")
+		message := data
+		if prehashed {
+			algorithm = []byte("ED")
+			digest := blake2b.Sum512(data)
+			message = digest[:]
+		}
+
+		signature := ed25519.Sign(priv, message)
+		trustedComment := "timestamp:1700000000\tfile:master.tar.gz"
+		global := ed25519.Sign(priv, append(append([]byte{}, signature...), trustedComment...))
+
+		return []byte(fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+			base64.StdEncoding.EncodeToString(append(append(algorithm, keyID...), signature...)),
+			trustedComment,
+			base64.StdEncoding.EncodeToString(global)))
+	}
+
+	return publicKey, sign
+}
+
+func TestVerifyArchive(t *testing.T) {
+	data := []byte("rule archive contents")
+	digest := sha256.Sum256(data)
+	sum := hex.EncodeToString(digest[:])
+
+	publicKey, sign := minisignKeyPair(t)
+	_, otherSign := minisignKeyPair(t)
+
+	rawPub, rawPriv, _ := ed25519.GenerateKey(rand.Reader)
+	rawPublicKey := base64.StdEncoding.EncodeToString(rawPub)
+
+	tests := []struct {
+		name         string
+		source       RuleSource
+		checksumFile string
+		signature    []byte
+		wantVerified bool
+		wantStatus   string
+		wantErr      bool
+	}{
+		{
+			name:         "no verification configured",
+			source:       RuleSource{Name: "test"},
+			wantVerified: false,
+		},
+		{
+			name:         "pinned sha256",
+			source:       RuleSource{Name: "test", SHA256: sum},
+			wantVerified: true,
+		},
+		{
+			name:    "pinned sha256 mismatch",
+			source:  RuleSource{Name: "test", SHA256: hex.EncodeToString(make([]byte, 32))},
+			wantErr: true,
+		},
+		{
+			// A checksum from where the archive came from only shows it is intact
+			name:         "checksum file",
+			source:       RuleSource{Name: "test"},
+			checksumFile: "0000000000000000000000000000000000000000000000000000000000000000  other.tar.gz\n" + sum + "  master.tar.gz\n",
+			wantVerified: false,
+			wantStatus:   "checksum-only",
+		},
+		{
+			name:         "checksum file mismatch",
+			source:       RuleSource{Name: "test"},
+			checksumFile: hex.EncodeToString(make([]byte, 32)) + "  master.tar.gz\n",
+			wantErr:      true,
+		},
+		{
+			name:         "checksum file and pinned sha256",
+			source:       RuleSource{Name: "test", SHA256: sum},
+			checksumFile: sum + "  master.tar.gz\n",
+			wantVerified: true,
+			wantStatus:   "verified",
+		},
+		{
+			name:         "checksum file and signature",
+			source:       RuleSource{Name: "test", PublicKey: publicKey},
+			checksumFile: sum + "  master.tar.gz\n",
+			signature:    sign(data, false),
+			wantVerified: true,
+			wantStatus:   "verified",
+		},
+		{
+			name:         "checksum file without entry",
+			source:       RuleSource{Name: "test"},
+			checksumFile: sum + "  one.tar.gz\n" + sum + "  two.tar.gz\n",
+			wantErr:      true,
+		},
+		{
+			name:         "minisign signature",
+			source:       RuleSource{Name: "test", PublicKey: publicKey},
+			signature:    sign(data, false),
+			wantVerified: true,
+		},
+		{
+			name:         "prehashed minisign signature",
+			source:       RuleSource{Name: "test", PublicKey: publicKey},
+			signature:    sign(data, true),
+			wantVerified: true,
+		},
+		{
+			name:      "signature from another key",
+			source:    RuleSource{Name: "test", PublicKey: publicKey},
+			signature: otherSign(data, false),
+			wantErr:   true,
+		},
+		{
+			name:      "signature over different data",
+			source:    RuleSource{Name: "test", PublicKey: publicKey},
+			signature: sign([]byte("tampered"), false),
+			wantErr:   true,
+		},
+		{
+			name:    "missing signature",
+			source:  RuleSource{Name: "test", PublicKey: publicKey},
+			wantErr: true,
+		},
+		{
+			name:         "raw ed25519 signature",
+			source:       RuleSource{Name: "test", PublicKey: rawPublicKey},
+			signature:    []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(rawPriv, data))),
+			wantVerified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verification, err := VerifyArchive(tt.source, "master.tar.gz", data, tt.checksumFile, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if verification.Verified != tt.wantVerified {
+				t.Errorf("Expected verified = %v, got %v", tt.wantVerified, verification.Verified)
+			}
+			if tt.wantStatus != "" && verification.Status() != tt.wantStatus {
+				t.Errorf("Expected status %s, got %s", tt.wantStatus, verification.Status())
+			}
+			if verification.SHA256 != sum {
+				t.Errorf("Expected sha256 %s, got %s", sum, verification.SHA256)
+			}
+		})
+	}
+}
+
+func TestRuleManager_DownloadRecordsVerification(t *testing.T) {
+	archive := buildRuleArchive(t, map[string]string{
+		"repo-master/rules/linux/builtin/syslog/rule.yml": ruleContent("rule"),
+	})
+	publicKey, sign := minisignKeyPair(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch filepath.Ext(r.URL.Path) {
+		case ".minisig":
+			w.Write(sign(archive, true))
+		default:
+			w.Write(archive)
+		}
+	}))
+	defer server.Close()
+
+	rm := NewRuleManager(filepath.Join(t.TempDir(), "rules"))
+	if err := rm.Initialize(); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	rm.SetStrict(true)
+
+	config, err := rm.loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	config.Sources[0].URL = server.URL
+	if err := rm.saveConfig(config); err != nil {
+		t.Fatalf("saveConfig() error = %v", err)
+	}
+
+	// Strict mode refuses a source with nothing to verify against
+	if err := rm.DownloadRules("ChopChopGo"); err == nil {
+		t.Fatal("Expected strict mode to refuse an unverified source")
+	}
+
+	config.Sources[0].PublicKey = publicKey
+	if err := rm.saveConfig(config); err != nil {
+		t.Fatalf("saveConfig() error = %v", err)
+	}
+	if err := rm.DownloadRules("ChopChopGo"); err != nil {
+		t.Fatalf("DownloadRules() error = %v", err)
+	}
+
+	verifications, err := CheckSources(rm.rulesDir, true)
+	if err != nil {
+		t.Fatalf("CheckSources() error = %v", err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("Expected 1 verification record, got %d", len(verifications))
+	}
+	if !verifications[0].Verified || verifications[0].Signature != "valid" {
+		t.Errorf("Expected a verified signature, got %+v", verifications[0])
+	}
+	if verifications[0].KeyID != "0807060504030201" {
+		t.Errorf("Expected key ID 0807060504030201, got %s", verifications[0].KeyID)
+	}
+}
+
+func TestCheckSources(t *testing.T) {
+	rulesDir := filepath.Join(t.TempDir(), "rules")
+	sourceDir := filepath.Join(rulesDir, "external", "sigmahq")
+	writeRuleFile(t, sourceDir, "rule.yml", ruleContent("rule"))
+	if err := writeVerification(sourceDir, Verification{Source: "sigmahq", Checksum: "matched", Signature: "none", Verified: true}); err != nil {
+		t.Fatalf("writeVerification() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "sources.yml"), []byte("sources: []\n"), 0644); err != nil {
+		t.Fatalf("Failed to write sources.yml: %v", err)
+	}
+
+	verifications, err := CheckSources(rulesDir, true)
+	if err != nil {
+		t.Fatalf("CheckSources() error = %v", err)
+	}
+	if len(verifications) != 1 || verifications[0].Status() != "verified" {
+		t.Fatalf("Expected sigmahq to be verified, got %+v", verifications)
+	}
+
+	// Installed files are re-hashed against the digests recorded at install
+	writeRuleFile(t, sourceDir, "rule.yml", ruleContent("tampered"))
+	writeRuleFile(t, sourceDir, "extra.yml", ruleContent("extra"))
+	if _, err := CheckSources(rulesDir, true); err == nil {
+		t.Error("Expected strict mode to refuse a modified source")
+	}
+	verifications, err = CheckSources(rulesDir, false)
+	if err != nil {
+		t.Fatalf("CheckSources() error = %v", err)
+	}
+	if got := strings.Join(verifications[0].Modified, ","); got != "extra.yml,rule.yml" || verifications[0].Status() != "modified" {
+		t.Errorf("Expected extra.yml and rule.yml to be modified, got %q (%s)", got, verifications[0].Status())
+	}
+
+	// Rules outside external/ were never verified
+	localDir := t.TempDir()
+	writeRuleFile(t, filepath.Join(localDir, "linux", "syslog"), "local.yml", ruleContent("local"))
+	if _, err := CheckSources(localDir, true); err == nil {
+		t.Error("Expected strict mode to refuse local rules")
+	}
+	verifications, err = CheckSources(localDir, false)
+	if err != nil {
+		t.Fatalf("CheckSources() error = %v", err)
+	}
+	if len(verifications) != 1 || verifications[0].Source != "local" || verifications[0].Verified {
+		t.Errorf("Expected an unverified local source, got %+v", verifications)
+	}
+
+	// Pinned local rules are trusted until they change
+	if err := PinLocalRules(localDir); err != nil {
+		t.Fatalf("PinLocalRules() error = %v", err)
+	}
+	verifications, err = CheckSources(localDir, true)
+	if err != nil {
+		t.Fatalf("CheckSources() of pinned local rules error = %v", err)
+	}
+	if verifications[0].Status() != "verified" || verifications[0].Checksum != "pinned" {
+		t.Errorf("Expected pinned local rules to be verified, got %+v", verifications[0])
+	}
+	writeRuleFile(t, filepath.Join(localDir, "linux", "syslog"), "added.yml", ruleContent("added"))
+	if _, err := CheckSources(localDir, true); err == nil {
+		t.Error("Expected strict mode to refuse local rules added after pinning")
+	}
+	verifications, err = CheckSources(localDir, false)
+	if err != nil {
+		t.Fatalf("CheckSources() error = %v", err)
+	}
+	if got := strings.Join(verifications[0].Modified, ","); got != "linux/syslog/added.yml" {
+		t.Errorf("Expected linux/syslog/added.yml to be modified, got %q", got)
+	}
+}
+
+func TestRuleManager_PinSource(t *testing.T) {
+	rulesDir := filepath.Join(t.TempDir(), "rules")
+	sourceDir := filepath.Join(rulesDir, "external", "bundled")
+	writeRuleFile(t, sourceDir, "rule.yml", ruleContent("rule"))
+
+	if _, err := CheckSources(rulesDir, true); err == nil {
+		t.Fatal("Expected strict mode to refuse a source installed without a record")
+	}
+
+	rm := NewRuleManager(rulesDir)
+	if err := rm.PinSource("Bundled"); err != nil {
+		t.Fatalf("PinSource() error = %v", err)
+	}
+	verifications, err := CheckSources(rulesDir, true)
+	if err != nil {
+		t.Fatalf("CheckSources() of a pinned source error = %v", err)
+	}
+	if len(verifications) != 1 || verifications[0].Source != "bundled" || verifications[0].Checksum != "pinned" {
+		t.Errorf("Expected bundled to be pinned, got %+v", verifications)
+	}
+
+	writeRuleFile(t, sourceDir, "rule.yml", ruleContent("tampered"))
+	if _, err := CheckSources(rulesDir, true); err == nil {
+		t.Error("Expected strict mode to refuse a pinned source modified since")
+	}
+
+	if err := rm.PinSource("missing"); err == nil {
+		t.Error("Expected an error pinning a source that isn't installed")
+	}
+}
+
+func TestCheckSources_BundledRules(t *testing.T) {
+	// The rules shipped with hayanix are pinned, so strict mode accepts them
+	verifications, err := CheckSources(filepath.Join("..", "..", "rules"), true)
+	if err != nil {
+		t.Fatalf("CheckSources() of the bundled rules error = %v", err)
+	}
+	if len(verifications) == 0 {
+		t.Fatal("Expected the bundled rule sources")
+	}
+}
@@ -0,0 +1,261 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultKeepVersions is how many superseded downloads of a source are kept
+// for rollback when sources.yml doesn't say otherwise.
+const defaultKeepVersions = 3
+
+// RuleVersion is a previously installed copy of a rule source.
+type RuleVersion struct {
+	Version string
+	Path    string
+	Created time.Time
+}
+
+func (c RuleConfig) keepVersions() int {
+	if c.KeepVersions <= 0 {
+		return defaultKeepVersions
+	}
+	return c.KeepVersions
+}
+
+func versionStamp() string {
+	return time.Now().UTC().Format("20060102T150405.000000000Z")
+}
+
+func (rm *RuleManager) sourceDir(sourceName string) string {
+	return filepath.Join(rm.rulesDir, "external", strings.ToLower(sourceName))
+}
+
+func (rm *RuleManager) versionsDir(sourceName string) string {
+	return filepath.Join(rm.rulesDir, ".versions", strings.ToLower(sourceName))
+}
+
+func (rm *RuleManager) newStagingDir(sourceName string) (string, error) {
+	stagingRoot := filepath.Join(rm.rulesDir, ".staging")
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	dir, err := os.MkdirTemp(stagingRoot, strings.ToLower(sourceName)+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+// validateStagedRules loads every rule under dir the same way the engine does
+// and returns how many are usable.
+func validateStagedRules(dir string) (int, error) {
+	engine := &Engine{rules: make([]Rule, 0)}
+	if err := engine.loadRulesFromDir(dir); err != nil {
+		return 0, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	if len(engine.rules) == 0 {
+		return 0, fmt.Errorf("no valid rules found")
+	}
+
+	return len(engine.rules), nil
+}
+
+// Filesystem operations used to swap rule directories, replaceable in tests.
+var (
+	renameDir   = os.Rename
+	exchangeDir = exchangeDirs
+)
+
+// installStaged swaps a validated staging directory in place of the live
+// copy of a source, keeping the live copy as a version for rollback.
+func (rm *RuleManager) installStaged(sourceName, stagingDir string, keep int) error {
+	targetDir := rm.sourceDir(sourceName)
+	if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	if err := swapIn(stagingDir, targetDir); err != nil {
+		return fmt.Errorf("failed to install rules for %s: %w", sourceName, err)
+	}
+
+	// The staging directory now holds the rules that were live
+	if err := rm.keepVersion(sourceName, stagingDir); err != nil {
+		return err
+	}
+
+	return rm.pruneVersions(sourceName, keep)
+}
+
+// swapIn puts newDir in place of targetDir and leaves whatever targetDir held
+// at newDir. On Linux the two are exchanged in one step, so targetDir is never
+// missing; elsewhere there is a short window between two renames.
+func swapIn(newDir, targetDir string) error {
+	if _, err := os.Lstat(targetDir); os.IsNotExist(err) {
+		return renameDir(newDir, targetDir)
+	}
+
+	err := exchangeDir(newDir, targetDir)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	// Park the live copy, move the new one in, then hand the old one back
+	parkedDir := newDir + ".old"
+	if err := renameDir(targetDir, parkedDir); err != nil {
+		return err
+	}
+
+	if err := renameDir(newDir, targetDir); err != nil {
+		return restoreRetired(parkedDir, targetDir, err)
+	}
+
+	return renameDir(parkedDir, newDir)
+}
+
+// restoreRetired moves a retired live copy back after a failed swap and
+// returns the swap error, noting where the rules were left if the move fails.
+func restoreRetired(backupDir, targetDir string, swapErr error) error {
+	if backupDir == "" {
+		return swapErr
+	}
+
+	if err := renameDir(backupDir, targetDir); err != nil {
+		return fmt.Errorf("%w; previous rules left in %s: failed to restore them: %v", swapErr, backupDir, err)
+	}
+
+	return swapErr
+}
+
+// keepVersion moves rules that were swapped out of a source's live directory
+// into its versions directory.
+func (rm *RuleManager) keepVersion(sourceName, dir string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	// Initialize creates empty source directories; they aren't worth keeping
+	if err == nil && len(dirEntries) == 0 {
+		return os.Remove(dir)
+	}
+
+	versionsDir := rm.versionsDir(sourceName)
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create versions directory: %w", err)
+	}
+
+	if err := renameDir(dir, filepath.Join(versionsDir, versionStamp())); err != nil {
+		return fmt.Errorf("failed to keep previous rules for %s: %w", sourceName, err)
+	}
+
+	return nil
+}
+
+func (rm *RuleManager) pruneVersions(sourceName string, keep int) error {
+	versions, err := rm.ListVersions(sourceName)
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(versions); i++ {
+		if err := os.RemoveAll(versions[i].Path); err != nil {
+			return fmt.Errorf("failed to remove old version %s: %w", versions[i].Version, err)
+		}
+	}
+
+	return nil
+}
+
+// ListVersions returns the kept versions of a source, newest first.
+func (rm *RuleManager) ListVersions(sourceName string) ([]RuleVersion, error) {
+	versionsDir := rm.versionsDir(sourceName)
+
+	dirEntries, err := os.ReadDir(versionsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions directory: %w", err)
+	}
+
+	var versions []RuleVersion
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+
+		version := RuleVersion{
+			Version: dirEntry.Name(),
+			Path:    filepath.Join(versionsDir, dirEntry.Name()),
+		}
+		if info, err := dirEntry.Info(); err == nil {
+			version.Created = info.ModTime()
+		}
+		versions = append(versions, version)
+	}
+
+	// Version names are UTC timestamps, so they sort chronologically
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version > versions[j].Version
+	})
+
+	return versions, nil
+}
+
+// Rollback restores a kept version of a source. An empty version restores the
+// most recent one. The rules it replaces are kept as a version in turn.
+func (rm *RuleManager) Rollback(sourceName, version string) (string, error) {
+	versions, err := rm.ListVersions(sourceName)
+	if err != nil {
+		return "", err
+	}
+
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no previous versions of %s to roll back to", sourceName)
+	}
+
+	var chosen *RuleVersion
+	if version == "" {
+		chosen = &versions[0]
+	} else {
+		for i := range versions {
+			if versions[i].Version == version {
+				chosen = &versions[i]
+				break
+			}
+		}
+	}
+
+	if chosen == nil {
+		return "", fmt.Errorf("version %s of %s not found", version, sourceName)
+	}
+
+	if _, err := validateStagedRules(chosen.Path); err != nil {
+		return "", fmt.Errorf("version %s of %s failed validation: %w", chosen.Version, sourceName, err)
+	}
+
+	targetDir := rm.sourceDir(sourceName)
+	if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	if err := swapIn(chosen.Path, targetDir); err != nil {
+		return "", fmt.Errorf("failed to restore version %s of %s: %w", chosen.Version, sourceName, err)
+	}
+
+	// The chosen version's directory now holds the rules it replaced
+	if err := rm.keepVersion(sourceName, chosen.Path); err != nil {
+		return "", err
+	}
+
+	return chosen.Version, nil
+}
@@ -11,6 +11,8 @@ import (
 var version = "0.1.0"
 
 func main() {
+	cli.Version = version
+
 	var cliArgs cli.Args
 	ctx := kong.Parse(&cliArgs,
 		kong.Name("hayanix"),